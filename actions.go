@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/spy16/ticktock/ticker"
+	"github.com/spy16/ticktock/utils"
+)
+
+// Actions of the client action logs.
+const (
+	actionConnect     = "connect"
+	actionSubscribe   = "subscribe"
+	actionUnsubscribe = "unsubscribe"
+	actionDisconnect  = "disconnect"
+)
+
+// clientAction is an entry of a client action log, e.g.:
+//
+//	{"t": "2024-03-01T09:15:00.120Z", "c": "u42", "a": "subscribe", "m": 1, "i": [408065]}
+//
+// Subscriptions without a mode are in LTP mode.
+type clientAction struct {
+	Time        time.Time   `json:"t"`
+	Client      string      `json:"c"`
+	Action      string      `json:"a"`
+	Mode        ticker.Mode `json:"m,omitempty"`
+	Instruments []int32     `json:"i,omitempty"`
+	Symbols     []string    `json:"s,omitempty"`
+	Groups      []string    `json:"g,omitempty"`
+
+	due time.Time
+}
+
+// actionStats counts the replayed actions.
+type actionStats struct {
+	actions atomic.Int64 // number of actions performed.
+	skipped atomic.Int64 // number of actions that could not be performed.
+	lag     *utils.Histogram
+}
+
+// actionSummary is the summary of a replayed action log in the report.
+type actionSummary struct {
+	Actions int64 `json:"actions"`
+	Skipped int64 `json:"skipped"`
+	LagP50  int64 `json:"lag_p50_us"`
+	LagP99  int64 `json:"lag_p99_us"`
+	LagMax  int64 `json:"lag_max_us"`
+}
+
+func (as *actionStats) summarize() *actionSummary {
+	lag := as.lag.Snapshot()
+	return &actionSummary{
+		Actions: as.actions.Load(),
+		Skipped: as.skipped.Load(),
+		LagP50:  lag.ValueAt(50),
+		LagP99:  lag.ValueAt(99),
+		LagMax:  lag.Max,
+	}
+}
+
+// actionReplayer replays a client action log against the server.
+type actionReplayer struct {
+	Addr       string
+	Token      string
+	Speed      float64 // 0 replays as fast as possible.
+	ReplayGaps bool
+
+	stats   *loadStats
+	actions *actionStats
+	gaps    *gapStats
+	latency *clientLatency
+
+	wg      sync.WaitGroup
+	clients map[string]chan clientAction
+}
+
+// Run replays the action log until it is exhausted or the context is
+// canceled. Clients still connected at the end of the log are disconnected.
+func (ar *actionReplayer) Run(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ar.clients = map[string]chan clientAction{}
+	defer func() {
+		for _, actions := range ar.clients {
+			close(actions)
+		}
+		ar.wg.Wait()
+	}()
+
+	ar.stats.startedAt = time.Now()
+
+	var first time.Time
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+
+		var act clientAction
+		if err := json.Unmarshal(sc.Bytes(), &act); err != nil {
+			return fmt.Errorf("line %d: invalid action: %w", line, err)
+		} else if act.Client == "" {
+			return fmt.Errorf("line %d: action without a client", line)
+		}
+
+		switch act.Action {
+		case actionConnect, actionSubscribe, actionUnsubscribe, actionDisconnect:
+		default:
+			return fmt.Errorf("line %d: unknown action '%s'", line, act.Action)
+		}
+
+		if first.IsZero() {
+			first = act.Time
+		}
+		act.due = time.Now()
+		if ar.Speed > 0 {
+			offset := time.Duration(float64(act.Time.Sub(first)) / ar.Speed)
+			act.due = ar.stats.startedAt.Add(offset)
+			if !sleep(ctx, time.Until(act.due)) {
+				return nil
+			}
+		}
+
+		actions, found := ar.clients[act.Client]
+		if !found {
+			actions = make(chan clientAction, 1024)
+			ar.clients[act.Client] = actions
+
+			ar.wg.Add(1)
+			go func(id string) {
+				defer ar.wg.Done()
+				ar.runClient(ctx, id, actions)
+			}(act.Client)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case actions <- act:
+		}
+	}
+	return sc.Err()
+}
+
+// runClient performs the actions of a client in order.
+func (ar *actionReplayer) runClient(ctx context.Context, id string, actions <-chan clientAction) {
+	tracker := &seqTracker{stats: ar.gaps, last: make(map[int32]uint64)}
+
+	var cc *clientConn
+	var done chan struct{}
+	disconnect := func() {
+		_ = cc.write(ws.OpClose, nil)
+		_ = cc.Close()
+		<-done
+		cc = nil
+		ar.stats.active.Add(-1)
+	}
+	defer func() {
+		if cc != nil {
+			disconnect()
+		}
+	}()
+
+	for act := range actions {
+		if ctx.Err() != nil {
+			return
+		}
+
+		// the server closed the connection.
+		if cc != nil && isClosed(done) {
+			ar.stats.drops.Add(1)
+			cc = nil
+			ar.stats.active.Add(-1)
+		}
+
+		ar.actions.lag.Record(time.Since(act.due))
+		switch {
+		case act.Action == actionConnect && cc == nil:
+			conn, err := dialServer(ctx, ar.Addr, ar.Token)
+			if err != nil {
+				log.Printf("client %s failed to connect: %v", id, err)
+				ar.stats.failures.Add(1)
+				ar.actions.skipped.Add(1)
+				continue
+			}
+			ar.stats.connects.Add(1)
+			ar.stats.active.Add(1)
+
+			cc, done = conn, make(chan struct{})
+			go func(cc *clientConn, done chan struct{}) {
+				defer close(done)
+				cc.readTicks(tracker, ar.latency, ar.ReplayGaps, func(msg []byte) { ar.stats.countReply(msg) })
+			}(cc, done)
+
+		case act.Action == actionDisconnect && cc != nil:
+			disconnect()
+
+		case (act.Action == actionSubscribe || act.Action == actionUnsubscribe) && cc != nil:
+			req := ticker.Request{
+				Mode:        act.Mode,
+				Instruments: act.Instruments,
+				Symbols:     act.Symbols,
+				Groups:      act.Groups,
+			}
+			if act.Action == actionUnsubscribe {
+				req.Mode = ticker.ModeNone
+			} else if req.Mode == ticker.ModeNone {
+				req.Mode = ticker.ModeLTP
+			}
+			tracker.forget(act.Instruments...)
+
+			ar.stats.requests.Add(1)
+			if err := cc.write(ws.OpText, jsonStr(req)); err != nil {
+				ar.actions.skipped.Add(1)
+				continue
+			}
+
+		default:
+			// e.g., subscribing while disconnected.
+			ar.actions.skipped.Add(1)
+			continue
+		}
+		ar.actions.actions.Add(1)
+	}
+}
+
+func isClosed(done chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spy16/ticktock/utils"
+)
+
+// writeActions writes the action log lines to a file.
+func writeActions(t *testing.T, lines ...string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "actions.jsonl")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newActionReplayer(addr string, speed float64) *actionReplayer {
+	return &actionReplayer{
+		Addr:    addr,
+		Speed:   speed,
+		stats:   &loadStats{},
+		actions: &actionStats{lag: utils.NewHistogram()},
+		gaps:    &gapStats{},
+		latency: newClientLatency(),
+	}
+}
+
+func TestActionReplayerInvalid(t *testing.T) {
+	// subscriptions of disconnected clients are skipped without a server.
+	const valid = `{"t": "2024-03-01T09:15:00Z", "c": "u1", "a": "subscribe", "i": [1]}`
+
+	tests := []struct {
+		name string
+		line string
+		err  string
+	}{
+		{"JSON", `{"c": "u1", "a": `, "line 3: invalid action"},
+		{"NoClient", `{"t": "2024-03-01T09:15:00Z", "a": "connect"}`, "line 3: action without a client"},
+		{"UnknownAction", `{"t": "2024-03-01T09:15:00Z", "c": "u1", "a": "reconnect"}`, "line 3: unknown action 'reconnect'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ar := newActionReplayer("ws://127.0.0.1:1", 0)
+			err := ar.Run(context.Background(), writeActions(t, valid, "", tt.line))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Run() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestActionReplayerSpeed(t *testing.T) {
+	ar := newActionReplayer("ws://127.0.0.1:1", 10)
+	path := writeActions(t,
+		`{"t": "2024-03-01T09:15:00Z", "c": "u1", "a": "subscribe", "i": [1]}`,
+		`{"t": "2024-03-01T09:15:00.5Z", "c": "u2", "a": "unsubscribe", "i": [1]}`,
+		`{"t": "2024-03-01T09:15:01Z", "c": "u1", "a": "disconnect"}`,
+	)
+
+	// the log spans a second, replayed in 100ms at 10x.
+	start := time.Now()
+	if err := ar.Run(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 900*time.Millisecond {
+		t.Fatalf("replayed in %v, want 100ms", elapsed)
+	}
+
+	if got := ar.actions.summarize(); got.Actions != 0 || got.Skipped != 3 {
+		t.Fatalf("%d actions and %d skipped, want 0 and 3", got.Actions, got.Skipped)
+	}
+	if lags := ar.actions.lag.Snapshot().Count; lags != 3 {
+		t.Fatalf("%d lags recorded, want 3", lags)
+	}
+}
+
+func TestActionReplayer(t *testing.T) {
+	_, addr := serveBroker(t, "gorillav1")
+
+	ar := newActionReplayer(addr, 0)
+	path := writeActions(t,
+		`{"t": "2024-03-01T09:15:00Z", "c": "u1", "a": "connect"}`,
+		`{"t": "2024-03-01T09:15:00Z", "c": "u1", "a": "subscribe", "m": 3, "i": [1, 2]}`,
+		`{"t": "2024-03-01T09:15:00Z", "c": "u2", "a": "subscribe", "i": [1]}`,
+		`{"t": "2024-03-01T09:15:00Z", "c": "u2", "a": "connect"}`,
+		`{"t": "2024-03-01T09:15:00Z", "c": "u2", "a": "subscribe", "g": ["G"]}`,
+		`{"t": "2024-03-01T09:15:00Z", "c": "u1", "a": "unsubscribe", "i": [1]}`,
+		`{"t": "2024-03-01T09:15:00Z", "c": "u1", "a": "disconnect"}`,
+		`{"t": "2024-03-01T09:15:00Z", "c": "u1", "a": "disconnect"}`,
+		`{"t": "2024-03-01T09:15:00Z", "c": "u1", "a": "connect"}`,
+	)
+	if err := ar.Run(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+
+	// the subscription and the disconnection of disconnected clients are
+	// skipped, and the clients still connected are disconnected.
+	if got := ar.actions.summarize(); got.Actions != 7 || got.Skipped != 2 {
+		t.Errorf("%d actions and %d skipped, want 7 and 2", got.Actions, got.Skipped)
+	}
+	if connects, requests := ar.stats.connects.Load(), ar.stats.requests.Load(); connects != 3 || requests != 3 {
+		t.Errorf("%d connections and %d requests, want 3 and 3", connects, requests)
+	}
+	if active, failures := ar.stats.active.Load(), ar.stats.failures.Load(); active != 0 || failures != 0 {
+		t.Errorf("%d clients active and %d failures, want none", active, failures)
+	}
+}
+
+func TestActionReplayerCancel(t *testing.T) {
+	ar := newActionReplayer("ws://127.0.0.1:1", 1)
+	path := writeActions(t,
+		`{"t": "2024-03-01T09:15:00Z", "c": "u1", "a": "subscribe", "i": [1]}`,
+		`{"t": "2024-03-01T10:15:00Z", "c": "u1", "a": "subscribe", "i": [2]}`,
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := ar.Run(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Run() returned %v after the context was canceled", elapsed)
+	}
+	if skipped := ar.actions.skipped.Load(); skipped != 1 {
+		t.Fatalf("%d actions skipped, want 1", skipped)
+	}
+}
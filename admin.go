@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spy16/ticktock/ticker"
+)
+
+const (
+	defaultSearchLimit = 50
+	defaultMemoryTop   = 10
+)
+
+// registerAdmin registers the admin API on the mux of the debug server.
+func registerAdmin(mux *http.ServeMux, master *ticker.Master) {
+	// GET /admin/instruments?q=INFY&exchange=NSE&segment=NSE&limit=10
+	// searches the master by trading symbol, and ?token=408065 looks up a
+	// single instrument.
+	mux.HandleFunc("/admin/instruments", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		params := r.URL.Query()
+
+		if v := params.Get("token"); v != "" {
+			token, err := strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				http.Error(w, "invalid token", http.StatusBadRequest)
+				return
+			}
+
+			in, found := master.Get(int32(token))
+			if !found {
+				http.Error(w, "unknown instrument", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, in)
+			return
+		}
+
+		limit := defaultSearchLimit
+		if v := params.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		found := master.Search(params.Get("q"), params.Get("exchange"), params.Get("segment"), limit)
+		if found == nil {
+			found = []ticker.Instrument{}
+		}
+		writeJSON(w, found)
+	})
+
+	// POST /admin/instruments/reload reloads the master from its file.
+	mux.HandleFunc("/admin/instruments/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := master.Reload(); err != nil {
+			log.Error().Err(err).Msg("failed to reload instrument master")
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		log.Info().Int("instruments", master.Len()).Msg("reloaded instrument master")
+		writeJSON(w, map[string]int{"instruments": master.Len()})
+	})
+}
+
+// registerMemoryAdmin registers the memory report on the mux of the debug
+// server.
+func registerMemoryAdmin(mux *http.ServeMux, mg *memoryGuard) {
+	// GET /admin/memory?top=10 reports the estimated memory of the
+	// connections against the budget, with the top heaviest clients.
+	mux.HandleFunc("/admin/memory", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		top := defaultMemoryTop
+		if v := r.URL.Query().Get("top"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid top", http.StatusBadRequest)
+				return
+			}
+			top = n
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		status, err := mg.Status(ctx, top)
+		if err != nil {
+			http.Error(w, "broker did not respond", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, status)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn().Err(err).Msg("failed to write response")
+	}
+}
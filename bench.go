@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spy16/ticktock/ticker"
+	"github.com/spy16/ticktock/utils"
+)
+
+// benchServers are the brokers compared by default.
+var benchServers = []string{"gorillav1", "gorillav2", "gorillav3", "gobwasv1", "gobwasv2", "netpollv1", "quickwsv1"}
+
+func cmdBench() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Benchmarks the brokers in-process with the native client",
+	}
+
+	var cfg benchConfig
+	var mode, scenarioFile, outputFile, outputFormat string
+	cmd.Flags().StringSliceVarP(&cfg.Servers, "servers", "s", benchServers, "Server models to benchmark, in order")
+	cmd.Flags().IntVarP(&cfg.Clients, "clients", "c", 1000, "Number of clients to connect")
+	cmd.Flags().IntVarP(&cfg.Instruments, "instruments", "i", 100, "Number of instruments to stream")
+	cmd.Flags().IntVar(&cfg.Subscriptions, "subscriptions", 3, "Number of instruments each client subscribes to")
+	cmd.Flags().StringVar(&mode, "mode", "ltp", "Subscription mode of the clients (ltp, quote or full)")
+	cmd.Flags().StringVar(&scenarioFile, "scenario", "", "Drive the brokers with the clients of this scenario file instead (its addr and duration are ignored)")
+	cmd.Flags().DurationVarP(&cfg.TickRate, "tickrate", "t", 100*time.Millisecond, "Tick Rate")
+	cmd.Flags().IntVar(&cfg.TradeCount, "trade-count", 5000, "Number of trades to generate per tick")
+	cmd.Flags().DurationVar(&cfg.Warmup, "warmup", 5*time.Second, "Time given to the clients to connect and subscribe before measuring")
+	cmd.Flags().DurationVarP(&cfg.Duration, "duration", "d", 30*time.Second, "Measurement time per server")
+	cmd.Flags().IntVar(&cfg.ClientQueue, "client-queue", 0, "Number of packets buffered per client (0 for the broker default)")
+	cmd.Flags().BoolVar(&cfg.PooledBuffers, "pooled-buffers", false, "Run the gobwas servers with pooled buffers (see ticktock serve --pooled-buffers)")
+	cmd.Flags().BoolVar(&cfg.PreparedFrames, "prepared-frames", false, "Frame the packets once for all the subscribers in the gobwas, gorillav1/v2 and netpoll servers (see ticktock serve --prepared-frames)")
+	cmd.Flags().IntVar(&cfg.MaxWriteBytes, "max-write-bytes", 0, "Maximum size of a vectored write of the gobwas and netpoll servers (0 for the default, see ticktock serve --max-write-bytes)")
+	cmd.Flags().BoolVar(&cfg.Trace, "trace", false, "Trace the packets to break the latency down by stage (see ticktock serve --trace)")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "Write the results to this file instead of stdout")
+	cmd.Flags().StringVar(&outputFormat, "format", "", "Format of the results, markdown or json (defaults to the extension of the file, markdown otherwise)")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		format, err := benchFormat(outputFile, outputFormat)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid output format")
+		}
+		for _, name := range cfg.Servers {
+			if !isBenchServer(name) {
+				log.Fatal().Str("server", name).Msg("unknown server type")
+			}
+		}
+
+		sc := &scenario{
+			Clients:       cfg.Clients,
+			Instruments:   cfg.Instruments,
+			Subscriptions: cfg.Subscriptions,
+			Modes:         map[string]float64{mode: 1},
+		}
+		if scenarioFile != "" {
+			sc, err = loadScenario(scenarioFile)
+			if err != nil {
+				log.Fatal().Err(err).Msg("failed to load scenario")
+			}
+			cfg.Scenario = scenarioFile
+			cfg.Clients, cfg.Instruments, cfg.Subscriptions = sc.Clients, sc.Instruments, sc.Subscriptions
+		} else if _, found := scenarioModes[mode]; !found {
+			log.Fatal().Str("mode", mode).Msg("unknown mode (expecting ltp, quote or full)")
+		}
+		sc.Duration = 0
+		cfg.Modes = sc.Modes
+		cfg.GoVersion = runtime.Version()
+		cfg.GOMAXPROCS = runtime.GOMAXPROCS(0)
+
+		if v, err := utils.SetMaxFdLimit(); err != nil {
+			log.Warn().Err(err).Msg("failed to set max open files")
+		} else if v < uint64(2*cfg.Clients) {
+			log.Warn().Uint64("max_open_files", v).Msg("max open files may be too low for the clients")
+		}
+
+		report := benchReport{Config: cfg}
+		for _, name := range cfg.Servers {
+			res, err := runBench(cmd.Context(), name, cfg, sc)
+			if err != nil {
+				log.Error().Err(err).Str("server", name).Msg("benchmark failed")
+				continue
+			}
+			log.Info().Str("server", name).
+				Float64("messages_per_s", res.MessageRate).
+				Str("latency", res.Latency.String()).
+				Msg("benchmark done")
+			report.Results = append(report.Results, *res)
+
+			if cmd.Context().Err() != nil {
+				break
+			}
+		}
+
+		var w io.Writer = os.Stdout
+		if outputFile != "" {
+			f, err := os.Create(outputFile)
+			if err != nil {
+				log.Fatal().Err(err).Msg("failed to create output file")
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := report.write(w, format); err != nil {
+			log.Error().Err(err).Msg("failed to write results")
+		}
+	}
+
+	return cmd
+}
+
+// benchConfig is the configuration of a benchmark, included in the JSON
+// results so that they can be reproduced.
+type benchConfig struct {
+	Servers        []string           `json:"servers"`
+	Clients        int                `json:"clients"`
+	Instruments    int                `json:"instruments"`
+	Subscriptions  int                `json:"subscriptions"`
+	Modes          map[string]float64 `json:"modes"`
+	Scenario       string             `json:"scenario,omitempty"`
+	TickRate       time.Duration      `json:"tick_rate_ns"`
+	TradeCount     int                `json:"trade_count"`
+	Warmup         time.Duration      `json:"warmup_ns"`
+	Duration       time.Duration      `json:"duration_ns"`
+	Trace          bool               `json:"trace"`
+	ClientQueue    int                `json:"client_queue,omitempty"`
+	PooledBuffers  bool               `json:"pooled_buffers"`
+	PreparedFrames bool               `json:"prepared_frames"`
+	MaxWriteBytes  int                `json:"max_write_bytes,omitempty"`
+	GoVersion      string             `json:"go_version"`
+	GOMAXPROCS     int                `json:"gomaxprocs"`
+}
+
+// benchResult holds the measurements of a broker. Rates are measured after
+// the warmup, the memory and goroutines at the end of the run.
+type benchResult struct {
+	Server      string                    `json:"server"`
+	Clients     int64                     `json:"clients"` // connected at the end.
+	Failures    int64                     `json:"failures"`
+	Drops       int64                     `json:"drops"`
+	Messages    int64                     `json:"messages"`
+	MessageRate float64                   `json:"messages_per_s"`
+	Throughput  float64                   `json:"mb_per_s"`
+	Latency     latencySummary            `json:"latency"`
+	Stages      map[string]latencySummary `json:"stages,omitempty"`
+	Gaps        int64                     `json:"gaps"`
+	Missed      int64                     `json:"missed"`
+
+	// FramesPerWrite is the mean number of frames of the vectored writes of
+	// the brokers that count them.
+	FramesPerWrite float64 `json:"frames_per_write,omitempty"`
+
+	// the allocations are of the whole process, i.e., including the clients
+	// and the tick source. The goroutines exclude the 2 of each client.
+	AllocRate  float64 `json:"allocs_per_s"`
+	AllocBytes float64 `json:"alloc_mb_per_s"`
+	Goroutines int     `json:"goroutines"`
+
+	// HeapDelta is the growth of the live heap over the run, measured after
+	// a forced GC on both ends, so that the leftovers of the previous
+	// brokers are excluded. It includes the clients and the tick source.
+	HeapDelta float64 `json:"heap_delta_mb"`
+}
+
+type benchReport struct {
+	Config  benchConfig   `json:"config"`
+	Results []benchResult `json:"results"`
+}
+
+// runBench starts the broker on a loopback port, connects the clients of
+// the scenario and measures the broker for the duration of the benchmark.
+func runBench(ctx context.Context, name string, cfg benchConfig, sc *scenario) (*benchResult, error) {
+	// start from a clean slate, the previous brokers may still be winding
+	// down.
+	runtime.GC()
+	debug.FreeOSMemory()
+	baseline := runtime.NumGoroutine()
+	var baseMem runtime.MemStats
+	runtime.ReadMemStats(&baseMem)
+
+	addr, err := freeAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	srvCtx, stopServer := context.WithCancel(ctx)
+	defer stopServer()
+
+	srv := setupBroker(srvCtx, name, "", brokerOptions{
+		ClientQueue:    cfg.ClientQueue,
+		PooledBuffers:  cfg.PooledBuffers,
+		PreparedFrames: cfg.PreparedFrames,
+		MaxWriteBytes:  cfg.MaxWriteBytes,
+	})
+	seq := ticker.NewSequencer(srv, 100)
+	srv.Topics().History = seq
+	srv.Topics().Trace = cfg.Trace
+
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(srvCtx, addr) }()
+	if err := waitListening(srvCtx, addr, served); err != nil {
+		return nil, err
+	}
+
+	ts := &ticker.Ticker{TickRate: cfg.TickRate, TradeCount: cfg.TradeCount}
+	go func() { _ = ts.Run(srvCtx, seq) }()
+
+	log.Info().Str("server", name).Str("addr", addr).Int("clients", cfg.Clients).Msg("benchmarking server")
+
+	clientCtx, stopClients := context.WithCancel(ctx)
+	defer stopClients()
+
+	stats := &loadStats{}
+	gaps := &gapStats{}
+	latency := newClientLatency()
+	bc := *sc
+	bc.Addr = "ws://" + addr
+	clientsDone := make(chan struct{})
+	go func() {
+		defer close(clientsDone)
+		runScenario(clientCtx, &bc, stats, gaps, latency)
+	}()
+
+	if !sleep(ctx, cfg.Warmup) {
+		return nil, ctx.Err()
+	}
+	var startMem, endMem runtime.MemStats
+	runtime.ReadMemStats(&startMem)
+	startLatency := latency.total.Snapshot()
+	startBytes := latency.bytes.Load()
+	startGaps, startMissed := gaps.gaps.Load(), gaps.missed.Load()
+	wr, countsWrites := srv.(interface{ WriteStats() ticker.WriteStats })
+	var startWrites ticker.WriteStats
+	if countsWrites {
+		startWrites = wr.WriteStats()
+	}
+	startedAt := time.Now()
+
+	if !sleep(ctx, cfg.Duration) {
+		return nil, ctx.Err()
+	}
+	elapsed := time.Since(startedAt).Seconds()
+	runtime.ReadMemStats(&endMem)
+	lat := latency.total.Snapshot().Sub(startLatency)
+	active := stats.active.Load()
+
+	// the live heap is measured with the broker and the clients still
+	// running.
+	var liveMem runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&liveMem)
+
+	res := &benchResult{
+		Server:      name,
+		Clients:     active,
+		Failures:    stats.failures.Load(),
+		Drops:       stats.drops.Load(),
+		Messages:    lat.Count,
+		MessageRate: float64(lat.Count) / elapsed,
+		Throughput:  float64(latency.bytes.Load()-startBytes) / elapsed / (1 << 20),
+		Latency:     summarize(lat),
+		Gaps:        gaps.gaps.Load() - startGaps,
+		Missed:      gaps.missed.Load() - startMissed,
+		AllocRate:   float64(endMem.Mallocs-startMem.Mallocs) / elapsed,
+		AllocBytes:  float64(endMem.TotalAlloc-startMem.TotalAlloc) / elapsed / (1 << 20),
+		Goroutines:  max(runtime.NumGoroutine()-baseline-2*int(active), 0),
+		HeapDelta:   float64(int64(liveMem.HeapAlloc)-int64(baseMem.HeapAlloc)) / (1 << 20),
+	}
+	if countsWrites {
+		end := wr.WriteStats()
+		if writes := end.Writes - startWrites.Writes; writes > 0 {
+			res.FramesPerWrite = float64(end.Frames-startWrites.Frames) / float64(writes)
+		}
+	}
+	if cfg.Trace {
+		// the stages are not reset after the warmup, they include it.
+		res.Stages = latency.summarizeStages()
+	}
+
+	stopClients()
+	<-clientsDone
+	stopServer()
+	if err := <-served; err != nil && !errors.Is(err, context.Canceled) {
+		log.Warn().Err(err).Str("server", name).Msg("server exited")
+	}
+	return res, nil
+}
+
+// freeAddr returns a free loopback address to serve on.
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+// waitListening waits until the server accepts connections on addr.
+func waitListening(ctx context.Context, addr string, served <-chan error) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-served:
+			return fmt.Errorf("server exited: %w", err)
+		default:
+		}
+
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			return conn.Close()
+		}
+		if !sleep(ctx, 10*time.Millisecond) {
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("server is not listening on %s", addr)
+}
+
+func isBenchServer(name string) bool {
+	for _, s := range benchServers {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// benchFormat picks the format of the results, based on the extension of
+// the output file if format is empty.
+func benchFormat(path, format string) (string, error) {
+	if format == "" {
+		format = "markdown"
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			format = "json"
+		}
+	}
+	if format != "markdown" && format != "json" {
+		return "", fmt.Errorf("unknown format '%s' (expecting markdown or json)", format)
+	}
+	return format, nil
+}
+
+// write writes the results as JSON or as a Markdown table.
+func (br benchReport) write(w io.Writer, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(br)
+	}
+
+	us := func(v int64) time.Duration { return time.Duration(v) * time.Microsecond }
+	cfg := br.Config
+	fmt.Fprintf(w, "%d clients x %d subscriptions of %d instruments (modes %v), tick rate %s, %d trades per tick, %s after a %s warmup (%s, GOMAXPROCS=%d)",
+		cfg.Clients, cfg.Subscriptions, cfg.Instruments, cfg.Modes, cfg.TickRate, cfg.TradeCount, cfg.Duration, cfg.Warmup, cfg.GoVersion, cfg.GOMAXPROCS)
+	if cfg.ClientQueue > 0 {
+		fmt.Fprintf(w, ", client queue %d", cfg.ClientQueue)
+	}
+	if cfg.PooledBuffers {
+		fmt.Fprint(w, ", pooled buffers")
+	}
+	if cfg.PreparedFrames {
+		fmt.Fprint(w, ", prepared frames")
+	}
+	if cfg.MaxWriteBytes > 0 {
+		fmt.Fprintf(w, ", max write bytes %d", cfg.MaxWriteBytes)
+	}
+	fmt.Fprint(w, "\n\n")
+	fmt.Fprintln(w, "| server | clients | msgs/s | MB/s | p50 | p99 | p999 | max | gaps | allocs/s | alloc MB/s | goroutines | heap delta MB (after GC) | frames/write |")
+	fmt.Fprintln(w, "|--------|---------|--------|------|-----|-----|------|-----|------|----------|------------|------------|-------------------------|--------------|")
+	for _, r := range br.Results {
+		framesPerWrite := "-"
+		if r.FramesPerWrite > 0 {
+			framesPerWrite = fmt.Sprintf("%.2f", r.FramesPerWrite)
+		}
+		fmt.Fprintf(w, "| %s | %d | %.0f | %.2f | %s | %s | %s | %s | %d | %.0f | %.1f | %d | %.1f | %s |\n",
+			r.Server, r.Clients, r.MessageRate, r.Throughput,
+			us(r.Latency.P50), us(r.Latency.P99), us(r.Latency.P999), us(r.Latency.Max),
+			r.Gaps, r.AllocRate, r.AllocBytes, r.Goroutines, r.HeapDelta, framesPerWrite)
+	}
+	return nil
+}
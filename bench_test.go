@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBenchFormat(t *testing.T) {
+	tests := []struct {
+		path   string
+		format string
+		want   string
+		err    bool
+	}{
+		{"", "", "markdown", false},
+		{"results.md", "", "markdown", false},
+		{"results.JSON", "", "json", false},
+		{"results.json", "markdown", "markdown", false},
+		{"", "csv", "", true},
+	}
+	for _, tt := range tests {
+		got, err := benchFormat(tt.path, tt.format)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("benchFormat(%q, %q) = %q, %v, want %q", tt.path, tt.format, got, err, tt.want)
+		}
+	}
+}
+
+func TestBenchReportWrite(t *testing.T) {
+	report := benchReport{
+		Config: benchConfig{Servers: []string{"gorillav1"}, Clients: 10, PooledBuffers: true},
+		Results: []benchResult{
+			{Server: "gorillav1", Clients: 10, MessageRate: 1000, HeapDelta: 1.5},
+			{Server: "gobwasv2", Clients: 10, HeapDelta: -0.25, FramesPerWrite: 2.5},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := report.write(&buf, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded benchReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, report) {
+		t.Fatalf("decoded %+v, want %+v", decoded, report)
+	}
+
+	buf.Reset()
+	if err := report.write(&buf, "markdown"); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		", pooled buffers\n",
+		"heap delta MB (after GC)",
+		"| gorillav1 | 10 | 1000 |",
+		"| 1.5 | - |\n",
+		"| -0.2 | 2.50 |\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("markdown results miss %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	cfg := benchConfig{
+		Clients:       2,
+		Instruments:   10,
+		Subscriptions: 3,
+		TickRate:      10 * time.Millisecond,
+		TradeCount:    20,
+		Warmup:        200 * time.Millisecond,
+		Duration:      300 * time.Millisecond,
+	}
+	sc := &scenario{
+		Clients:       cfg.Clients,
+		Instruments:   cfg.Instruments,
+		Subscriptions: cfg.Subscriptions,
+		Modes:         map[string]float64{"ltp": 1},
+	}
+
+	for _, name := range []string{"gorillav1", "gobwasv2"} {
+		res, err := runBench(context.Background(), name, cfg, sc)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if res.Server != name || res.Clients != 2 || res.Failures != 0 {
+			t.Errorf("%s: %d clients and %d failures, want 2 and none", name, res.Clients, res.Failures)
+		}
+		if res.Messages == 0 || res.MessageRate <= 0 || res.Throughput <= 0 {
+			t.Errorf("%s: %d messages at %.0f/s and %.2f MB/s, want some", name, res.Messages, res.MessageRate, res.Throughput)
+		}
+		if res.AllocRate <= 0 {
+			t.Errorf("%s: allocation rate %.0f/s, want some", name, res.AllocRate)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+// Package brokertest provides a conformance test suite for brokers. Broker
+// packages run it from their tests with Run, so that regressions and
+// behavioural differences between the implementations show up right away.
+package brokertest
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+	"github.com/spy16/ticktock/ticker"
+)
+
+// timeout bounds every wait of the suite.
+const timeout = 5 * time.Second
+
+// shutdownUpdates is the number of updates made after a broker stops, more
+// than the default request queues hold.
+const shutdownUpdates = 1 << 20
+
+// Broker is a websocket server that publishes ticks to its subscribers.
+type Broker interface {
+	Serve(ctx context.Context, addr string) error
+	ticker.Publisher
+	Topics() *ticker.Topics
+}
+
+// Run runs the conformance suite against the brokers returned by newBroker.
+// Each test gets a fresh broker. The broker logs are only kept in verbose
+// mode.
+func Run(t *testing.T, newBroker func(t *testing.T) Broker) {
+	if !testing.Verbose() {
+		level := zerolog.GlobalLevel()
+		zerolog.SetGlobalLevel(zerolog.Disabled)
+		t.Cleanup(func() { zerolog.SetGlobalLevel(level) })
+	}
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, h *harness)
+	}{
+		{"Subscribe", testSubscribe},
+		{"Unsubscribe", testUnsubscribe},
+		{"ModeChange", testModeChange},
+		{"ModeNone", testModeNone},
+		{"DisconnectCleanup", testDisconnectCleanup},
+		{"DuplicateSubscriptions", testDuplicateSubscriptions},
+		{"PipelinedRequests", testPipelinedRequests},
+		{"Ordering", testOrdering},
+		{"SlowConsumer", testSlowConsumer},
+		{"Shutdown", testShutdown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, start(t, newBroker(t)))
+		})
+	}
+}
+
+func testSubscribe(t *testing.T, h *harness) {
+	c := h.dial(t)
+	c.subscribe(t, ticker.ModeLTP, 1, 2)
+
+	h.publish(t, tick(1, 1), tick(3, 1), tick(2, 1))
+	got := c.expect(t, ticker.ModeLTP, 2)
+	assertInstruments(t, got, 1, 2)
+}
+
+func testUnsubscribe(t *testing.T, h *harness) {
+	c := h.dial(t)
+	c.subscribe(t, ticker.ModeLTP, 1, 2)
+	c.subscribe(t, ticker.ModeNone, 1)
+
+	h.publish(t, tick(1, 1), tick(2, 1))
+	got := c.expect(t, ticker.ModeLTP, 1)
+	assertInstruments(t, got, 2)
+}
+
+func testModeChange(t *testing.T, h *harness) {
+	c := h.dial(t)
+	c.subscribe(t, ticker.ModeLTP, 1)
+	h.publish(t, tick(1, 1))
+	c.expect(t, ticker.ModeLTP, 1)
+
+	c.subscribe(t, ticker.ModeFull, 1)
+	h.publish(t, tick(1, 2))
+	got := c.expect(t, ticker.ModeFull, 1)
+	if got[0].Bids[0].Price != 99 {
+		t.Fatalf("expected a full packet with the market depth, got %+v", got[0])
+	}
+
+	c.subscribe(t, ticker.ModeQuote, 1)
+	h.publish(t, tick(1, 3))
+	got = c.expect(t, ticker.ModeQuote, 1)
+	if got[0].Volume != 1000 {
+		t.Fatalf("expected a quote packet, got %+v", got[0])
+	}
+}
+
+// testModeNone checks that unsubscribing from instruments the client is not
+// subscribed to is a no-op.
+func testModeNone(t *testing.T, h *harness) {
+	c := h.dial(t)
+	c.subscribe(t, ticker.ModeNone, 1, 2)
+	c.subscribe(t, ticker.ModeLTP, 3)
+
+	h.publish(t, tick(1, 1), tick(2, 1), tick(3, 1))
+	got := c.expect(t, ticker.ModeLTP, 1)
+	assertInstruments(t, got, 3)
+
+	c.send(t, ticker.Request{Action: ticker.ActionList})
+	var list ticker.List
+	c.reply(t, ticker.ActionList, &list)
+	if len(list.Instruments) != 1 || list.Instruments[3] != ticker.ModeLTP {
+		t.Fatalf("expected only instrument 3 to be subscribed, got %v", list.Instruments)
+	}
+}
+
+func testDisconnectCleanup(t *testing.T, h *harness) {
+	topics := h.br.Topics()
+
+	gone := h.dial(t)
+	gone.subscribe(t, ticker.ModeLTP, 1)
+	c := h.dial(t)
+	c.subscribe(t, ticker.ModeLTP, 1)
+	waitFor(t, "2 connected clients", func() bool { return topics.Connected() == 2 })
+
+	gone.close()
+	waitFor(t, "the client to be removed", func() bool { return topics.Connected() == 1 })
+
+	// the broker must not get stuck on the closed client.
+	for seq := uint64(1); seq <= 100; seq++ {
+		h.publish(t, tick(1, seq))
+	}
+	got := c.expect(t, ticker.ModeLTP, 100)
+	assertSequence(t, got, 1)
+}
+
+func testDuplicateSubscriptions(t *testing.T, h *harness) {
+	c := h.dial(t)
+	c.subscribe(t, ticker.ModeLTP, 1, 1)
+	c.subscribe(t, ticker.ModeLTP, 1)
+	c.subscribe(t, ticker.ModeLTP, 2)
+
+	// duplicated packets of 1 would show up before the packet of 2.
+	h.publish(t, tick(1, 1), tick(1, 2), tick(2, 1))
+	got := c.expect(t, ticker.ModeLTP, 3)
+	assertInstruments(t, got, 1, 1, 2)
+	assertSequence(t, got[:2], 1)
+}
+
+// testPipelinedRequests checks that requests sent back to back, which may
+// arrive in a single read, are all answered.
+func testPipelinedRequests(t *testing.T, h *harness) {
+	c := h.dial(t)
+
+	const count = 50
+	for i := int32(1); i <= count; i++ {
+		c.send(t, ticker.Request{Mode: ticker.ModeLTP, Instruments: []int32{i}})
+	}
+	for i := 0; i < count; i++ {
+		var ack ticker.Ack
+		c.reply(t, ticker.ActionAck, &ack)
+	}
+
+	c.send(t, ticker.Request{Action: ticker.ActionList})
+	var list ticker.List
+	c.reply(t, ticker.ActionList, &list)
+	if len(list.Instruments) != count {
+		t.Fatalf("expected %d subscribed instruments, got %d", count, len(list.Instruments))
+	}
+}
+
+func testOrdering(t *testing.T, h *harness) {
+	c := h.dial(t)
+	c.subscribe(t, ticker.ModeLTP, 1, 2)
+
+	const count = 500
+	for seq := uint64(1); seq <= count; seq += 10 {
+		var batch []ticker.Tick
+		for i := seq; i < seq+10; i++ {
+			batch = append(batch, tick(1, i), tick(2, i))
+		}
+		h.publish(t, batch...)
+	}
+
+	got := c.expect(t, ticker.ModeLTP, 2*count)
+	byInstr := map[int32][]ticker.Quote{}
+	for _, q := range got {
+		byInstr[q.Instrument] = append(byInstr[q.Instrument], q)
+	}
+	assertSequence(t, byInstr[1], 1)
+	assertSequence(t, byInstr[2], 1)
+}
+
+// testSlowConsumer checks that a client that does not read does not hold
+// up the others, at least until its queue is full, and that the broker
+// recovers once it is gone.
+func testSlowConsumer(t *testing.T, h *harness) {
+	slow := h.dial(t)
+	slow.subscribe(t, ticker.ModeFull, 1)
+	slow.pause()
+
+	c := h.dial(t)
+	c.subscribe(t, ticker.ModeLTP, 1)
+
+	const count = 1000
+	for seq := uint64(1); seq <= count; seq++ {
+		h.publish(t, tick(1, seq))
+	}
+	got := c.expect(t, ticker.ModeLTP, count)
+	assertSequence(t, got, 1)
+
+	slow.close()
+	waitFor(t, "the slow client to be removed", func() bool { return h.br.Topics().Connected() == 1 })
+
+	h.publish(t, tick(1, count+1))
+	got = c.expect(t, ticker.ModeLTP, 1)
+	assertSequence(t, got, count+1)
+}
+
+func testShutdown(t *testing.T, h *harness) {
+	c := h.dial(t)
+	c.subscribe(t, ticker.ModeLTP, 1)
+
+	h.cancel()
+	select {
+	case <-h.done:
+	case <-time.After(timeout):
+		t.Fatal("Serve did not return after the context was cancelled")
+	}
+
+	select {
+	case <-c.closed:
+	case <-time.After(timeout):
+		t.Fatal("client connection was not closed on shutdown")
+	}
+
+	// updates of a stopped broker must not wait on a full request queue.
+	if up, ok := h.br.(interface {
+		Update(fn func(t *ticker.Topics))
+	}); ok {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < shutdownUpdates; i++ {
+				up.Update(func(*ticker.Topics) {})
+			}
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(timeout):
+			t.Fatal("Update blocked after the broker stopped")
+		}
+	}
+}
+
+// tick returns a tick with the full packet of a quote of the instrument.
+func tick(instr int32, seq uint64) ticker.Tick {
+	q := ticker.Quote{
+		Timestamp:  time.Now().UnixMicro(),
+		Instrument: instr,
+		Seq:        seq,
+		LastPrice:  100,
+		Volume:     1000,
+	}
+	for i := range q.Bids {
+		q.Bids[i] = ticker.DepthLevel{Price: 99 - int64(i), Qty: 10, Orders: 1}
+		q.Asks[i] = ticker.DepthLevel{Price: 101 + int64(i), Qty: 10, Orders: 1}
+	}
+	return q.Tick()
+}
+
+// harness runs a broker on a loopback port.
+type harness struct {
+	br     Broker
+	addr   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func start(t *testing.T, br Broker) *harness {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &harness{br: br, addr: addr, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(h.done)
+		_ = br.Serve(ctx, addr)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-h.done
+	})
+
+	waitFor(t, "the broker to listen", func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err == nil
+	})
+	return h
+}
+
+func (h *harness) publish(t *testing.T, ticks ...ticker.Tick) {
+	t.Helper()
+
+	opts := ticker.PublishOptions{Mode: ticker.PublishBlock, Timeout: timeout}
+	if n, err := h.br.Publish(opts, ticks); err != nil || n != len(ticks) {
+		t.Fatalf("failed to publish: accepted %d of %d: %v", n, len(ticks), err)
+	}
+}
+
+// client is a websocket client of the broker.
+type client struct {
+	conn    *websocket.Conn
+	packets chan []byte // binary messages.
+	replies chan []byte // text messages other than session replies.
+	paused  chan struct{}
+	stop    chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (h *harness) dial(t *testing.T) *client {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+h.addr+"/", nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	c := &client{
+		conn:    conn,
+		packets: make(chan []byte, 1<<16),
+		replies: make(chan []byte, 64),
+		paused:  make(chan struct{}),
+		stop:    make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	go c.read()
+	t.Cleanup(c.close)
+	return c
+}
+
+func (c *client) read() {
+	defer close(c.closed)
+
+	for {
+		select {
+		case <-c.paused:
+			<-c.stop // a stalled reader, until the connection is closed.
+			return
+		default:
+		}
+
+		op, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if op == websocket.BinaryMessage {
+			c.packets <- msg
+		} else if !strings.Contains(string(msg), `"a":"`+ticker.ActionSession+`"`) {
+			c.replies <- msg
+		}
+	}
+}
+
+// pause stops reading from the connection.
+func (c *client) pause() {
+	close(c.paused)
+}
+
+func (c *client) close() {
+	c.once.Do(func() {
+		close(c.stop)
+		_ = c.conn.Close()
+	})
+}
+
+func (c *client) send(t *testing.T, req ticker.Request) {
+	t.Helper()
+
+	msg, _ := json.Marshal(req)
+	if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+}
+
+// subscribe sends a subscription request and waits for its ack, after which
+// the subscription is in effect.
+func (c *client) subscribe(t *testing.T, mode ticker.Mode, instruments ...int32) {
+	t.Helper()
+
+	c.send(t, ticker.Request{Mode: mode, Instruments: instruments})
+	var ack ticker.Ack
+	c.reply(t, ticker.ActionAck, &ack)
+	if len(ack.Rejected) > 0 {
+		t.Fatalf("subscription rejected: %+v", ack)
+	}
+}
+
+// reply waits for the next reply and decodes it into v.
+func (c *client) reply(t *testing.T, action string, v any) {
+	t.Helper()
+
+	select {
+	case msg := <-c.replies:
+		var head struct {
+			Action string `json:"a"`
+		}
+		if err := json.Unmarshal(msg, &head); err != nil || head.Action != action {
+			t.Fatalf("expected a '%s' reply, got %s", action, msg)
+		}
+		if err := json.Unmarshal(msg, v); err != nil {
+			t.Fatalf("invalid '%s' reply: %v", action, err)
+		}
+
+	case <-time.After(timeout):
+		t.Fatalf("timed out waiting for a '%s' reply", action)
+	}
+}
+
+// expect waits for count packets of the mode. Messages may carry several
+// packets.
+func (c *client) expect(t *testing.T, mode ticker.Mode, count int) []ticker.Quote {
+	t.Helper()
+
+	size := ticker.PacketSize(mode)
+	deadline := time.After(timeout)
+
+	var quotes []ticker.Quote
+	for len(quotes) < count {
+		select {
+		case msg := <-c.packets:
+			if len(msg)%size != 0 {
+				t.Fatalf("expected packets of %d bytes, got a message of %d bytes", size, len(msg))
+			}
+			for ; len(msg) > 0; msg = msg[size:] {
+				q, got, err := ticker.ParseQuote(msg[:size])
+				if err != nil || got != mode {
+					t.Fatalf("expected a packet of mode %d, got mode %d: %v", mode, got, err)
+				}
+				quotes = append(quotes, q)
+			}
+
+		case <-deadline:
+			t.Fatalf("timed out waiting for packets: got %d of %d", len(quotes), count)
+		}
+	}
+
+	if len(quotes) > count {
+		t.Fatalf("expected %d packets, got %d", count, len(quotes))
+	}
+	return quotes
+}
+
+func assertInstruments(t *testing.T, quotes []ticker.Quote, want ...int32) {
+	t.Helper()
+
+	got := make([]int32, len(quotes))
+	for i, q := range quotes {
+		got[i] = q.Instrument
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected packets of instruments %v, got %v", want, got)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("expected packets of instruments %v, got %v", want, got)
+		}
+	}
+}
+
+// assertSequence checks that the quotes have consecutive sequence numbers
+// from first.
+func assertSequence(t *testing.T, quotes []ticker.Quote, first uint64) {
+	t.Helper()
+
+	for i, q := range quotes {
+		if want := first + uint64(i); q.Seq != want {
+			t.Fatalf("expected sequence number %d at %d, got %d", want, i, q.Seq)
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,153 @@
+package brokertest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/spy16/ticktock/ticker"
+)
+
+// TestReference runs the suite against the simplest broker that should pass
+// it, so that the expectations of the suite are checked apart from the
+// optimisations of the real brokers.
+func TestReference(t *testing.T) {
+	Run(t, func(t *testing.T) Broker {
+		return &refBroker{topics: ticker.NewTopics(), queue: ticker.NewQueue(1024)}
+	})
+}
+
+// refBroker guards its registry with a mutex and runs a reader and a writer
+// goroutine per client.
+type refBroker struct {
+	mu     sync.Mutex
+	topics *ticker.Topics
+	queue  *ticker.Queue
+}
+
+func (rb *refBroker) Topics() *ticker.Topics { return rb.topics }
+
+func (rb *refBroker) Publish(opts ticker.PublishOptions, ticks []ticker.Tick) (int, error) {
+	return rb.queue.Push(opts, ticks)
+}
+
+func (rb *refBroker) Serve(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-rb.queue.Ready():
+				rb.mu.Lock()
+				rb.topics.Dispatch(rb.queue.Pop(256))
+				rb.mu.Unlock()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	srv := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rb.serveClient(ctx, conn)
+		}()
+	})}
+	stop := context.AfterFunc(ctx, func() { _ = srv.Close() })
+	defer stop()
+
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (rb *refBroker) serveClient(ctx context.Context, conn *websocket.Conn) {
+	// the queue holds all the packets a slow consumer is sent by the suite.
+	rc := &refClient{writes: make(chan []byte, 4096), replies: make(chan []byte, 64), done: make(chan struct{})}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	rb.mu.Lock()
+	rb.topics.Connect(rc, "")
+	rb.mu.Unlock()
+
+	go func() {
+		defer conn.Close()
+		for {
+			var err error
+			select {
+			case <-rc.done:
+				return
+			case msg := <-rc.writes:
+				err = conn.WriteMessage(websocket.BinaryMessage, msg)
+			case msg := <-rc.replies:
+				err = conn.WriteMessage(websocket.TextMessage, msg)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// the client is closed first, since the dispatch may be blocked on it
+	// with the lock held.
+	defer func() {
+		close(rc.done)
+		_ = conn.Close()
+		rb.mu.Lock()
+		rb.topics.Remove(rc)
+		rb.mu.Unlock()
+	}()
+
+	for {
+		op, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		} else if op != websocket.TextMessage {
+			continue
+		}
+
+		var req ticker.Request
+		if err := json.Unmarshal(msg, &req); err != nil {
+			continue
+		}
+		rb.mu.Lock()
+		rb.topics.Apply(rc, req)
+		rb.mu.Unlock()
+	}
+}
+
+// refClient blocks the dispatch on a full queue until it is closed.
+type refClient struct {
+	writes  chan []byte
+	replies chan []byte
+	done    chan struct{}
+}
+
+func (rc *refClient) EnqueuWrite(msg []byte) {
+	select {
+	case rc.writes <- msg:
+	case <-rc.done:
+	}
+}
+
+func (rc *refClient) EnqueuReply(msg []byte) {
+	select {
+	case rc.replies <- msg:
+	case <-rc.done:
+	}
+}
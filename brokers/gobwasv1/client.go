@@ -5,26 +5,35 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 	"github.com/rs/zerolog/log"
+	"github.com/spy16/ticktock/brokers/internal/wsframe"
 	"github.com/spy16/ticktock/ticker"
 )
 
 type wsClient struct {
-	br     *Broker
-	rw     *bufio.ReadWriter
-	done   chan struct{}
-	conn   net.Conn
-	writes chan []byte
+	br      *Broker
+	rw      *bufio.ReadWriter // nil with pooled buffers.
+	src     io.Reader         // frames are read from with pooled buffers.
+	done    chan struct{}
+	conn    net.Conn
+	writes  chan []byte
+	replies chan []byte
+	queued  atomic.Int64 // size of the packets in writes.
 }
 
 func (wc *wsClient) EnqueuWrite(msg []byte) {
+	wc.queued.Add(int64(len(msg)))
 	select {
 	case <-wc.done:
+		wc.queued.Add(-int64(len(msg)))
 		return // client is closed
 
 	case wc.writes <- msg:
@@ -32,11 +41,43 @@ func (wc *wsClient) EnqueuWrite(msg []byte) {
 	}
 }
 
+func (wc *wsClient) EnqueuReply(msg []byte) {
+	select {
+	case <-wc.done:
+		return // client is closed
+
+	case wc.replies <- msg:
+		return
+	}
+}
+
+// Memory estimates the memory used by the connection: its bufio
+// buffers (unless pooled), the queues and the 2 goroutines.
+func (wc *wsClient) Memory() (buffers, queued int64) {
+	buffers = int64(cap(wc.writes)+cap(wc.replies))*ticker.QueueSlotSize + 2*ticker.StackSize
+	if wc.rw != nil {
+		buffers += int64(wc.rw.Reader.Size() + wc.rw.Writer.Size())
+	}
+	return buffers, wc.queued.Load()
+}
+
+// Shed closes the connection, which stops the reader and then the client.
+func (wc *wsClient) Shed() {
+	_ = wc.conn.Close()
+}
+
+func (wc *wsClient) RemoteAddr() net.Addr {
+	return wc.conn.RemoteAddr()
+}
+
 func (wc *wsClient) Run(ctx context.Context) {
+	// the removal must go through even after the client context is
+	// canceled, e.g., by the reader.
+	brokerCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
 	defer func() {
 		close(wc.done)
-		wc.br.removeSub(ctx, wc)
+		wc.br.removeSub(brokerCtx, wc)
 		cancel()
 		_ = wc.conn.Close()
 	}()
@@ -48,18 +89,61 @@ func (wc *wsClient) Run(ctx context.Context) {
 		case <-ctx.Done():
 			return
 
+		case msg := <-wc.replies:
+			if kill := wc.write(ws.OpText, msg); kill {
+				return
+			}
+
 		case msg, ok := <-wc.writes:
 			if !ok {
 				return
 			}
-
-			if kill := wc.write(msg); kill {
+			if kill := wc.writeQueued(msg); kill {
 				return
 			}
 		}
 	}
 }
 
+// writeQueued writes the packet along with the ones queued behind it, up to
+// Options.MaxWriteBytes, in a single vectored write.
+func (wc *wsClient) writeQueued(msg []byte) (kill bool) {
+	b := wsframe.GetBatch()
+	defer wsframe.PutBatch(b)
+
+	now := time.Now()
+	add := func(msg []byte) {
+		wc.queued.Add(-int64(len(msg)))
+		if wc.br.topics.Trace {
+			ticker.StampWritten(msg, now)
+		}
+		b.Add(ws.OpBinary, msg, wc.br.opts.PreparedFrames)
+	}
+
+	add(msg)
+drain:
+	for !b.Full(wc.br.opts.MaxWriteBytes) {
+		select {
+		case msg, ok := <-wc.writes:
+			if !ok {
+				break drain
+			}
+			add(msg)
+
+		default:
+			break drain
+		}
+	}
+
+	if err := b.WriteTo(wc.conn, &wc.br.writes); err != nil {
+		if !errors.Is(err, syscall.EPIPE) {
+			log.Error().Err(err).Msg("failed to write messages")
+		}
+		return true
+	}
+	return false
+}
+
 func (wc *wsClient) runReader(ctx context.Context, cancel context.CancelFunc) {
 	defer cancel()
 
@@ -69,7 +153,7 @@ func (wc *wsClient) runReader(ctx context.Context, cancel context.CancelFunc) {
 			return
 
 		default:
-			msg, op, err := wsutil.ReadClientData(wc.rw)
+			msg, op, release, err := wc.read()
 			if err != nil {
 				var closeErr wsutil.ClosedError
 				if errors.As(err, &closeErr) {
@@ -83,11 +167,14 @@ func (wc *wsClient) runReader(ctx context.Context, cancel context.CancelFunc) {
 			} else if op == ws.OpClose {
 				return
 			} else if op != ws.OpText {
+				release()
 				continue
 			}
 
 			var req ticker.Request
-			if err := json.Unmarshal(msg, &req); err != nil {
+			err = json.Unmarshal(msg, &req)
+			release()
+			if err != nil {
 				log.Warn().Err(err).Msg("failed to unmarshal request")
 				continue // ignore invalid requests
 			}
@@ -96,8 +183,33 @@ func (wc *wsClient) runReader(ctx context.Context, cancel context.CancelFunc) {
 	}
 }
 
-func (wc *wsClient) write(data []byte) (kill bool) {
-	if err := wsutil.WriteServerMessage(wc.rw, ws.OpBinary, data); err != nil {
+// read reads the next data message of the client. release must be called
+// once the message is processed.
+func (wc *wsClient) read() (msg []byte, op ws.OpCode, release func(), err error) {
+	if wc.rw != nil {
+		msg, op, err = wsutil.ReadClientData(wc.rw)
+		return msg, op, func() {}, err
+	}
+
+	buf, op, err := wsframe.ReadPooled(wc.src, wc.conn)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return buf.Bytes(), op, func() { wsframe.PutPayload(buf) }, nil
+}
+
+func (wc *wsClient) write(op ws.OpCode, data []byte) (kill bool) {
+	if wc.rw == nil {
+		if err := wsframe.WritePooled(wc.conn, op, data); err != nil {
+			if !errors.Is(err, syscall.EPIPE) {
+				log.Error().Err(err).Msg("failed to write message")
+			}
+			return true
+		}
+		return false
+	}
+
+	if err := wsutil.WriteServerMessage(wc.rw, op, data); err != nil {
 		if !errors.Is(err, syscall.EPIPE) {
 			log.Error().Err(err).Msg("failed to write message")
 		}
@@ -109,3 +221,9 @@ func (wc *wsClient) write(data []byte) (kill bool) {
 
 	return false
 }
+
+// prepareFrame frames a packet as a binary message (see
+// Options.PreparedFrames).
+func prepareFrame(packet []byte) []byte {
+	return ws.MustCompileFrame(ws.NewBinaryFrame(packet))
+}
@@ -2,45 +2,62 @@ package gobwasv2
 
 import (
 	"context"
+	"errors"
 	"net"
 	"net/http"
 	"sync"
-	"time"
+	"syscall"
 
 	"github.com/gobwas/ws"
 	"github.com/rs/zerolog/log"
 	"github.com/smallnest/epoller"
+	"github.com/spy16/ticktock/brokers/internal/wsframe"
 	"github.com/spy16/ticktock/ticker"
 	"github.com/spy16/ticktock/utils"
 )
 
-func New() *Broker {
+// dispatchBatch is the maximum number of queued ticks dispatched at once.
+const dispatchBatch = 4096
+
+func New(opts Options) *Broker {
 	p, err := epoller.NewPoller()
 	if err != nil {
 		panic(err)
 	}
 
-	return &Broker{
+	opts = opts.withDefaults()
+	br := &Broker{
+		opts:    opts,
 		poller:  p,
 		clients: make(map[net.Conn]*wsClient),
-		topics:  make(map[int32]map[*wsClient]ticker.Mode),
+		topics:  ticker.NewTopics(),
 
-		ioEvents: make(chan net.Conn, 200000),
-		messages: make(chan []ticker.Tick, 200000),
-		requests: make(chan brokerRequest, 200000),
+		ioEvents: make(chan net.Conn, opts.RequestQueue),
+		messages: ticker.NewQueue(opts.TickQueue),
+		requests: make(chan brokerRequest, opts.RequestQueue),
+		stopped:  make(chan struct{}),
+	}
+	br.topics.ClientQueue = opts.ClientQueue
+	if opts.PreparedFrames {
+		br.topics.Prepare = prepareFrame
 	}
+	return br
 }
 
 type Broker struct {
+	opts Options
+
 	mu      sync.RWMutex
 	clients map[net.Conn]*wsClient
 
 	poller epoller.Poller
-	topics map[int32]map[*wsClient]ticker.Mode
+	topics *ticker.Topics
 
 	ioEvents chan net.Conn
 	requests chan brokerRequest
-	messages chan []ticker.Tick
+	stopped  chan struct{} // closed when the management loop exits.
+	messages *ticker.Queue
+	writes   ticker.WriteCounter
 }
 
 type brokerRequest struct {
@@ -48,16 +65,36 @@ type brokerRequest struct {
 
 	Client *wsClient
 	Remove bool
+	Update func(t *ticker.Topics)
 }
 
-// Publish publishes the given ticks to all subscribers.
-func (br *Broker) Publish(timeout time.Duration, ticks []ticker.Tick) error {
-	select {
-	case br.messages <- ticks:
-		return nil
+// Publish enqueues the given ticks for delivery to all subscribers.
+func (br *Broker) Publish(opts ticker.PublishOptions, ticks []ticker.Tick) (int, error) {
+	return br.messages.Push(opts, ticks)
+}
+
+// Queue returns the queue of published ticks awaiting dispatch.
+func (br *Broker) Queue() *ticker.Queue {
+	return br.messages
+}
+
+// Topics returns the subscription registry of the broker. It must only be
+// configured before Serve is called.
+func (br *Broker) Topics() *ticker.Topics {
+	return br.topics
+}
+
+// WriteStats returns the counts of the vectored writes of the clients.
+func (br *Broker) WriteStats() ticker.WriteStats {
+	return br.writes.Stats()
+}
 
-	case <-time.After(timeout):
-		return ticker.ErrTimeout
+// Update runs fn with the subscription registry once the pending requests
+// have been applied. It does nothing once the broker has stopped.
+func (br *Broker) Update(fn func(t *ticker.Topics)) {
+	select {
+	case br.requests <- brokerRequest{Update: fn}:
+	case <-br.stopped:
 	}
 }
 
@@ -69,7 +106,7 @@ func (br *Broker) Serve(ctx context.Context, addr string) error {
 	go br.runManagement(ctx, cancel)
 	go br.runPoller(ctx, cancel)
 
-	return utils.ServeCtx(ctx, addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return utils.ServeCtx(ctx, addr, br.opts.Admission.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		conn, rw, _, err := ws.UpgradeHTTP(r, w)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to upgrade connection")
@@ -80,32 +117,45 @@ func (br *Broker) Serve(ctx context.Context, addr string) error {
 			tc.SetNoDelay(true)
 		}
 
-		if err := br.poller.Add(conn); err != nil {
-			log.Error().Err(err).Msg("failed to add connection to poller")
-			return
-		}
-
 		wc := &wsClient{
-			br:     br,
-			rw:     rw,
-			conn:   conn,
-			done:   make(chan struct{}),
-			reads:  make(chan struct{}, 10000),
-			writes: make(chan []byte, 10000),
+			br:      br,
+			rw:      rw,
+			conn:    conn,
+			done:    make(chan struct{}),
+			reads:   make(chan struct{}, br.opts.ClientQueue),
+			writes:  make(chan []byte, br.opts.ClientQueue),
+			replies: make(chan []byte, 16),
+		}
+		if br.opts.PooledBuffers {
+			wc.rw, wc.src = nil, wsframe.ReleaseBuffers(conn, rw)
+			if wc.src != conn {
+				// the poller will not report the bytes received along with
+				// the handshake.
+				wc.reads <- struct{}{}
+			}
 		}
 
+		// the client is registered before the poller can report it, since a
+		// reported connection is not reported again until it is rearmed.
 		br.mu.Lock()
 		br.clients[wc.conn] = wc
 		br.mu.Unlock()
+		br.connect(ctx, wc, r.URL.Query().Get("session"))
 
-		go func() {
-			wc.Run(ctx)
-			_ = br.poller.Remove(wc.conn)
-		}()
-	}))
+		if err := br.poller.Add(conn); err != nil {
+			log.Error().Err(err).Msg("failed to add connection to poller")
+			close(wc.done)
+			br.release(wc)
+			br.removeSub(ctx, wc)
+			_ = conn.Close()
+			return
+		}
+		go wc.Run(ctx)
+	})))
 }
 
 func (br *Broker) runManagement(ctx context.Context, cancel context.CancelFunc) {
+	defer close(br.stopped)
 	defer cancel()
 
 	for {
@@ -130,33 +180,16 @@ func (br *Broker) runManagement(ctx context.Context, cancel context.CancelFunc)
 				}
 			}
 
-		case ticks := <-br.messages:
-			for _, tick := range ticks {
-				subs := br.topics[tick.Instrument]
-
-				for wc, mode := range subs {
-					wc.EnqueuWrite(tick.Compute(mode))
-				}
-			}
+		case <-br.messages.Ready():
+			br.topics.Dispatch(br.messages.Pop(dispatchBatch))
 
 		case cmd := <-br.requests:
-			if cmd.Remove {
-				for _, instr := range cmd.Instruments {
-					delete(br.topics[instr], cmd.Client)
-				}
+			if cmd.Update != nil {
+				cmd.Update(br.topics)
+			} else if cmd.Remove {
+				br.topics.Remove(cmd.Client)
 			} else {
-				for _, instr := range cmd.Instruments {
-					if cmd.Mode == ticker.ModeNone {
-						if br.topics[instr] != nil {
-							delete(br.topics[instr], cmd.Client)
-						}
-					} else {
-						if br.topics[instr] == nil {
-							br.topics[instr] = make(map[*wsClient]ticker.Mode)
-						}
-						br.topics[instr][cmd.Client] = cmd.Mode
-					}
-				}
+				br.topics.Apply(cmd.Client, cmd.Request)
 			}
 		}
 	}
@@ -173,7 +206,7 @@ func (br *Broker) runPoller(ctx context.Context, cancel context.CancelFunc) {
 			return
 
 		default:
-			conns, err := br.poller.Wait(1024)
+			conns, err := br.poller.Wait(br.opts.PollBatch)
 			if err != nil {
 				log.Error().Err(err).Msg("failed to poll")
 				continue
@@ -182,10 +215,22 @@ func (br *Broker) runPoller(ctx context.Context, cancel context.CancelFunc) {
 			}
 
 			for _, conn := range conns {
+				if conn == nil {
+					// added to epoll but not to the connections of the
+					// poller yet. It is reported again on the next wait.
+					continue
+				}
 				if pConn, ok := conn.(epoller.ConnImpl); ok {
 					conn = pConn.Conn
 				}
 
+				// the poller is level-triggered: the connection is dropped
+				// until its reader rearms it, so that the poller does not
+				// spin on the unread request in the meantime.
+				if err := br.poller.Remove(conn); err != nil {
+					log.Error().Err(err).Msg("failed to remove connection from poller")
+				}
+
 				select {
 				case br.ioEvents <- conn:
 				case <-ctx.Done():
@@ -196,6 +241,26 @@ func (br *Broker) runPoller(ctx context.Context, cancel context.CancelFunc) {
 	}
 }
 
+// release forgets the client and stops polling its connection. It must be
+// done before the connection is closed, which frees its descriptor for reuse.
+func (br *Broker) release(wc *wsClient) {
+	br.mu.Lock()
+	delete(br.clients, wc.conn)
+	br.mu.Unlock()
+
+	// the connection is not registered while its reader handles a report.
+	if err := br.poller.Remove(wc.conn); err != nil && !errors.Is(err, syscall.ENOENT) {
+		log.Error().Err(err).Msg("failed to remove connection from poller")
+	}
+}
+
+func (br *Broker) connect(ctx context.Context, wc *wsClient, token string) {
+	select {
+	case br.requests <- brokerRequest{Update: func(t *ticker.Topics) { t.Connect(wc, token) }}:
+	case <-ctx.Done():
+	}
+}
+
 func (br *Broker) updateSubs(ctx context.Context, wc *wsClient, req ticker.Request) {
 	select {
 	case br.requests <- brokerRequest{Request: req, Client: wc}:
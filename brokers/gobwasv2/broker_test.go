@@ -0,0 +1,25 @@
+package gobwasv2
+
+import (
+	"testing"
+
+	"github.com/spy16/ticktock/brokers/brokertest"
+)
+
+func TestConformance(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) brokertest.Broker {
+		return New(Options{})
+	})
+}
+
+func TestConformancePooledBuffers(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) brokertest.Broker {
+		return New(Options{PooledBuffers: true})
+	})
+}
+
+func TestConformancePreparedFrames(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) brokertest.Broker {
+		return New(Options{PreparedFrames: true})
+	})
+}
@@ -7,26 +7,34 @@ import (
 	"errors"
 	"io"
 	"net"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 	"github.com/rs/zerolog/log"
+	"github.com/spy16/ticktock/brokers/internal/wsframe"
 	"github.com/spy16/ticktock/ticker"
 )
 
 type wsClient struct {
-	br     *Broker
-	rw     *bufio.ReadWriter
-	done   chan struct{}
-	conn   net.Conn
-	writes chan []byte
-	reads  chan struct{}
+	br      *Broker
+	rw      *bufio.ReadWriter // nil with pooled buffers.
+	src     io.Reader         // frames are read from with pooled buffers.
+	done    chan struct{}
+	conn    net.Conn
+	writes  chan []byte
+	replies chan []byte
+	reads   chan struct{}
+	queued  atomic.Int64 // size of the packets in writes.
 }
 
 func (wc *wsClient) EnqueuWrite(msg []byte) {
+	wc.queued.Add(int64(len(msg)))
 	select {
 	case <-wc.done:
+		wc.queued.Add(-int64(len(msg)))
 		return // client is closed
 
 	case wc.writes <- msg:
@@ -34,12 +42,50 @@ func (wc *wsClient) EnqueuWrite(msg []byte) {
 	}
 }
 
+func (wc *wsClient) EnqueuReply(msg []byte) {
+	select {
+	case <-wc.done:
+		return // client is closed
+
+	case wc.replies <- msg:
+		return
+	}
+}
+
+// Memory estimates the memory used by the connection: its bufio
+// buffers (unless pooled), the queues and the 2 goroutines.
+func (wc *wsClient) Memory() (buffers, queued int64) {
+	buffers = int64(cap(wc.writes)+cap(wc.replies))*ticker.QueueSlotSize + 2*ticker.StackSize
+	if wc.rw != nil {
+		buffers += int64(wc.rw.Reader.Size() + wc.rw.Writer.Size())
+	}
+	return buffers, wc.queued.Load()
+}
+
+// Shed closes the connection. The reader is woken up to find it closed,
+// which stops the client.
+func (wc *wsClient) Shed() {
+	_ = wc.conn.Close()
+	select {
+	case wc.reads <- struct{}{}:
+	default:
+	}
+}
+
+func (wc *wsClient) RemoteAddr() net.Addr {
+	return wc.conn.RemoteAddr()
+}
+
 func (wc *wsClient) Run(ctx context.Context) {
+	// the removal must go through even after the client context is
+	// canceled, e.g., by the reader.
+	brokerCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
 	defer func() {
 		close(wc.done)
-		wc.br.removeSub(ctx, wc)
+		wc.br.removeSub(brokerCtx, wc)
 		cancel()
+		wc.br.release(wc)
 		_ = wc.conn.Close()
 	}()
 
@@ -50,18 +96,61 @@ func (wc *wsClient) Run(ctx context.Context) {
 		case <-ctx.Done():
 			return
 
+		case msg := <-wc.replies:
+			if kill := wc.write(ws.OpText, msg); kill {
+				return
+			}
+
 		case msg, ok := <-wc.writes:
 			if !ok {
 				return
 			}
-
-			if kill := wc.write(msg); kill {
+			if kill := wc.writeQueued(msg); kill {
 				return
 			}
 		}
 	}
 }
 
+// writeQueued writes the packet along with the ones queued behind it, up to
+// Options.MaxWriteBytes, in a single vectored write.
+func (wc *wsClient) writeQueued(msg []byte) (kill bool) {
+	b := wsframe.GetBatch()
+	defer wsframe.PutBatch(b)
+
+	now := time.Now()
+	add := func(msg []byte) {
+		wc.queued.Add(-int64(len(msg)))
+		if wc.br.topics.Trace {
+			ticker.StampWritten(msg, now)
+		}
+		b.Add(ws.OpBinary, msg, wc.br.opts.PreparedFrames)
+	}
+
+	add(msg)
+drain:
+	for !b.Full(wc.br.opts.MaxWriteBytes) {
+		select {
+		case msg, ok := <-wc.writes:
+			if !ok {
+				break drain
+			}
+			add(msg)
+
+		default:
+			break drain
+		}
+	}
+
+	if err := b.WriteTo(wc.conn, &wc.br.writes); err != nil {
+		if !errors.Is(err, syscall.EPIPE) {
+			log.Error().Err(err).Msg("failed to write messages")
+		}
+		return true
+	}
+	return false
+}
+
 func (wc *wsClient) runReader(ctx context.Context, cancel context.CancelFunc) {
 	defer cancel()
 
@@ -75,39 +164,90 @@ func (wc *wsClient) runReader(ctx context.Context, cancel context.CancelFunc) {
 				return
 			}
 
-			msg, op, err := wsutil.ReadClientData(wc.rw)
-			if err != nil {
-				if errors.Is(err, io.EOF) {
+			// requests buffered along with the last one are not reported
+			// by the poller.
+			for {
+				if kill := wc.readRequest(ctx); kill {
 					return
 				}
-
-				var closeErr wsutil.ClosedError
-				if errors.As(err, &closeErr) {
-					return
-				} else if errors.Is(err, syscall.EPIPE) {
-					return
+				if wc.rw == nil || wc.rw.Reader.Buffered() == 0 {
+					break
 				}
+			}
 
-				log.Error().Err(err).Msg("failed to read message")
-				return
-			} else if op == ws.OpClose {
+			// the poller dropped the connection when it reported it (see
+			// Broker.runPoller).
+			if err := wc.br.poller.Add(wc.conn); err != nil && !errors.Is(err, syscall.EEXIST) {
+				log.Error().Err(err).Msg("failed to rearm connection")
 				return
-			} else if op != ws.OpText {
-				continue
 			}
+		}
+	}
+}
 
-			var req ticker.Request
-			if err := json.Unmarshal(msg, &req); err != nil {
-				log.Warn().Err(err).Msg("failed to unmarshal request")
-				continue // ignore invalid requests
-			}
-			wc.br.updateSubs(ctx, wc, req)
+// readRequest reads the next message of the client and applies it if it is
+// a request.
+func (wc *wsClient) readRequest(ctx context.Context) (kill bool) {
+	msg, op, release, err := wc.read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return true
+		}
+
+		var closeErr wsutil.ClosedError
+		if errors.As(err, &closeErr) {
+			return true
+		} else if errors.Is(err, syscall.EPIPE) {
+			return true
 		}
+
+		log.Error().Err(err).Msg("failed to read message")
+		return true
+	} else if op == ws.OpClose {
+		return true
+	} else if op != ws.OpText {
+		release()
+		return false
+	}
+
+	var req ticker.Request
+	err = json.Unmarshal(msg, &req)
+	release()
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to unmarshal request")
+		return false // ignore invalid requests
 	}
+	wc.br.updateSubs(ctx, wc, req)
+	return false
 }
 
-func (wc *wsClient) write(data []byte) (kill bool) {
-	if err := wsutil.WriteServerMessage(wc.rw, ws.OpBinary, data); err != nil {
+// read reads the next data message of the client. release must be called
+// once the message is processed.
+func (wc *wsClient) read() (msg []byte, op ws.OpCode, release func(), err error) {
+	if wc.rw != nil {
+		msg, op, err = wsutil.ReadClientData(wc.rw)
+		return msg, op, func() {}, err
+	}
+
+	buf, op, err := wsframe.ReadPooled(wc.src, wc.conn)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return buf.Bytes(), op, func() { wsframe.PutPayload(buf) }, nil
+}
+
+func (wc *wsClient) write(op ws.OpCode, data []byte) (kill bool) {
+	if wc.rw == nil {
+		if err := wsframe.WritePooled(wc.conn, op, data); err != nil {
+			if !errors.Is(err, syscall.EPIPE) {
+				log.Error().Err(err).Msg("failed to write message")
+			}
+			return true
+		}
+		return false
+	}
+
+	if err := wsutil.WriteServerMessage(wc.rw, op, data); err != nil {
 		if !errors.Is(err, syscall.EPIPE) {
 			log.Error().Err(err).Msg("failed to write message")
 		}
@@ -119,3 +259,9 @@ func (wc *wsClient) write(data []byte) (kill bool) {
 
 	return false
 }
+
+// prepareFrame frames a packet as a binary message (see
+// Options.PreparedFrames).
+func prepareFrame(packet []byte) []byte {
+	return ws.MustCompileFrame(ws.NewBinaryFrame(packet))
+}
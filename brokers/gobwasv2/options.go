@@ -0,0 +1,59 @@
+package gobwasv2
+
+import "github.com/spy16/ticktock/utils"
+
+// Options are the tuning knobs of the broker. Zero values use the defaults.
+type Options struct {
+	// TickQueue is the number of published ticks buffered for dispatch.
+	TickQueue int
+
+	// RequestQueue is the capacity of the subscription request channel and
+	// of the channel of connections with pending reads.
+	RequestQueue int
+
+	// ClientQueue is the number of packets buffered for each client. Clients
+	// that fall further behind block the dispatch.
+	ClientQueue int
+
+	// PooledBuffers releases the bufio buffers of each connection after the
+	// upgrade. Frames are then read and written with buffers borrowed from
+	// a pool for the time of the frame, trading a pool round-trip per frame
+	// for the 8KB each idle connection would otherwise keep.
+	PooledBuffers bool
+
+	// PreparedFrames frames each packet once per tick and mode for all the
+	// subscribers (see ticker.Topics.Prepare). The frames are then written
+	// to the connections as-is.
+	PreparedFrames bool
+
+	// MaxWriteBytes caps the size of the packets a writer drains from the
+	// queue of a client for a single vectored write.
+	MaxWriteBytes int
+
+	// PollBatch is the maximum number of ready connections taken from the
+	// poller at once.
+	PollBatch int
+
+	// Admission, if set, authenticates and limits the clients before their
+	// connections are upgraded.
+	Admission *utils.Admission
+}
+
+func (o Options) withDefaults() Options {
+	if o.TickQueue <= 0 {
+		o.TickQueue = 1 << 20
+	}
+	if o.RequestQueue <= 0 {
+		o.RequestQueue = 200000
+	}
+	if o.ClientQueue <= 0 {
+		o.ClientQueue = 10000
+	}
+	if o.MaxWriteBytes <= 0 {
+		o.MaxWriteBytes = 64 << 10
+	}
+	if o.PollBatch <= 0 {
+		o.PollBatch = 1024
+	}
+	return o
+}
@@ -0,0 +1,137 @@
+package gobwasv2
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smallnest/epoller"
+)
+
+// TestPollerRearm checks that a connection removed from the poller when it
+// is reported, like Broker.runPoller does, is reported again once added
+// back if it received data in the meantime, so that no request is missed.
+func TestPollerRearm(t *testing.T) {
+	p, err := epoller.NewPoller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close(false)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// wake is written to when the test ends to stop the waiting goroutine.
+	wake, wakePeer := connPair(t, l)
+	client, server := connPair(t, l)
+
+	events := make(chan net.Conn, 16)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conns, err := p.Wait(8)
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for _, conn := range conns {
+				if conn == nil {
+					continue
+				}
+				if pConn, ok := conn.(epoller.ConnImpl); ok {
+					conn = pConn.Conn
+				}
+				_ = p.Remove(conn)
+				events <- conn
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		if _, err := wake.Write([]byte{0}); err != nil {
+			t.Error(err)
+		}
+		<-done
+	}()
+	if err := p.Add(wakePeer); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(what string, reported bool) {
+		t.Helper()
+		select {
+		case conn := <-events:
+			if !reported || conn != server {
+				t.Fatalf("%s: unexpected event of %v", what, conn.LocalAddr())
+			}
+		case <-time.After(50 * time.Millisecond):
+			if reported {
+				t.Fatalf("%s: the connection was not reported", what)
+			}
+		}
+	}
+	write := func(data string) {
+		t.Helper()
+		if _, err := client.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.Add(server); err != nil {
+		t.Fatal(err)
+	}
+	expect("no data", false)
+
+	write("a")
+	expect("data", true)
+
+	// data received while removed is reported once the connection is
+	// added back, as when a request arrives right before the rearm.
+	write("b")
+	expect("removed", false)
+	if err := p.Add(server); err != nil {
+		t.Fatal(err)
+	}
+	expect("rearmed with data", true)
+
+	// once drained, the connection is only reported for new data.
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "ab" {
+		t.Fatalf("read %q: %v, want ab", buf, err)
+	}
+	if err := p.Add(server); err != nil {
+		t.Fatal(err)
+	}
+	expect("rearmed without data", false)
+	write("c")
+	expect("new data", true)
+}
+
+// connPair returns both ends of a loopback connection.
+func connPair(t *testing.T, l net.Listener) (client, server net.Conn) {
+	t.Helper()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	server, err = l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = server.Close() })
+	return client, server
+}
@@ -5,7 +5,6 @@ import (
 	"net"
 	"net/http"
 	"sync"
-	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog/log"
@@ -13,37 +12,54 @@ import (
 	"github.com/spy16/ticktock/utils"
 )
 
-func New() (*Broker, error) {
-	return &Broker{
-		topics: make(map[int32]map[*wsClient]ticker.Mode),
+func New(opts Options) (*Broker, error) {
+	opts = opts.withDefaults()
+	br := &Broker{
+		opts:   opts,
+		topics: ticker.NewTopics(),
 		upgrader: &websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
+			ReadBufferSize:  opts.ReadBufferSize,
+			WriteBufferSize: opts.WriteBufferSize,
 			CheckOrigin:     func(r *http.Request) bool { return true },
 		},
-	}, nil
+	}
+	br.topics.ClientQueue = opts.ClientQueue
+	if opts.PreparedFrames {
+		br.topics.Prepare = prepareFrame
+	}
+	return br, nil
 }
 
 // Broker is a broker implementation using gorilla websocket.
 type Broker struct {
+	opts Options
+
 	mu     sync.RWMutex
-	topics map[int32]map[*wsClient]ticker.Mode
+	topics *ticker.Topics
 
 	upgrader *websocket.Upgrader
 }
 
-// Publish publishes the given ticks to all subscribers.
-func (br *Broker) Publish(_ time.Duration, ticks []ticker.Tick) error {
+// Publish publishes the given ticks to all subscribers. Ticks are
+// dispatched synchronously, so all of them are always accepted.
+func (br *Broker) Publish(_ ticker.PublishOptions, ticks []ticker.Tick) (int, error) {
 	br.mu.RLock()
 	defer br.mu.RUnlock()
-	for _, tick := range ticks {
-		subs := br.topics[tick.Instrument]
+	br.topics.Dispatch(ticks)
+	return len(ticks), nil
+}
 
-		for wc, mode := range subs {
-			wc.EnqueuWrite(tick.Compute(mode))
-		}
-	}
-	return nil
+// Topics returns the subscription registry of the broker. It must only be
+// configured before Serve is called.
+func (br *Broker) Topics() *ticker.Topics {
+	return br.topics
+}
+
+// Update runs fn with the subscription registry.
+func (br *Broker) Update(fn func(t *ticker.Topics)) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	fn(br.topics)
 }
 
 // Serve starts the broker server.
@@ -51,7 +67,7 @@ func (br *Broker) Serve(ctx context.Context, addr string) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	return utils.ServeCtx(ctx, addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return utils.ServeCtx(ctx, addr, br.opts.Admission.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		conn, err := br.upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to upgrade connection")
@@ -63,38 +79,31 @@ func (br *Broker) Serve(ctx context.Context, addr string) error {
 		}
 
 		wc := &wsClient{
-			br:     br,
-			conn:   conn,
-			done:   make(chan struct{}),
-			writes: make(chan []byte, 10000),
+			br:      br,
+			conn:    conn,
+			done:    make(chan struct{}),
+			writes:  make(chan []byte, br.opts.ClientQueue),
+			replies: make(chan []byte, 16),
 		}
+		br.connect(ctx, wc, r.URL.Query().Get("session"))
 		go wc.Run(ctx)
-	}))
+	})))
 }
 
-func (br *Broker) updateSubs(ctx context.Context, wc *wsClient, req ticker.Request) {
+func (br *Broker) connect(ctx context.Context, wc *wsClient, token string) {
 	br.mu.Lock()
 	defer br.mu.Unlock()
+	br.topics.Connect(wc, token)
+}
 
-	for _, instr := range req.Instruments {
-		if req.Mode == ticker.ModeNone {
-			if br.topics[instr] != nil {
-				delete(br.topics[instr], wc)
-			}
-		} else {
-			if br.topics[instr] == nil {
-				br.topics[instr] = make(map[*wsClient]ticker.Mode)
-			}
-			br.topics[instr][wc] = req.Mode
-		}
-	}
+func (br *Broker) updateSubs(ctx context.Context, wc *wsClient, req ticker.Request) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.topics.Apply(wc, req)
 }
 
 func (br *Broker) removeSub(ctx context.Context, wc *wsClient) {
 	br.mu.Lock()
 	defer br.mu.Unlock()
-
-	for _, subs := range br.topics {
-		delete(subs, wc)
-	}
+	br.topics.Remove(wc)
 }
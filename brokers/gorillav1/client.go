@@ -2,9 +2,12 @@ package gorillav1
 
 import (
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"io"
+	"net"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -13,18 +16,20 @@ import (
 	"github.com/spy16/ticktock/ticker"
 )
 
-const readTimeout = 60 * time.Second
-
 type wsClient struct {
-	br     *Broker
-	done   chan struct{}
-	conn   *websocket.Conn
-	writes chan []byte
+	br      *Broker
+	done    chan struct{}
+	conn    *websocket.Conn
+	writes  chan []byte
+	replies chan []byte
+	queued  atomic.Int64 // size of the packets in writes.
 }
 
 func (wc *wsClient) EnqueuWrite(msg []byte) {
+	wc.queued.Add(int64(len(msg)))
 	select {
 	case <-wc.done:
+		wc.queued.Add(-int64(len(msg)))
 		return // client is closed
 
 	case wc.writes <- msg:
@@ -32,21 +37,49 @@ func (wc *wsClient) EnqueuWrite(msg []byte) {
 	}
 }
 
+func (wc *wsClient) EnqueuReply(msg []byte) {
+	select {
+	case <-wc.done:
+		return // client is closed
+
+	case wc.replies <- msg:
+		return
+	}
+}
+
+// Memory estimates the memory used by the connection: its read and write
+// buffers, the queues and the 2 goroutines.
+func (wc *wsClient) Memory() (buffers, queued int64) {
+	buffers = int64(wc.br.opts.ReadBufferSize+wc.br.opts.WriteBufferSize) +
+		int64(cap(wc.writes)+cap(wc.replies))*ticker.QueueSlotSize + 2*ticker.StackSize
+	return buffers, wc.queued.Load()
+}
+
+// Shed closes the connection, which stops the reader and then the client.
+func (wc *wsClient) Shed() {
+	_ = wc.conn.Close()
+}
+
+func (wc *wsClient) RemoteAddr() net.Addr {
+	return wc.conn.RemoteAddr()
+}
+
 func (wc *wsClient) Run(ctx context.Context) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer func() {
 		close(wc.done)
 		wc.br.removeSub(ctx, wc)
 		cancel()
+		_ = wc.conn.Close()
 	}()
 
-	if err := wc.conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+	if err := wc.conn.SetReadDeadline(time.Now().Add(wc.br.opts.ReadTimeout)); err != nil {
 		log.Error().Err(err).Msg("failed to set read deadline")
 		return
 	}
 
 	wc.conn.SetPongHandler(func(string) error {
-		return wc.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		return wc.conn.SetReadDeadline(time.Now().Add(wc.br.opts.ReadTimeout))
 	})
 
 	go wc.runReader(ctx, cancel)
@@ -56,12 +89,25 @@ func (wc *wsClient) Run(ctx context.Context) {
 		case <-ctx.Done():
 			return
 
+		case msg := <-wc.replies:
+			if err := wc.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				if isClose(err) {
+					return
+				}
+				log.Error().Err(err).Msg("failed to write reply")
+				return
+			}
+
 		case msg, ok := <-wc.writes:
 			if !ok {
 				return
 			}
+			wc.queued.Add(-int64(len(msg)))
+			if wc.br.topics.Trace {
+				ticker.StampWritten(msg, time.Now())
+			}
 
-			if err := wc.conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+			if err := wc.writePacket(msg); err != nil {
 				if isClose(err) {
 					return
 				}
@@ -72,6 +118,17 @@ func (wc *wsClient) Run(ctx context.Context) {
 	}
 }
 
+// writePacket writes a packet, or its frame with prepared frames. The frame
+// goes out in a single write of the connection, which cannot interleave with
+// the control frames written by gorilla.
+func (wc *wsClient) writePacket(msg []byte) error {
+	if wc.br.opts.PreparedFrames {
+		_, err := wc.conn.UnderlyingConn().Write(msg)
+		return err
+	}
+	return wc.conn.WriteMessage(websocket.BinaryMessage, msg)
+}
+
 func (wc *wsClient) runReader(ctx context.Context, cancel context.CancelFunc) {
 	defer cancel()
 
@@ -122,3 +179,22 @@ func isClose(err error) bool {
 
 	return false
 }
+
+// prepareFrame frames a packet as a binary message (see
+// Options.PreparedFrames). gorilla does not expose its framing, so the
+// header is laid out here as in RFC 6455, section 5.2.
+func prepareFrame(packet []byte) []byte {
+	frame := make([]byte, 0, 10+len(packet))
+	frame = append(frame, 0x80|websocket.BinaryMessage) // FIN and opcode.
+	switch n := len(packet); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	return append(frame, packet...)
+}
@@ -0,0 +1,48 @@
+package gorillav1
+
+import (
+	"time"
+
+	"github.com/spy16/ticktock/utils"
+)
+
+// Options are the tuning knobs of the broker. Zero values use the defaults.
+type Options struct {
+	// ClientQueue is the number of packets buffered for each client. Clients
+	// that fall further behind block the dispatch.
+	ClientQueue int
+
+	// ReadBufferSize and WriteBufferSize are the I/O buffer sizes of each
+	// connection.
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// ReadTimeout is how long a client may stay silent (not even answering
+	// pings) before it is disconnected.
+	ReadTimeout time.Duration
+
+	// PreparedFrames frames each packet once per tick and mode for all the
+	// subscribers (see ticker.Topics.Prepare). The frames are then written
+	// to the connections as-is.
+	PreparedFrames bool
+
+	// Admission, if set, authenticates and limits the clients before their
+	// connections are upgraded.
+	Admission *utils.Admission
+}
+
+func (o Options) withDefaults() Options {
+	if o.ClientQueue <= 0 {
+		o.ClientQueue = 10000
+	}
+	if o.ReadBufferSize <= 0 {
+		o.ReadBufferSize = 1024
+	}
+	if o.WriteBufferSize <= 0 {
+		o.WriteBufferSize = 1024
+	}
+	if o.ReadTimeout <= 0 {
+		o.ReadTimeout = 60 * time.Second
+	}
+	return o
+}
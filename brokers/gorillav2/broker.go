@@ -3,7 +3,6 @@ package gorillav2
 import (
 	"context"
 	"net/http"
-	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog/log"
@@ -11,26 +10,40 @@ import (
 	"github.com/spy16/ticktock/utils"
 )
 
+// dispatchBatch is the maximum number of queued ticks dispatched at once.
+const dispatchBatch = 4096
+
 // New creates a new gorilla websocket broker.
-func New() (*Broker, error) {
-	return &Broker{
-		topics:   make(map[int32]map[*wsClient]ticker.Mode),
-		messages: make(chan []ticker.Tick, 200000),
-		requests: make(chan brokerRequest, 200000),
+func New(opts Options) (*Broker, error) {
+	opts = opts.withDefaults()
+	br := &Broker{
+		opts:     opts,
+		topics:   ticker.NewTopics(),
+		messages: ticker.NewQueue(opts.TickQueue),
+		requests: make(chan brokerRequest, opts.RequestQueue),
+		stopped:  make(chan struct{}),
 		upgrader: &websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
+			ReadBufferSize:  opts.ReadBufferSize,
+			WriteBufferSize: opts.WriteBufferSize,
 			CheckOrigin:     func(r *http.Request) bool { return true },
 		},
-	}, nil
+	}
+	br.topics.ClientQueue = opts.ClientQueue
+	if opts.PreparedFrames {
+		br.topics.Prepare = prepareFrame
+	}
+	return br, nil
 }
 
 // Broker is a broker implementation using gorilla websocket.
 type Broker struct {
-	topics map[int32]map[*wsClient]ticker.Mode
+	opts Options
+
+	topics *ticker.Topics
 
 	requests chan brokerRequest
-	messages chan []ticker.Tick
+	stopped  chan struct{} // closed when the management loop exits.
+	messages *ticker.Queue
 	upgrader *websocket.Upgrader
 }
 
@@ -39,16 +52,31 @@ type brokerRequest struct {
 
 	Client *wsClient
 	Remove bool
+	Update func(t *ticker.Topics)
 }
 
-// Publish publishes the given ticks to all subscribers.
-func (br *Broker) Publish(timeout time.Duration, ticks []ticker.Tick) error {
-	select {
-	case br.messages <- ticks:
-		return nil
+// Publish enqueues the given ticks for delivery to all subscribers.
+func (br *Broker) Publish(opts ticker.PublishOptions, ticks []ticker.Tick) (int, error) {
+	return br.messages.Push(opts, ticks)
+}
+
+// Queue returns the queue of published ticks awaiting dispatch.
+func (br *Broker) Queue() *ticker.Queue {
+	return br.messages
+}
+
+// Topics returns the subscription registry of the broker. It must only be
+// configured before Serve is called.
+func (br *Broker) Topics() *ticker.Topics {
+	return br.topics
+}
 
-	case <-time.After(timeout):
-		return ticker.ErrTimeout
+// Update runs fn with the subscription registry once the pending requests
+// have been applied. It does nothing once the broker has stopped.
+func (br *Broker) Update(fn func(t *ticker.Topics)) {
+	select {
+	case br.requests <- brokerRequest{Update: fn}:
+	case <-br.stopped:
 	}
 }
 
@@ -59,7 +87,7 @@ func (br *Broker) Serve(ctx context.Context, addr string) error {
 
 	go br.runManagement(ctx, cancel)
 
-	return utils.ServeCtx(ctx, addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return utils.ServeCtx(ctx, addr, br.opts.Admission.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		conn, err := br.upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to upgrade connection")
@@ -67,16 +95,19 @@ func (br *Broker) Serve(ctx context.Context, addr string) error {
 		}
 
 		wc := &wsClient{
-			br:     br,
-			conn:   conn,
-			done:   make(chan struct{}),
-			writes: make(chan []byte, 10000),
+			br:      br,
+			conn:    conn,
+			done:    make(chan struct{}),
+			writes:  make(chan []byte, br.opts.ClientQueue),
+			replies: make(chan []byte, 16),
 		}
+		br.connect(ctx, wc, r.URL.Query().Get("session"))
 		go wc.Run(ctx)
-	}))
+	})))
 }
 
 func (br *Broker) runManagement(ctx context.Context, cancel context.CancelFunc) {
+	defer close(br.stopped)
 	defer cancel()
 
 	for {
@@ -84,38 +115,28 @@ func (br *Broker) runManagement(ctx context.Context, cancel context.CancelFunc)
 		case <-ctx.Done():
 			return
 
-		case ticks := <-br.messages:
-			for _, tick := range ticks {
-				subs := br.topics[tick.Instrument]
-
-				for wc, mode := range subs {
-					wc.EnqueuWrite(tick.Compute(mode))
-				}
-			}
+		case <-br.messages.Ready():
+			br.topics.Dispatch(br.messages.Pop(dispatchBatch))
 
 		case cmd := <-br.requests:
-			if cmd.Remove {
-				for _, instr := range cmd.Instruments {
-					delete(br.topics[instr], cmd.Client)
-				}
+			if cmd.Update != nil {
+				cmd.Update(br.topics)
+			} else if cmd.Remove {
+				br.topics.Remove(cmd.Client)
 			} else {
-				for _, instr := range cmd.Instruments {
-					if cmd.Mode == ticker.ModeNone {
-						if br.topics[instr] != nil {
-							delete(br.topics[instr], cmd.Client)
-						}
-					} else {
-						if br.topics[instr] == nil {
-							br.topics[instr] = make(map[*wsClient]ticker.Mode)
-						}
-						br.topics[instr][cmd.Client] = cmd.Mode
-					}
-				}
+				br.topics.Apply(cmd.Client, cmd.Request)
 			}
 		}
 	}
 }
 
+func (br *Broker) connect(ctx context.Context, wc *wsClient, token string) {
+	select {
+	case br.requests <- brokerRequest{Update: func(t *ticker.Topics) { t.Connect(wc, token) }}:
+	case <-ctx.Done():
+	}
+}
+
 func (br *Broker) updateSubs(ctx context.Context, wc *wsClient, req ticker.Request) {
 	select {
 	case br.requests <- brokerRequest{Request: req, Client: wc}:
@@ -0,0 +1,27 @@
+package gorillav2
+
+import (
+	"testing"
+
+	"github.com/spy16/ticktock/brokers/brokertest"
+)
+
+func TestConformance(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) brokertest.Broker {
+		br, err := New(Options{})
+		if err != nil {
+			t.Fatalf("failed to create broker: %v", err)
+		}
+		return br
+	})
+}
+
+func TestConformancePreparedFrames(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) brokertest.Broker {
+		br, err := New(Options{PreparedFrames: true})
+		if err != nil {
+			t.Fatalf("failed to create broker: %v", err)
+		}
+		return br
+	})
+}
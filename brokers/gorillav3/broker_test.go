@@ -0,0 +1,18 @@
+package gorillav3
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spy16/ticktock/brokers/brokertest"
+)
+
+func TestConformance(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) brokertest.Broker {
+		br, err := New(Options{WriteInterval: 5 * time.Millisecond})
+		if err != nil {
+			t.Fatalf("failed to create broker: %v", err)
+		}
+		return br
+	})
+}
@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"net"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -14,18 +16,20 @@ import (
 	"github.com/spy16/ticktock/ticker"
 )
 
-const readTimeout = 60 * time.Second
-
 type wsClient struct {
-	br     *Broker
-	done   chan struct{}
-	conn   *websocket.Conn
-	writes chan []byte
+	br      *Broker
+	done    chan struct{}
+	conn    *websocket.Conn
+	writes  chan []byte
+	replies chan []byte
+	queued  atomic.Int64 // size of the packets in writes.
 }
 
 func (wc *wsClient) EnqueuWrite(msg []byte) {
+	wc.queued.Add(int64(len(msg)))
 	select {
 	case <-wc.done:
+		wc.queued.Add(-int64(len(msg)))
 		return // client is closed
 
 	case wc.writes <- msg:
@@ -33,29 +37,63 @@ func (wc *wsClient) EnqueuWrite(msg []byte) {
 	}
 }
 
+func (wc *wsClient) EnqueuReply(msg []byte) {
+	select {
+	case <-wc.done:
+		return // client is closed
+
+	case wc.replies <- msg:
+		return
+	}
+}
+
+// Memory estimates the memory used by the connection: its read and write
+// buffers, the queues and the 2 goroutines.
+func (wc *wsClient) Memory() (buffers, queued int64) {
+	buffers = int64(wc.br.opts.ReadBufferSize+wc.br.opts.WriteBufferSize) +
+		int64(cap(wc.writes)+cap(wc.replies))*ticker.QueueSlotSize + 2*ticker.StackSize
+	return buffers, wc.queued.Load()
+}
+
+// Shed closes the connection, which stops the reader and then the client.
+func (wc *wsClient) Shed() {
+	_ = wc.conn.Close()
+}
+
+func (wc *wsClient) RemoteAddr() net.Addr {
+	return wc.conn.RemoteAddr()
+}
+
 func (wc *wsClient) Run(ctx context.Context) {
+	// the removal must go through even after the client context is
+	// canceled, e.g., by the reader.
+	brokerCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
 	defer func() {
 		close(wc.done)
-		wc.br.removeSub(ctx, wc)
+		wc.br.removeSub(brokerCtx, wc)
 		cancel()
+		_ = wc.conn.Close()
 	}()
 
-	if err := wc.conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+	if err := wc.conn.SetReadDeadline(time.Now().Add(wc.br.opts.ReadTimeout)); err != nil {
 		log.Error().Err(err).Msg("failed to set read deadline")
 		return
 	}
 
 	wc.conn.SetPongHandler(func(string) error {
-		return wc.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		return wc.conn.SetReadDeadline(time.Now().Add(wc.br.opts.ReadTimeout))
 	})
 
 	go wc.runReader(ctx, cancel)
 
-	writeTick := time.NewTicker(100 * time.Millisecond)
+	writeTick := time.NewTicker(wc.br.opts.WriteInterval)
 	defer writeTick.Stop()
 
+	// traced packets are written as separate messages so that clients can
+	// find their traces.
 	var buf bytes.Buffer
+	var traced [][]byte
 
 	for {
 		select {
@@ -63,10 +101,23 @@ func (wc *wsClient) Run(ctx context.Context) {
 			return
 
 		case <-writeTick.C:
+			for _, msg := range traced {
+				wc.queued.Add(-int64(len(msg)))
+				ticker.StampWritten(msg, time.Now())
+				if err := wc.conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+					if isClose(err) {
+						return
+					}
+					log.Error().Err(err).Msg("failed to write message")
+				}
+			}
+			traced = traced[:0]
+
 			if buf.Len() == 0 {
 				continue
 			}
 
+			wc.queued.Add(-int64(buf.Len()))
 			if err := wc.conn.WriteMessage(websocket.BinaryMessage, buf.Bytes()); err != nil {
 				if isClose(err) {
 					return
@@ -75,11 +126,23 @@ func (wc *wsClient) Run(ctx context.Context) {
 			}
 			buf.Reset()
 
+		case msg := <-wc.replies:
+			if err := wc.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				if isClose(err) {
+					return
+				}
+				log.Error().Err(err).Msg("failed to write reply")
+			}
+
 		case msg, ok := <-wc.writes:
 			if !ok {
 				return
 			}
-			_, _ = buf.Write(msg)
+			if wc.br.topics.Trace {
+				traced = append(traced, msg)
+			} else {
+				_, _ = buf.Write(msg)
+			}
 		}
 	}
 }
@@ -0,0 +1,62 @@
+package gorillav3
+
+import (
+	"time"
+
+	"github.com/spy16/ticktock/utils"
+)
+
+// Options are the tuning knobs of the broker. Zero values use the defaults.
+type Options struct {
+	// TickQueue is the number of published ticks buffered for dispatch.
+	TickQueue int
+
+	// RequestQueue is the capacity of the subscription request channel.
+	RequestQueue int
+
+	// ClientQueue is the number of packets buffered for each client. Clients
+	// that fall further behind block the dispatch.
+	ClientQueue int
+
+	// ReadBufferSize and WriteBufferSize are the I/O buffer sizes of each
+	// connection.
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// ReadTimeout is how long a client may stay silent (not even answering
+	// pings) before it is disconnected.
+	ReadTimeout time.Duration
+
+	// WriteInterval is how often the packets queued for a client are written
+	// out together.
+	WriteInterval time.Duration
+
+	// Admission, if set, authenticates and limits the clients before their
+	// connections are upgraded.
+	Admission *utils.Admission
+}
+
+func (o Options) withDefaults() Options {
+	if o.TickQueue <= 0 {
+		o.TickQueue = 1 << 20
+	}
+	if o.RequestQueue <= 0 {
+		o.RequestQueue = 200000
+	}
+	if o.ClientQueue <= 0 {
+		o.ClientQueue = 10000
+	}
+	if o.ReadBufferSize <= 0 {
+		o.ReadBufferSize = 1024
+	}
+	if o.WriteBufferSize <= 0 {
+		o.WriteBufferSize = 1024
+	}
+	if o.ReadTimeout <= 0 {
+		o.ReadTimeout = 60 * time.Second
+	}
+	if o.WriteInterval <= 0 {
+		o.WriteInterval = 100 * time.Millisecond
+	}
+	return o
+}
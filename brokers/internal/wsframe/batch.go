@@ -0,0 +1,96 @@
+// Package wsframe batches the frames and pools the buffers of the brokers
+// built on gobwas/ws.
+package wsframe
+
+import (
+	"bytes"
+	"net"
+	"sync"
+
+	"github.com/gobwas/ws"
+	"github.com/spy16/ticktock/ticker"
+)
+
+// MaxWriteFrames is the maximum number of frames of a vectored write: with
+// a header and a payload per frame, it fills the 1024 vectors a writev
+// takes at most.
+const MaxWriteFrames = 512
+
+// batchPool holds the batches of the writers that only borrow them for the
+// time of a write, so that idle connections do not keep them.
+var batchPool = sync.Pool{New: func() any { return NewBatch() }}
+
+// Batch gathers frames for a single vectored write.
+type Batch struct {
+	vecs    net.Buffers
+	headers bytes.Buffer // never grows, so that vecs can point into it.
+	flat    []byte       // the frames copied for the connections without writev.
+	frames  int
+	size    int
+}
+
+func NewBatch() *Batch {
+	b := &Batch{vecs: make(net.Buffers, 0, 2*MaxWriteFrames)}
+	b.headers.Grow(MaxWriteFrames * ws.MaxHeaderSize)
+	return b
+}
+
+// GetBatch borrows a batch from the pool. It must be returned with PutBatch
+// once written.
+func GetBatch() *Batch {
+	return batchPool.Get().(*Batch)
+}
+
+func PutBatch(b *Batch) {
+	batchPool.Put(b)
+}
+
+// Add adds a message to the batch. Prepared messages are frames already.
+func (b *Batch) Add(op ws.OpCode, data []byte, prepared bool) {
+	if !prepared {
+		start := b.headers.Len()
+		_ = ws.WriteHeader(&b.headers, ws.Header{Fin: true, OpCode: op, Length: int64(len(data))})
+		b.vecs = append(b.vecs, b.headers.Bytes()[start:])
+	}
+	b.vecs = append(b.vecs, data)
+	b.frames++
+	b.size += len(data)
+}
+
+// Full reports whether the batch has reached the limits of a write.
+func (b *Batch) Full(maxBytes int) bool {
+	return b.frames >= MaxWriteFrames || b.size >= maxBytes
+}
+
+// WriteTo writes the frames of the batch to the connection, counts the
+// write and resets the batch. It takes no lock: it relies on the frames
+// going out in a single write call, which the runtime serialises with the
+// other writes of the connection, so that they cannot interleave with the
+// replies to control frames. Only TCP and Unix connections take the frames
+// in a vectored write; they are copied into a single buffer for the others
+// (e.g., TLS), since net.Buffers would write them one at a time.
+func (b *Batch) WriteTo(conn net.Conn, counter *ticker.WriteCounter) error {
+	var n int64
+	var err error
+	switch conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		vecs := b.vecs
+		n, err = vecs.WriteTo(conn)
+
+	default:
+		b.flat = b.flat[:0]
+		for _, vec := range b.vecs {
+			b.flat = append(b.flat, vec...)
+		}
+		var written int
+		written, err = conn.Write(b.flat)
+		n = int64(written)
+	}
+	counter.Record(b.frames, int(n))
+
+	clear(b.vecs)
+	b.vecs = b.vecs[:0]
+	b.headers.Reset()
+	b.frames, b.size = 0, 0
+	return err
+}
@@ -0,0 +1,181 @@
+package wsframe
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/spy16/ticktock/ticker"
+)
+
+func TestBatch(t *testing.T) {
+	prepared := ws.MustCompileFrame(ws.NewBinaryFrame([]byte("prepared")))
+
+	tests := []struct {
+		name     string
+		add      func(b *Batch)
+		want     []string
+		maxBytes int
+		full     bool
+	}{
+		{
+			name: "Framed",
+			add: func(b *Batch) {
+				b.Add(ws.OpBinary, []byte("one"), false)
+				b.Add(ws.OpText, []byte("two"), false)
+			},
+			want:     []string{"one", "two"},
+			maxBytes: 1 << 10,
+		},
+		{
+			name: "Prepared",
+			add: func(b *Batch) {
+				b.Add(ws.OpBinary, prepared, true)
+				b.Add(ws.OpBinary, []byte("framed"), false)
+			},
+			want:     []string{"prepared", "framed"},
+			maxBytes: 1 << 10,
+		},
+		{
+			name:     "FullBytes",
+			add:      func(b *Batch) { b.Add(ws.OpBinary, []byte("12345678"), false) },
+			want:     []string{"12345678"},
+			maxBytes: 8,
+			full:     true,
+		},
+		{
+			name: "FullFrames",
+			add: func(b *Batch) {
+				for i := 0; i < MaxWriteFrames; i++ {
+					b.Add(ws.OpBinary, []byte{byte(i)}, false)
+				}
+			},
+			want:     make([]string, MaxWriteFrames),
+			maxBytes: 1 << 20,
+			full:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBatch()
+			tt.add(b)
+			if got := b.Full(tt.maxBytes); got != tt.full {
+				t.Fatalf("Full() = %t, want %t", got, tt.full)
+			}
+
+			server, client := net.Pipe()
+			defer client.Close()
+
+			var counter ticker.WriteCounter
+			errs := make(chan error, 1)
+			go func() {
+				errs <- b.WriteTo(server, &counter)
+				server.Close()
+			}()
+
+			for i, want := range tt.want {
+				frame, err := ws.ReadFrame(client)
+				if err != nil {
+					t.Fatalf("failed to read frame %d: %v", i, err)
+				}
+				if want != "" && string(frame.Payload) != want {
+					t.Fatalf("frame %d = %q, want %q", i, frame.Payload, want)
+				}
+			}
+			if err := <-errs; err != nil {
+				t.Fatalf("WriteTo() error = %v", err)
+			}
+
+			if stats := counter.Stats(); stats.Writes != 1 || stats.Frames != uint64(len(tt.want)) {
+				t.Fatalf("counted %d writes of %d frames, want 1 of %d", stats.Writes, stats.Frames, len(tt.want))
+			}
+			if b.Full(tt.maxBytes) || len(b.vecs) != 0 || b.headers.Len() != 0 {
+				t.Fatal("batch not reset after the write")
+			}
+		})
+	}
+}
+
+func TestReadPooled(t *testing.T) {
+	var in bytes.Buffer
+	_ = ws.WriteFrame(&in, ws.MaskFrame(ws.NewPingFrame([]byte("ping"))))
+	_ = ws.WriteFrame(&in, ws.MaskFrame(ws.NewTextFrame([]byte(`{"a":"list"}`))))
+
+	var out bytes.Buffer
+	buf, op, err := ReadPooled(&in, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer PutPayload(buf)
+
+	if op != ws.OpText || buf.String() != `{"a":"list"}` {
+		t.Fatalf("ReadPooled() = %q (op %d), want the text message", buf, op)
+	}
+	pong, err := ws.ReadFrame(&out)
+	if err != nil || pong.Header.OpCode != ws.OpPong || string(pong.Payload) != "ping" {
+		t.Fatalf("expected a pong to the ping, got %+v: %v", pong.Header, err)
+	}
+}
+
+// writeCounting counts the writes to a connection that does not take
+// vectored writes.
+type writeCounting struct {
+	net.Conn
+	writes int
+}
+
+func (wc *writeCounting) Write(p []byte) (int, error) {
+	wc.writes++
+	return wc.Conn.Write(p)
+}
+
+func TestBatchSingleWrite(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tcp, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	peer, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	// the frames go out in a single write whether the connection takes
+	// vectored writes or not.
+	wrapped := &writeCounting{Conn: tcp}
+	for _, conn := range []net.Conn{tcp, wrapped} {
+		b := NewBatch()
+		for _, msg := range []string{"one", "two", "three"} {
+			b.Add(ws.OpBinary, []byte(msg), false)
+		}
+		var counter ticker.WriteCounter
+		if err := b.WriteTo(conn, &counter); err != nil {
+			t.Fatal(err)
+		}
+		if stats := counter.Stats(); stats.Writes != 1 || stats.Frames != 3 || stats.Bytes != 17 {
+			t.Fatalf("%T: counted %+v, want 1 write of 3 frames and 17 bytes", conn, stats)
+		}
+
+		for _, want := range []string{"one", "two", "three"} {
+			frame, err := ws.ReadFrame(peer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(frame.Payload) != want {
+				t.Fatalf("%T: frame %q, want %q", conn, frame.Payload, want)
+			}
+		}
+	}
+	if wrapped.writes != 1 {
+		t.Fatalf("%d writes to the wrapped connection, want 1", wrapped.writes)
+	}
+}
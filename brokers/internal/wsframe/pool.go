@@ -0,0 +1,107 @@
+package wsframe
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+const (
+	// pooledBufferSize is the size of the write buffers of the pool.
+	pooledBufferSize = 4096
+
+	// maxPooledPayload is the capacity beyond which read buffers are not
+	// returned to the pool, so that a large request does not pin memory.
+	maxPooledPayload = 64 << 10
+)
+
+// Buffers borrowed for the time of a frame.
+var (
+	writerPool  = sync.Pool{New: func() any { return bufio.NewWriterSize(nil, pooledBufferSize) }}
+	payloadPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+)
+
+// ReleaseBuffers drops the bufio buffers of the upgrade. It returns the
+// source of the frames of the client: the bytes it sent along with the
+// handshake (if any) followed by the connection.
+func ReleaseBuffers(conn net.Conn, rw *bufio.ReadWriter) io.Reader {
+	n := rw.Reader.Buffered()
+	if n == 0 {
+		return conn
+	}
+
+	pending := make([]byte, n)
+	_, _ = io.ReadFull(rw.Reader, pending)
+	return io.MultiReader(bytes.NewReader(pending), conn)
+}
+
+// ReadPooled reads the next data message from src into a buffer of the
+// pool, which must be returned with PutPayload once the message is
+// processed. Control frames are replied to on w.
+func ReadPooled(src io.Reader, w io.Writer) (*bytes.Buffer, ws.OpCode, error) {
+	controlHandler := wsutil.ControlFrameHandler(w, ws.StateServerSide)
+	rd := wsutil.Reader{
+		Source:         src,
+		State:          ws.StateServerSide,
+		CheckUTF8:      true,
+		OnIntermediate: controlHandler,
+	}
+
+	for {
+		hdr, err := rd.NextFrame()
+		if err != nil {
+			return nil, 0, err
+		}
+		if hdr.OpCode.IsControl() {
+			if err := controlHandler(hdr, &rd); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+
+		buf, err := ReadPayload(&rd)
+		if err != nil {
+			return nil, 0, err
+		}
+		return buf, hdr.OpCode, nil
+	}
+}
+
+// ReadPayload reads the payload of a frame from rd into a buffer of the
+// pool, which must be returned with PutPayload.
+func ReadPayload(rd io.Reader) (*bytes.Buffer, error) {
+	buf := payloadPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	if _, err := buf.ReadFrom(rd); err != nil {
+		PutPayload(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+func PutPayload(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledPayload {
+		payloadPool.Put(buf)
+	}
+}
+
+// WritePooled writes a message to w through a buffer of the pool, so that
+// the header and the payload go out in a single write.
+func WritePooled(w io.Writer, op ws.OpCode, data []byte) error {
+	bw := writerPool.Get().(*bufio.Writer)
+	bw.Reset(w)
+	defer func() {
+		bw.Reset(nil)
+		writerPool.Put(bw)
+	}()
+
+	if err := wsutil.WriteServerMessage(bw, op, data); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,358 @@
+package netpollv1
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/rs/zerolog/log"
+	"github.com/spy16/ticktock/brokers/internal/wsframe"
+	"github.com/spy16/ticktock/ticker"
+	"github.com/spy16/ticktock/utils"
+)
+
+const (
+	// dispatchBatch is the maximum number of queued ticks dispatched at once.
+	dispatchBatch = 4096
+
+	// pollTimeout is the time the poller waits for ready connections before
+	// checking whether the broker is stopped.
+	pollTimeout = 100 * time.Millisecond
+)
+
+func New(opts Options) *Broker {
+	opts = opts.withDefaults()
+	p, err := newPoller(opts.PollBatch)
+	if err != nil {
+		panic(err)
+	}
+
+	br := &Broker{
+		opts:    opts,
+		poller:  p,
+		clients: make(map[int]*wsClient),
+		topics:  ticker.NewTopics(),
+
+		ready:    newReadyQueue(opts.Workers),
+		messages: ticker.NewQueue(opts.TickQueue),
+		requests: make(chan brokerRequest, opts.RequestQueue),
+		stopped:  make(chan struct{}),
+	}
+	br.topics.ClientQueue = opts.ClientQueue
+	if opts.PreparedFrames {
+		br.topics.Prepare = prepareFrame
+	}
+	return br
+}
+
+// Broker serves the clients from a fixed pool of workers: the poller reports
+// the connections with bytes to read and the dispatch the ones with packets
+// to write, and a worker then handles the connection until it has nothing
+// left to do. No goroutine is kept per connection.
+type Broker struct {
+	opts Options
+
+	mu      sync.RWMutex
+	clients map[int]*wsClient // by file descriptor.
+	gen     uint32
+
+	poller *poller
+	topics *ticker.Topics
+
+	ready    *readyQueue
+	requests chan brokerRequest
+	stopped  chan struct{} // closed when the management loop exits.
+	messages *ticker.Queue
+	writes   ticker.WriteCounter
+}
+
+type brokerRequest struct {
+	ticker.Request
+
+	Client *wsClient
+	Remove bool
+	Update func(t *ticker.Topics)
+}
+
+// Publish enqueues the given ticks for delivery to all subscribers.
+func (br *Broker) Publish(opts ticker.PublishOptions, ticks []ticker.Tick) (int, error) {
+	return br.messages.Push(opts, ticks)
+}
+
+// Queue returns the queue of published ticks awaiting dispatch.
+func (br *Broker) Queue() *ticker.Queue {
+	return br.messages
+}
+
+// Topics returns the subscription registry of the broker. It must only be
+// configured before Serve is called.
+func (br *Broker) Topics() *ticker.Topics {
+	return br.topics
+}
+
+// WriteStats returns the counts of the vectored writes of the clients.
+func (br *Broker) WriteStats() ticker.WriteStats {
+	return br.writes.Stats()
+}
+
+// Update runs fn with the subscription registry once the pending requests
+// have been applied. It does nothing once the broker has stopped.
+func (br *Broker) Update(fn func(t *ticker.Topics)) {
+	select {
+	case br.requests <- brokerRequest{Update: fn}:
+	case <-br.stopped:
+	}
+}
+
+// Serve starts the broker server.
+func (br *Broker) Serve(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < br.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			br.runWorker(ctx)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		br.runPoller(ctx, cancel)
+	}()
+	go br.runManagement(ctx, cancel)
+
+	err := utils.ServeCtx(ctx, addr, br.opts.Admission.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, _, err := ws.UpgradeHTTP(r, w)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to upgrade connection")
+			return
+		}
+
+		if tc, ok := conn.(*net.TCPConn); ok {
+			tc.SetNoDelay(true)
+		}
+
+		fd, err := connFd(conn)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get connection descriptor")
+			_ = conn.Close()
+			return
+		}
+
+		// the client is not scheduled until it is connected, so that its
+		// requests are not applied before.
+		wc := &wsClient{br: br, conn: conn, fd: fd, src: conn, scheduled: true}
+		if n := rw.Reader.Buffered(); n > 0 {
+			// the poller will not report the bytes received along with the
+			// handshake.
+			pending := make([]byte, n)
+			_, _ = io.ReadFull(rw.Reader, pending)
+			wc.handshake = bytes.NewReader(pending)
+			wc.src = io.MultiReader(wc.handshake, conn)
+			wc.readable = true
+		}
+
+		br.mu.Lock()
+		br.gen++
+		wc.gen = br.gen
+		br.clients[fd] = wc
+		br.mu.Unlock()
+
+		// the client is only connected once polled, since a client that
+		// cannot be polled would never be removed.
+		if err := br.poller.add(fd, wc.gen); err != nil {
+			log.Error().Err(err).Msg("failed to add connection to poller")
+			br.release(wc)
+			_ = conn.Close()
+			return
+		}
+
+		br.connect(ctx, wc, r.URL.Query().Get("session"))
+		wc.start()
+	})))
+
+	// unblock the workers waiting on a connection before closing them all.
+	cancel()
+	br.mu.RLock()
+	for _, wc := range br.clients {
+		_ = wc.conn.SetDeadline(time.Now())
+	}
+	br.mu.RUnlock()
+	wg.Wait()
+
+	br.mu.Lock()
+	for fd, wc := range br.clients {
+		_ = wc.conn.Close()
+		delete(br.clients, fd)
+	}
+	br.mu.Unlock()
+	_ = br.poller.close()
+
+	return err
+}
+
+func (br *Broker) runManagement(ctx context.Context, cancel context.CancelFunc) {
+	defer close(br.stopped)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-br.messages.Ready():
+			br.topics.Dispatch(br.messages.Pop(dispatchBatch))
+
+		case cmd := <-br.requests:
+			if cmd.Update != nil {
+				cmd.Update(br.topics)
+			} else if cmd.Remove {
+				br.topics.Remove(cmd.Client)
+			} else {
+				br.topics.Apply(cmd.Client, cmd.Request)
+			}
+		}
+	}
+}
+
+func (br *Broker) runPoller(ctx context.Context, cancel context.CancelFunc) {
+	defer cancel()
+
+	var ready []readyConn
+	for ctx.Err() == nil {
+		var err error
+		ready, err = br.poller.wait(ready[:0], pollTimeout)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to poll")
+			return
+		}
+
+		br.mu.RLock()
+		for _, rc := range ready {
+			if wc := br.clients[rc.fd]; wc != nil && wc.gen == rc.gen {
+				wc.setReadable()
+			}
+		}
+		br.mu.RUnlock()
+	}
+}
+
+// runWorker handles the ready clients until the context is canceled. The
+// write batch is shared by the clients the worker handles.
+func (br *Broker) runWorker(ctx context.Context) {
+	b := wsframe.NewBatch()
+
+	var batch []frame
+	for ctx.Err() == nil {
+		wc := br.ready.pop()
+		if wc == nil {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-br.ready.wake:
+			}
+			continue
+		}
+
+		batch = wc.process(ctx, b, batch)
+	}
+}
+
+// release stops polling the connection of the client. It must be done
+// before the connection is closed, which frees its descriptor for reuse.
+func (br *Broker) release(wc *wsClient) {
+	br.mu.Lock()
+	if br.clients[wc.fd] == wc {
+		delete(br.clients, wc.fd)
+	}
+	br.mu.Unlock()
+
+	_ = br.poller.remove(wc.fd)
+}
+
+func (br *Broker) connect(ctx context.Context, wc *wsClient, token string) {
+	select {
+	case br.requests <- brokerRequest{Update: func(t *ticker.Topics) { t.Connect(wc, token) }}:
+	case <-ctx.Done():
+	}
+}
+
+func (br *Broker) updateSubs(ctx context.Context, wc *wsClient, req ticker.Request) {
+	select {
+	case br.requests <- brokerRequest{Request: req, Client: wc}:
+	case <-ctx.Done():
+	}
+}
+
+func (br *Broker) removeSub(ctx context.Context, wc *wsClient) {
+	select {
+	case br.requests <- brokerRequest{Client: wc, Remove: true}:
+	case <-ctx.Done():
+	}
+}
+
+// readyQueue is the queue of the clients with work pending, shared by the
+// workers. It is unbounded so that neither the dispatch nor the poller ever
+// wait on the workers; a client is in it at most once.
+type readyQueue struct {
+	mu      sync.Mutex
+	clients []*wsClient
+
+	wake chan struct{}
+}
+
+func newReadyQueue(workers int) *readyQueue {
+	return &readyQueue{wake: make(chan struct{}, workers)}
+}
+
+func (q *readyQueue) push(wc *wsClient) {
+	q.mu.Lock()
+	q.clients = append(q.clients, wc)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default: // the workers are awake already.
+	}
+}
+
+func (q *readyQueue) pop() *wsClient {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.clients) == 0 {
+		return nil
+	}
+	wc := q.clients[0]
+	q.clients[0] = nil
+	q.clients = q.clients[1:]
+	return wc
+}
+
+func connFd(conn net.Conn) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, errors.New("connection has no file descriptor")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var fd int
+	if err := rc.Control(func(s uintptr) { fd = int(s) }); err != nil {
+		return 0, err
+	}
+	return fd, nil
+}
@@ -0,0 +1,19 @@
+package netpollv1
+
+import (
+	"testing"
+
+	"github.com/spy16/ticktock/brokers/brokertest"
+)
+
+func TestConformance(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) brokertest.Broker {
+		return New(Options{})
+	})
+}
+
+func TestConformancePreparedFrames(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) brokertest.Broker {
+		return New(Options{PreparedFrames: true})
+	})
+}
@@ -0,0 +1,287 @@
+package netpollv1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/rs/zerolog/log"
+	"github.com/spy16/ticktock/brokers/internal/wsframe"
+	"github.com/spy16/ticktock/ticker"
+)
+
+// frameSize is the size of a slot of the write queue of a client.
+const frameSize = int64(unsafe.Sizeof(frame{}))
+
+// frame is a message queued for a client.
+type frame struct {
+	op   ws.OpCode
+	data []byte
+}
+
+type wsClient struct {
+	br        *Broker
+	conn      net.Conn
+	fd        int
+	gen       uint32
+	src       io.Reader     // frames are read from.
+	handshake *bytes.Reader // bytes received along with the handshake, if any.
+
+	mu        sync.Mutex
+	frames    []frame
+	packets   int   // frames that are packets rather than replies.
+	queued    int64 // size of the packets in frames.
+	readable  bool
+	scheduled bool // pending in the ready queue, handled by a worker or not connected yet.
+	closing   bool
+	closed    bool
+}
+
+func (wc *wsClient) EnqueuWrite(msg []byte) {
+	wc.enqueue(ws.OpBinary, msg)
+}
+
+func (wc *wsClient) EnqueuReply(msg []byte) {
+	wc.enqueue(ws.OpText, msg)
+}
+
+func (wc *wsClient) enqueue(op ws.OpCode, msg []byte) {
+	wc.mu.Lock()
+	if wc.closed || wc.closing {
+		wc.mu.Unlock()
+		return // client is closed
+	}
+	if op == ws.OpBinary && wc.packets >= wc.br.opts.ClientQueue {
+		wc.mu.Unlock()
+		log.Warn().Str("addr", wc.RemoteAddr().String()).Msg("client queue is full, disconnecting")
+		wc.kill()
+		return
+	}
+
+	wc.frames = append(wc.frames, frame{op: op, data: msg})
+	if op == ws.OpBinary {
+		wc.packets++
+		wc.queued += int64(len(msg))
+	}
+	schedule := wc.scheduleLocked()
+	wc.mu.Unlock()
+
+	if schedule {
+		wc.br.ready.push(wc)
+	}
+}
+
+// Memory estimates the memory used by the connection: its write queue,
+// since the buffers and goroutines belong to the workers.
+func (wc *wsClient) Memory() (buffers, queued int64) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	buffers = int64(cap(wc.frames)) * frameSize
+	if wc.handshake != nil {
+		buffers += wc.handshake.Size()
+	}
+	return buffers, wc.queued
+}
+
+// Shed closes the connection. A worker waiting on it is unblocked.
+func (wc *wsClient) Shed() {
+	wc.kill()
+	_ = wc.conn.SetDeadline(time.Now())
+}
+
+func (wc *wsClient) RemoteAddr() net.Addr {
+	return wc.conn.RemoteAddr()
+}
+
+// setReadable schedules a read of the connection.
+func (wc *wsClient) setReadable() {
+	wc.mu.Lock()
+	if wc.closed {
+		wc.mu.Unlock()
+		return
+	}
+	wc.readable = true
+	schedule := wc.scheduleLocked()
+	wc.mu.Unlock()
+
+	if schedule {
+		wc.br.ready.push(wc)
+	}
+}
+
+// start schedules the client once connected, if it received a request or
+// was killed in the meantime.
+func (wc *wsClient) start() {
+	wc.mu.Lock()
+	wc.scheduled = false
+	schedule := (wc.readable || wc.closing) && wc.scheduleLocked()
+	wc.mu.Unlock()
+
+	if schedule {
+		wc.br.ready.push(wc)
+	}
+}
+
+// kill schedules the client to be closed by a worker.
+func (wc *wsClient) kill() {
+	wc.mu.Lock()
+	if wc.closed {
+		wc.mu.Unlock()
+		return
+	}
+	wc.closing = true
+	schedule := wc.scheduleLocked()
+	wc.mu.Unlock()
+
+	if schedule {
+		wc.br.ready.push(wc)
+	}
+}
+
+// scheduleLocked marks the client as scheduled and reports whether it must
+// be pushed to the ready queue, i.e., it was not scheduled already.
+func (wc *wsClient) scheduleLocked() bool {
+	if wc.scheduled {
+		return false
+	}
+	wc.scheduled = true
+	return true
+}
+
+// process reads and writes the connection until it has nothing left to do.
+// batch is reused for the frames to write and returned for the next client.
+func (wc *wsClient) process(ctx context.Context, b *wsframe.Batch, batch []frame) []frame {
+	for {
+		wc.mu.Lock()
+		if wc.closed {
+			wc.mu.Unlock()
+			return batch
+		}
+		if wc.closing {
+			wc.mu.Unlock()
+			wc.close(ctx)
+			return batch
+		}
+
+		readable := wc.readable
+		batch = append(batch[:0], wc.frames...)
+		clear(wc.frames)
+		wc.frames = wc.frames[:0]
+		wc.packets, wc.queued = 0, 0
+		wc.readable = false
+		if !readable && len(batch) == 0 {
+			wc.scheduled = false
+			wc.mu.Unlock()
+			return batch
+		}
+		wc.mu.Unlock()
+
+		if readable {
+			if kill := wc.read(ctx); kill {
+				wc.close(ctx)
+				return batch
+			}
+		}
+		if len(batch) > 0 {
+			kill := wc.write(b, batch)
+			clear(batch)
+			if kill {
+				wc.close(ctx)
+				return batch
+			}
+		}
+	}
+}
+
+// close stops polling the connection, closes it and removes the client.
+func (wc *wsClient) close(ctx context.Context) {
+	wc.mu.Lock()
+	if wc.closed {
+		wc.mu.Unlock()
+		return
+	}
+	wc.closed = true
+	clear(wc.frames)
+	wc.frames, wc.packets, wc.queued = nil, 0, 0
+	wc.mu.Unlock()
+
+	wc.br.release(wc)
+	_ = wc.conn.Close()
+	wc.br.removeSub(ctx, wc)
+}
+
+// read reads a frame of the client (and any others received along with the
+// handshake) and rearms the connection for the next one. The read blocks
+// until the whole frame is received: a client that sends part of a frame
+// holds the worker for up to Options.IOTimeout, and is then disconnected.
+func (wc *wsClient) read(ctx context.Context) (kill bool) {
+	// control frames are replied to while reading.
+	_ = wc.conn.SetDeadline(time.Now().Add(wc.br.opts.IOTimeout))
+
+	for {
+		buf, op, err := readFrame(wc.src, wc.conn)
+		if err != nil {
+			var closeErr wsutil.ClosedError
+			if !errors.Is(err, io.EOF) && !errors.As(err, &closeErr) && !errors.Is(err, syscall.EPIPE) &&
+				!errors.Is(err, syscall.ECONNRESET) {
+				log.Error().Err(err).Msg("failed to read message")
+			}
+			return true
+		}
+
+		if buf != nil {
+			if op == ws.OpText {
+				var req ticker.Request
+				if err := json.Unmarshal(buf.Bytes(), &req); err != nil {
+					log.Warn().Err(err).Msg("failed to unmarshal request")
+				} else {
+					wc.br.updateSubs(ctx, wc, req)
+				}
+			}
+			wsframe.PutPayload(buf)
+		}
+
+		if wc.handshake == nil || wc.handshake.Len() == 0 {
+			break
+		}
+	}
+
+	if err := wc.br.poller.rearm(wc.fd, wc.gen); err != nil {
+		log.Error().Err(err).Msg("failed to rearm connection")
+		return true
+	}
+	return false
+}
+
+// write writes the frames in vectored writes of up to
+// Options.MaxWriteBytes.
+func (wc *wsClient) write(b *wsframe.Batch, batch []frame) (kill bool) {
+	now := time.Now()
+	_ = wc.conn.SetWriteDeadline(now.Add(wc.br.opts.IOTimeout))
+	for i, f := range batch {
+		if f.op == ws.OpBinary && wc.br.topics.Trace {
+			ticker.StampWritten(f.data, now)
+		}
+		b.Add(f.op, f.data, f.op == ws.OpBinary && wc.br.opts.PreparedFrames)
+
+		if i == len(batch)-1 || b.Full(wc.br.opts.MaxWriteBytes) {
+			if err := b.WriteTo(wc.conn, &wc.br.writes); err != nil {
+				if !errors.Is(err, syscall.EPIPE) {
+					log.Error().Err(err).Msg("failed to write messages")
+				}
+				return true
+			}
+		}
+	}
+	return false
+}
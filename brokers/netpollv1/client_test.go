@@ -0,0 +1,161 @@
+package netpollv1
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/spy16/ticktock/ticker"
+)
+
+// serve serves the broker on a loopback port until the test ends and
+// returns its address.
+func serve(t *testing.T, br *Broker) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = br.Serve(ctx, addr)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+			return addr
+		} else if time.Now().After(deadline) {
+			t.Fatalf("the broker is not listening: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// subscribeFrame returns a masked client frame of a subscription request.
+func subscribeFrame(t *testing.T, instruments ...int32) []byte {
+	t.Helper()
+
+	req, err := json.Marshal(ticker.Request{Mode: ticker.ModeLTP, Instruments: instruments})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := ws.WriteFrame(&buf, ws.MaskFrameInPlace(ws.NewTextFrame(req))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readAck reads the messages of the server until the subscription ack.
+func readAck(t *testing.T, rd *bufio.Reader, conn net.Conn) ticker.Ack {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		msg, op, err := wsutil.ReadServerData(struct {
+			io.Reader
+			io.Writer
+		}{rd, conn})
+		if err != nil {
+			t.Fatalf("no ack: %v", err)
+		}
+
+		var ack ticker.Ack
+		if op == ws.OpText && json.Unmarshal(msg, &ack) == nil && ack.Action == ticker.ActionAck {
+			return ack
+		}
+	}
+}
+
+func TestHandshakeRequest(t *testing.T) {
+	addr := serve(t, New(Options{}))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the request is sent along with the handshake, so that the server
+	// reads it with the handshake rather than polling it.
+	upgrade := "GET / HTTP/1.1\r\nHost: " + addr + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write(append([]byte(upgrade), subscribeFrame(t, 1, 2)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	rd := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(rd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status %d", resp.StatusCode)
+	}
+	if ack := readAck(t, rd, conn); len(ack.Instruments) != 2 {
+		t.Fatalf("ack %+v, want the 2 instruments", ack)
+	}
+}
+
+// TestPartialFrame checks the documented limit of the workers: a client that
+// sends part of a frame holds a worker until IOTimeout, and is then
+// disconnected.
+func TestPartialFrame(t *testing.T) {
+	const ioTimeout = 300 * time.Millisecond
+	addr := serve(t, New(Options{Workers: 1, IOTimeout: ioTimeout}))
+
+	dial := func() net.Conn {
+		t.Helper()
+		conn, _, _, err := ws.Dial(context.Background(), "ws://"+addr+"/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn
+	}
+
+	stalled := dial()
+	frame := subscribeFrame(t, 1)
+	start := time.Now()
+	if _, err := stalled.Write(frame[:3]); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// the only worker is waiting on the stalled client.
+	conn := dial()
+	if _, err := conn.Write(subscribeFrame(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+	readAck(t, bufio.NewReader(conn), conn)
+	if elapsed := time.Since(start); elapsed < ioTimeout || elapsed > 3*ioTimeout {
+		t.Errorf("request served after %v, want after the IO timeout of %v", elapsed, ioTimeout)
+	}
+
+	_ = stalled.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stalled.Read(make([]byte, 1)); err == nil {
+		t.Fatal("the stalled client is still connected")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("the stalled client was not disconnected")
+	}
+}
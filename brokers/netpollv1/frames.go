@@ -0,0 +1,44 @@
+package netpollv1
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/spy16/ticktock/brokers/internal/wsframe"
+)
+
+// readFrame reads the next frame from src. A data message is read into a
+// buffer of the pool, which must be returned with wsframe.PutPayload once
+// it is processed. A control frame is replied to on w and returns no
+// buffer, so that a worker never waits for a frame the client did not send.
+func readFrame(src io.Reader, w io.Writer) (*bytes.Buffer, ws.OpCode, error) {
+	controlHandler := wsutil.ControlFrameHandler(w, ws.StateServerSide)
+	rd := wsutil.Reader{
+		Source:         src,
+		State:          ws.StateServerSide,
+		CheckUTF8:      true,
+		OnIntermediate: controlHandler,
+	}
+
+	hdr, err := rd.NextFrame()
+	if err != nil {
+		return nil, 0, err
+	}
+	if hdr.OpCode.IsControl() {
+		return nil, hdr.OpCode, controlHandler(hdr, &rd)
+	}
+
+	buf, err := wsframe.ReadPayload(&rd)
+	if err != nil {
+		return nil, 0, err
+	}
+	return buf, hdr.OpCode, nil
+}
+
+// prepareFrame frames a packet as a binary message (see
+// Options.PreparedFrames).
+func prepareFrame(packet []byte) []byte {
+	return ws.MustCompileFrame(ws.NewBinaryFrame(packet))
+}
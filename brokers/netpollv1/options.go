@@ -0,0 +1,75 @@
+package netpollv1
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/spy16/ticktock/utils"
+)
+
+// Options are the tuning knobs of the broker. Zero values use the defaults.
+type Options struct {
+	// TickQueue is the number of published ticks buffered for dispatch.
+	TickQueue int
+
+	// RequestQueue is the capacity of the subscription request channel.
+	RequestQueue int
+
+	// ClientQueue is the number of packets buffered for each client. Since
+	// the dispatch must not wait on a worker, clients that fall further
+	// behind are disconnected.
+	ClientQueue int
+
+	// Workers is the number of goroutines reading and writing the frames of
+	// the ready connections.
+	Workers int
+
+	// IOTimeout bounds the time a worker waits on a connection to finish
+	// reading a frame it started receiving or to accept the pending writes.
+	// The client is disconnected once it expires. Until then, the worker
+	// serves no other client, so that Workers clients stalling at once
+	// delay all the others by up to IOTimeout.
+	IOTimeout time.Duration
+
+	// PreparedFrames frames each packet once per tick and mode for all the
+	// subscribers (see ticker.Topics.Prepare). The frames are then written
+	// to the connections as-is.
+	PreparedFrames bool
+
+	// MaxWriteBytes caps the size of the packets written to a client in a
+	// single vectored write.
+	MaxWriteBytes int
+
+	// PollBatch is the maximum number of ready connections taken from the
+	// poller at once.
+	PollBatch int
+
+	// Admission, if set, authenticates and limits the clients before their
+	// connections are upgraded.
+	Admission *utils.Admission
+}
+
+func (o Options) withDefaults() Options {
+	if o.TickQueue <= 0 {
+		o.TickQueue = 1 << 20
+	}
+	if o.RequestQueue <= 0 {
+		o.RequestQueue = 200000
+	}
+	if o.ClientQueue <= 0 {
+		o.ClientQueue = 10000
+	}
+	if o.MaxWriteBytes <= 0 {
+		o.MaxWriteBytes = 64 << 10
+	}
+	if o.Workers <= 0 {
+		o.Workers = max(8, 4*runtime.GOMAXPROCS(0))
+	}
+	if o.IOTimeout <= 0 {
+		o.IOTimeout = 5 * time.Second
+	}
+	if o.PollBatch <= 0 {
+		o.PollBatch = 1024
+	}
+	return o
+}
@@ -0,0 +1,74 @@
+//go:build linux
+
+package netpollv1
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// poller reports the connections with bytes to read. Connections are
+// registered one-shot: once reported, a connection is not reported again
+// until it is rearmed, so that a single worker handles it at a time.
+type poller struct {
+	fd     int
+	events []unix.EpollEvent
+}
+
+// readyConn identifies a reported connection. The generation tells a
+// connection apart from an earlier one that had the same descriptor.
+type readyConn struct {
+	fd  int
+	gen uint32
+}
+
+func newPoller(batch int) (*poller, error) {
+	fd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &poller{fd: fd, events: make([]unix.EpollEvent, batch)}, nil
+}
+
+func (p *poller) add(fd int, gen uint32) error {
+	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_ADD, fd, pollEvent(fd, gen))
+}
+
+func (p *poller) rearm(fd int, gen uint32) error {
+	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd, pollEvent(fd, gen))
+}
+
+func (p *poller) remove(fd int) error {
+	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_DEL, fd, nil)
+}
+
+// wait waits up to timeout for ready connections and appends them to ready.
+// Hang-ups and errors are reported as readable: the read then fails.
+func (p *poller) wait(ready []readyConn, timeout time.Duration) ([]readyConn, error) {
+	n, err := unix.EpollWait(p.fd, p.events, int(timeout.Milliseconds()))
+	if err != nil {
+		if errors.Is(err, unix.EINTR) {
+			return ready, nil
+		}
+		return ready, err
+	}
+
+	for _, ev := range p.events[:n] {
+		ready = append(ready, readyConn{fd: int(ev.Fd), gen: uint32(ev.Pad)})
+	}
+	return ready, nil
+}
+
+func (p *poller) close() error {
+	return unix.Close(p.fd)
+}
+
+func pollEvent(fd int, gen uint32) *unix.EpollEvent {
+	return &unix.EpollEvent{
+		Events: unix.EPOLLIN | unix.EPOLLRDHUP | unix.EPOLLONESHOT,
+		Fd:     int32(fd),
+		Pad:    int32(gen),
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+
+package netpollv1
+
+import (
+	"errors"
+	"time"
+)
+
+var errUnsupported = errors.New("netpollv1 requires epoll (linux)")
+
+type poller struct{}
+
+type readyConn struct {
+	fd  int
+	gen uint32
+}
+
+func newPoller(batch int) (*poller, error) { return nil, errUnsupported }
+
+func (p *poller) add(fd int, gen uint32) error   { return errUnsupported }
+func (p *poller) rearm(fd int, gen uint32) error { return errUnsupported }
+func (p *poller) remove(fd int) error            { return errUnsupported }
+func (p *poller) close() error                   { return errUnsupported }
+
+func (p *poller) wait(ready []readyConn, timeout time.Duration) ([]readyConn, error) {
+	return ready, errUnsupported
+}
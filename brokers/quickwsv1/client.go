@@ -3,23 +3,34 @@ package quickwsv1
 import (
 	"context"
 	"encoding/json"
+	"net"
+	"sync/atomic"
+	"time"
 
 	"github.com/antlabs/quickws"
 	"github.com/rs/zerolog/log"
 	"github.com/spy16/ticktock/ticker"
 )
 
+// readBufferSize is the size of the bufio reader of the hijacked HTTP
+// connection, which quickws keeps reading frames from.
+const readBufferSize = 4096
+
 type wsClient struct {
-	br     *Broker
-	ctx    context.Context
-	conn   *quickws.Conn
-	done   chan struct{}
-	writes chan []byte
+	br      *Broker
+	ctx     context.Context
+	conn    *quickws.Conn
+	done    chan struct{}
+	writes  chan []byte
+	replies chan []byte
+	queued  atomic.Int64 // size of the packets in writes.
 }
 
 func (wc *wsClient) EnqueuWrite(msg []byte) {
+	wc.queued.Add(int64(len(msg)))
 	select {
 	case <-wc.done:
+		wc.queued.Add(-int64(len(msg)))
 		return // client is closed
 
 	case wc.writes <- msg:
@@ -27,8 +38,37 @@ func (wc *wsClient) EnqueuWrite(msg []byte) {
 	}
 }
 
+func (wc *wsClient) EnqueuReply(msg []byte) {
+	select {
+	case <-wc.done:
+		return // client is closed
+
+	case wc.replies <- msg:
+		return
+	}
+}
+
+// Memory estimates the memory used by the connection: its read buffer,
+// the queues and the 2 goroutines.
+func (wc *wsClient) Memory() (buffers, queued int64) {
+	buffers = readBufferSize +
+		int64(cap(wc.writes)+cap(wc.replies))*ticker.QueueSlotSize + 2*ticker.StackSize
+	return buffers, wc.queued.Load()
+}
+
+// Shed closes the connection, which stops the read loop and then the
+// client.
+func (wc *wsClient) Shed() {
+	_ = wc.conn.Close()
+}
+
+func (wc *wsClient) RemoteAddr() net.Addr {
+	return wc.conn.NetConn().RemoteAddr()
+}
+
 func (e *wsClient) Run(ctx context.Context) {
 	e.conn.StartReadLoop()
+	defer e.conn.Close()
 
 	log.Debug().Msg("started read loop")
 	for {
@@ -36,10 +76,20 @@ func (e *wsClient) Run(ctx context.Context) {
 		case <-ctx.Done():
 			return
 
+		case msg := <-e.replies:
+			if err := e.conn.WriteMessage(quickws.Text, msg); err != nil {
+				log.Error().Err(err).Msg("failed to write reply")
+				return
+			}
+
 		case msg, ok := <-e.writes:
 			if !ok {
 				return
 			}
+			e.queued.Add(-int64(len(msg)))
+			if e.br.topics.Trace {
+				ticker.StampWritten(msg, time.Now())
+			}
 
 			if err := e.conn.WriteMessage(quickws.Binary, msg); err != nil {
 				log.Error().Err(err).Msg("failed to write message")
@@ -2,16 +2,21 @@ package main
 
 import (
 	"context"
-	"encoding/binary"
 	"encoding/json"
+	"io"
 	"log"
 	"math/rand"
+	"net"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 	"github.com/spf13/cobra"
+	"github.com/spy16/ticktock/ticker"
+	"github.com/spy16/ticktock/utils"
 )
 
 func cmdClient() *cobra.Command {
@@ -20,66 +25,179 @@ func cmdClient() *cobra.Command {
 		Short: "Starts a client to connect to the socket server",
 	}
 
-	var addr string
+	var addr, token, reportFile, reportFormat, scenarioFile, actionsFile string
+	var actionsSpeed float64
 	var count, instruments int
+	var replayGaps bool
+	var reportInterval time.Duration
 	cmd.Flags().StringVarP(&addr, "addr", "a", "ws://localhost:8080", "Address to connect to")
 	cmd.Flags().IntVarP(&count, "count", "c", 100, "Number of clients to create")
 	cmd.Flags().IntVarP(&instruments, "instruments", "i", 10, "Number of instruments to stream")
+	cmd.Flags().StringVar(&token, "token", "", "Auth token to present to the server")
+	cmd.Flags().BoolVar(&replayGaps, "replay-gaps", false, "Request a replay of missed ticks when a gap is detected")
+	cmd.Flags().StringVar(&scenarioFile, "scenario", "", "Run the load test described by this scenario file (YAML) instead")
+	cmd.Flags().StringVar(&actionsFile, "actions", "", "Replay the client actions of this JSONL log instead")
+	cmd.Flags().Float64Var(&actionsSpeed, "actions-speed", 1, "Speed multiplier of the action replay (0 replays as fast as possible)")
+	cmd.Flags().DurationVar(&reportInterval, "report-interval", time.Second, "Interval of the latency summaries (0 to disable)")
+	cmd.Flags().StringVar(&reportFile, "report", "", "Write the final latency report to this file")
+	cmd.Flags().StringVar(&reportFormat, "report-format", "", "Format of the final report, json or csv (defaults to the extension of the file)")
 
 	cmd.Run = func(cmd *cobra.Command, args []string) {
 		wg := &sync.WaitGroup{}
-		log.Printf("creating %d clients", count)
-		for i := 0; i < count; i++ {
-			wg.Add(1)
-			go func(id int) {
-				defer wg.Done()
-				if err := runClient(cmd.Context(), int32(id), instruments, addr); err != nil {
-					log.Printf("client %d failed: %v", id, err)
-				}
-			}(i)
+		total := &gapStats{}
+		latency := newClientLatency()
+
+		ctx, cancel := context.WithCancel(cmd.Context())
+		defer cancel()
+		if reportInterval > 0 {
+			go logLatency(ctx, latency.total, reportInterval)
+		}
+
+		var load *loadStats
+		var actions *actionStats
+		if actionsFile != "" {
+			load = &loadStats{}
+			actions = &actionStats{lag: utils.NewHistogram()}
+			if reportInterval > 0 {
+				go logLoad(ctx, load, reportInterval)
+			}
+
+			ar := &actionReplayer{
+				Addr:       addr,
+				Token:      token,
+				Speed:      actionsSpeed,
+				ReplayGaps: replayGaps,
+				stats:      load,
+				actions:    actions,
+				gaps:       total,
+				latency:    latency,
+			}
+			log.Printf("replaying client actions from %s", actionsFile)
+			if err := ar.Run(ctx, actionsFile); err != nil {
+				log.Printf("failed to replay client actions: %v", err)
+			}
+		} else if scenarioFile != "" {
+			sc, err := loadScenario(scenarioFile)
+			if err != nil {
+				log.Fatalf("failed to load scenario: %v", err)
+			}
+			if sc.Addr == "" {
+				sc.Addr = addr
+			}
+			if sc.Token == "" {
+				sc.Token = token
+			}
+
+			load = &loadStats{}
+			if reportInterval > 0 {
+				go logLoad(ctx, load, reportInterval)
+			}
+			runScenario(ctx, sc, load, total, latency)
+		} else {
+			log.Printf("creating %d clients", count)
+			for i := 0; i < count; i++ {
+				wg.Add(1)
+				go func(id int) {
+					defer wg.Done()
+					if err := runClient(ctx, int32(id), instruments, addr, token, replayGaps, total, latency); err != nil {
+						log.Printf("client %d failed: %v", id, err)
+					}
+				}(i)
+			}
+			wg.Wait()
+		}
+		cancel()
+		log.Printf("all clients exited (gaps=%d missed=%d replayed=%d)",
+			total.gaps.Load(), total.missed.Load(), total.replayed.Load())
+
+		final := latency.total.Snapshot()
+		log.Printf("latency: %s", summarize(final))
+		stages := latency.summarizeStages()
+		for _, name := range stageNames {
+			if stage, found := stages[name]; found {
+				log.Printf("latency (%s): %s", name, stage)
+			}
+		}
+		if reportFile != "" {
+			report := newLatencyReport(final, total)
+			report.Stages = stages
+			if load != nil {
+				report.Load = load.summarize(final.Count)
+			}
+			if actions != nil {
+				report.Actions = actions.summarize()
+			}
+			if err := writeReport(reportFile, reportFormat, report); err != nil {
+				log.Printf("failed to write report: %v", err)
+			}
 		}
-		wg.Wait()
-		log.Println("all clients exited")
 	}
 
 	return cmd
 }
 
-func runClient(ctx context.Context, id int32, instruments int, addr string) error {
-	conn, _, _, err := ws.Dial(ctx, addr)
+// gapStats counts the sequence gaps detected by clients.
+type gapStats struct {
+	gaps     atomic.Int64 // number of gaps.
+	missed   atomic.Int64 // number of ticks missed in the gaps.
+	replayed atomic.Int64 // number of late ticks received, i.e., replays.
+}
+
+// seqTracker detects gaps in the per-instrument sequence numbers.
+type seqTracker struct {
+	stats *gapStats
+
+	mu   sync.Mutex
+	last map[int32]uint64
+}
+
+// track records the sequence number of a packet and returns the first
+// missed sequence number if the packet follows a gap.
+func (st *seqTracker) track(instr int32, seq uint64) (from uint64, gap bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	last, seen := st.last[instr]
+	switch {
+	case seen && seq <= last:
+		st.stats.replayed.Add(1)
+		return 0, false
+
+	case seen && seq > last+1:
+		st.stats.gaps.Add(1)
+		st.stats.missed.Add(int64(seq - last - 1))
+		st.last[instr] = seq
+		return last + 1, true
+
+	default:
+		st.last[instr] = seq
+		return 0, false
+	}
+}
+
+// forget forgets the sequence numbers of the instruments, e.g., when they
+// are subscribed to again.
+func (st *seqTracker) forget(instruments ...int32) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for _, instr := range instruments {
+		delete(st.last, instr)
+	}
+}
+
+func runClient(ctx context.Context, id int32, instruments int, addr, token string, replayGaps bool, stats *gapStats, latency *clientLatency) error {
+	cc, err := dialServer(ctx, addr, token)
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	defer cc.Close()
 
 	done := make(chan struct{})
-
 	go func() {
-		t := time.NewTicker(1 * time.Second)
-		defer t.Stop()
 		defer close(done)
-
-		curLatency := 0 * time.Microsecond
-
-		for {
-			select {
-			case <-t.C:
-				log.Printf("latency (%d): %s", id, curLatency)
-
-			default:
-				msg, op, err := wsutil.ReadServerData(conn)
-				if err != nil {
-					return
-				} else if op == ws.OpClose {
-					return
-				} else if op != ws.OpBinary {
-					continue
-				}
-
-				l := time.Since(time.UnixMicro(int64(binary.BigEndian.Uint64(msg))))
-				curLatency = (curLatency + l) / 2
-			}
-		}
+		tracker := &seqTracker{stats: stats, last: make(map[int32]uint64)}
+		cc.readTicks(tracker, latency, replayGaps, nil)
 	}()
 
 	req := jsonStr(map[string]any{
@@ -91,7 +209,7 @@ func runClient(ctx context.Context, id int32, instruments int, addr string) erro
 		},
 	})
 
-	if err := wsutil.WriteClientMessage(conn, ws.OpText, req); err != nil {
+	if err := cc.write(ws.OpText, req); err != nil {
 		return err
 	}
 
@@ -101,13 +219,93 @@ func runClient(ctx context.Context, id int32, instruments int, addr string) erro
 			return nil
 
 		case <-ctx.Done():
-			_ = wsutil.WriteClientMessage(conn, ws.OpClose, nil)
-			_ = conn.Close()
+			_ = cc.write(ws.OpClose, nil)
+			_ = cc.Close()
 			return nil
 		}
 	}
 }
 
+// clientConn is the websocket connection of a client.
+type clientConn struct {
+	net.Conn
+	r  io.Reader
+	mu sync.Mutex
+}
+
+// dialServer connects to the server, presenting the auth token if any.
+func dialServer(ctx context.Context, addr, token string) (*clientConn, error) {
+	dialer := ws.DefaultDialer
+	if token != "" {
+		dialer.Header = ws.HandshakeHeaderHTTP(http.Header{"Authorization": {"Bearer " + token}})
+	}
+
+	conn, br, _, err := dialer.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the server may have sent frames (e.g., the session reply) along with
+	// the handshake response, they are read from br first.
+	cc := &clientConn{Conn: conn, r: conn}
+	if br != nil {
+		cc.r = io.MultiReader(br, conn)
+	}
+	return cc, nil
+}
+
+func (cc *clientConn) Read(b []byte) (int, error) {
+	return cc.r.Read(b)
+}
+
+func (cc *clientConn) write(op ws.OpCode, msg []byte) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return wsutil.WriteClientMessage(cc.Conn, op, msg)
+}
+
+// readTicks reads messages until the connection is closed, recording the
+// latency and the sequence gaps of the tick packets. Replies (i.e., text
+// messages) are passed to onReply, if set.
+func (cc *clientConn) readTicks(tracker *seqTracker, latency *clientLatency, replayGaps bool, onReply func(msg []byte)) {
+	for {
+		msg, op, err := wsutil.ReadServerData(cc)
+		if err != nil {
+			return
+		} else if op == ws.OpClose {
+			return
+		} else if op == ws.OpText && onReply != nil {
+			onReply(msg)
+			continue
+		} else if op != ws.OpBinary {
+			continue
+		}
+
+		msg = latency.record(msg, time.Now())
+
+		// a message may carry several LTP packets (e.g., gorillav3).
+		size := ticker.PacketSize(ticker.ModeLTP)
+		for ; len(msg) >= size && len(msg)%size == 0; msg = msg[size:] {
+			q, _, err := ticker.ParseQuote(msg[:size])
+			if err != nil || q.Seq == 0 {
+				break
+			}
+
+			from, gap := tracker.track(q.Instrument, q.Seq)
+			if gap && replayGaps {
+				req := jsonStr(ticker.Request{
+					Action:      ticker.ActionReplay,
+					Instruments: []int32{q.Instrument},
+					From:        from,
+				})
+				if err := cc.write(ws.OpText, req); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
 func jsonStr(v any) []byte {
 	b, err := json.Marshal(v)
 	if err != nil {
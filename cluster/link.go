@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spy16/ticktock/ticker"
+)
+
+// Frame types of the inter-node link.
+const (
+	frameHello    byte = iota + 1 // node id of the dialing node.
+	frameInterest                 // changes in the interest of the dialing node.
+	frameTicks                    // ticks for the dialing node.
+)
+
+// maxFrameSize bounds the size of frames accepted from peers.
+const maxFrameSize = 64 << 20
+
+var errBadFrame = errors.New("bad frame")
+
+// writeFrame writes a frame of `type(1) length(4) payload(length)`.
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+	b := make([]byte, 5, 5+len(payload))
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:], uint32(len(payload)))
+	_, err := w.Write(append(b, payload...))
+	return err
+}
+
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("%w: %d bytes", errBadFrame, size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], payload, nil
+}
+
+// encodeInterest encodes interest changes as `instrument(4) active(1)`
+// pairs.
+func encodeInterest(changes map[int32]bool) []byte {
+	b := make([]byte, 0, len(changes)*5)
+	for instr, active := range changes {
+		b = binary.BigEndian.AppendUint32(b, uint32(instr))
+		if active {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+	}
+	return b
+}
+
+func decodeInterest(b []byte, fn func(instr int32, active bool)) error {
+	if len(b)%5 != 0 {
+		return errBadFrame
+	}
+	for ; len(b) > 0; b = b[5:] {
+		fn(int32(binary.BigEndian.Uint32(b)), b[4] == 1)
+	}
+	return nil
+}
+
+func encodeTicks(ticks []ticker.Tick) []byte {
+	var buf bytes.Buffer
+	_ = ticker.WriteBatch(&buf, time.Now(), ticks)
+	return buf.Bytes()
+}
+
+func decodeTicks(b []byte) ([]ticker.Tick, error) {
+	_, ticks, err := ticker.ReadBatch(bytes.NewReader(b))
+	return ticks, err
+}
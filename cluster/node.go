@@ -0,0 +1,379 @@
+// Package cluster implements instrument-partitioned clustering of ticktock
+// servers. Every instrument is owned by a single node (by consistent
+// hashing) which ingests it and forwards its ticks to the other nodes that
+// have local subscribers for it.
+package cluster
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spy16/ticktock/ticker"
+)
+
+const (
+	publishTimeout = 5 * time.Millisecond
+	retryInterval  = 1 * time.Second
+	forwardQueue   = 1024
+)
+
+// New returns a cluster node with the given id listening for peers on addr.
+// Peers maps the ids of the other nodes to their cluster addresses. Ticks
+// owned by this node and ticks received from peers are published to local.
+func New(id, addr string, peers map[string]string, local ticker.Publisher) *Node {
+	nodes := []string{id}
+	uplinks := make(map[string]*uplink, len(peers))
+	for peer, peerAddr := range peers {
+		nodes = append(nodes, peer)
+		uplinks[peer] = &uplink{
+			peer:    peer,
+			addr:    peerAddr,
+			wanted:  make(map[int32]bool),
+			pending: make(map[int32]bool),
+			notify:  make(chan struct{}, 1),
+		}
+	}
+
+	return &Node{
+		id:        id,
+		addr:      addr,
+		ring:      NewRing(nodes),
+		local:     local,
+		uplinks:   uplinks,
+		downlinks: make(map[string]*downlink),
+	}
+}
+
+// Node is a member of a cluster. It must be used as the publisher of the
+// local tick source and Node.Demand must be registered with the local broker.
+type Node struct {
+	id    string
+	addr  string
+	ring  *Ring
+	local ticker.Publisher
+
+	// uplinks are dialed by this node to every peer. They carry the
+	// interest of this node and receive the ticks owned by the peer.
+	uplinks map[string]*uplink
+
+	// downlinks are accepted from peers. They carry the interest of the
+	// peer and the ticks owned by this node.
+	mu        sync.RWMutex
+	downlinks map[string]*downlink
+}
+
+// Owner returns the id of the node that owns the instrument.
+func (n *Node) Owner(instr int32) string {
+	return n.ring.Owner(instr)
+}
+
+// Publish publishes the ticks owned by this node to the local broker and
+// forwards them to the peers interested in them. Ticks owned by other nodes
+// are dropped since their owners ingest them, and count as accepted. Only
+// the ticks accepted by the local broker are forwarded.
+func (n *Node) Publish(opts ticker.PublishOptions, ticks []ticker.Tick) (int, error) {
+	owned := make([]ticker.Tick, 0, len(ticks))
+	positions := make([]int, 0, len(ticks))
+	for i, tick := range ticks {
+		if n.ring.Owner(tick.Instrument) == n.id {
+			owned = append(owned, tick)
+			positions = append(positions, i)
+		}
+	}
+	if len(owned) == 0 {
+		return len(ticks), nil
+	}
+
+	accepted, err := n.local.Publish(opts, owned)
+
+	n.mu.RLock()
+	for _, dl := range n.downlinks {
+		dl.forward(ticker.AcceptedTicks(opts, owned, accepted))
+	}
+	n.mu.RUnlock()
+
+	if accepted == len(owned) {
+		return len(ticks), err
+	} else if opts.Mode == ticker.PublishShedOldest {
+		// the accepted suffix of the batch starts right after the last
+		// owned tick that was not accepted.
+		return len(ticks) - positions[len(owned)-accepted-1] - 1, err
+	}
+	// the accepted prefix of the batch ends right before the first owned
+	// tick that was not accepted.
+	return positions[accepted], err
+}
+
+// Demand records a change in the local subscribers of an instrument and
+// propagates it to the owner of the instrument. It never blocks.
+func (n *Node) Demand(instr int32, active bool) {
+	owner := n.ring.Owner(instr)
+	if owner == n.id {
+		return
+	}
+	n.uplinks[owner].demand(instr, active)
+}
+
+// Run starts accepting peers and connects to all the peers until the
+// context is cancelled.
+func (n *Node) Run(ctx context.Context) error {
+	l, err := net.Listen("tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("node", n.id).Str("addr", n.addr).Int("peers", len(n.uplinks)).Msg("cluster node started")
+
+	stop := context.AfterFunc(ctx, func() { _ = l.Close() })
+	defer stop()
+
+	for _, ul := range n.uplinks {
+		go ul.run(ctx, n.id, n.local)
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go n.serveDownlink(ctx, conn)
+	}
+}
+
+func (n *Node) serveDownlink(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	r := bufio.NewReader(conn)
+	typ, payload, err := readFrame(r)
+	if err != nil || typ != frameHello {
+		log.Warn().Err(err).Str("remote", conn.RemoteAddr().String()).Msg("invalid cluster handshake")
+		return
+	}
+
+	dl := &downlink{
+		peer:     string(payload),
+		interest: make(map[int32]bool),
+		out:      make(chan []ticker.Tick, forwardQueue),
+	}
+
+	n.mu.Lock()
+	if old := n.downlinks[dl.peer]; old != nil {
+		old.close()
+	}
+	n.downlinks[dl.peer] = dl
+	n.mu.Unlock()
+	log.Info().Str("peer", dl.peer).Msg("peer connected")
+
+	defer func() {
+		n.mu.Lock()
+		if n.downlinks[dl.peer] == dl {
+			delete(n.downlinks, dl.peer)
+		}
+		dl.close()
+		n.mu.Unlock()
+		log.Info().Str("peer", dl.peer).Msg("peer disconnected")
+	}()
+
+	go func() {
+		defer cancel()
+		for ticks := range dl.out {
+			if err := writeFrame(conn, frameTicks, encodeTicks(ticks)); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		typ, payload, err := readFrame(r)
+		if err != nil {
+			return
+		} else if typ != frameInterest {
+			continue
+		}
+
+		dl.mu.Lock()
+		err = decodeInterest(payload, func(instr int32, active bool) {
+			if active {
+				dl.interest[instr] = true
+			} else {
+				delete(dl.interest, instr)
+			}
+		})
+		dl.mu.Unlock()
+		if err != nil {
+			log.Warn().Err(err).Str("peer", dl.peer).Msg("invalid interest frame")
+			return
+		}
+	}
+}
+
+// downlink is an accepted connection from a peer.
+type downlink struct {
+	peer string
+
+	mu       sync.RWMutex
+	interest map[int32]bool
+	closed   bool
+	out      chan []ticker.Tick
+}
+
+func (dl *downlink) forward(ticks []ticker.Tick) {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+	if dl.closed || len(dl.interest) == 0 {
+		return
+	}
+
+	var fwd []ticker.Tick
+	for _, tick := range ticks {
+		if dl.interest[tick.Instrument] {
+			fwd = append(fwd, tick)
+		}
+	}
+	if len(fwd) == 0 {
+		return
+	}
+
+	select {
+	case dl.out <- fwd:
+	default:
+		log.Warn().Str("peer", dl.peer).Int("count", len(fwd)).Msg("peer is slow, dropping ticks")
+	}
+}
+
+func (dl *downlink) close() {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if !dl.closed {
+		dl.closed = true
+		close(dl.out)
+	}
+}
+
+// uplink is the connection dialed to a peer.
+type uplink struct {
+	peer string
+	addr string
+
+	mu      sync.Mutex
+	wanted  map[int32]bool
+	pending map[int32]bool
+	notify  chan struct{}
+}
+
+func (ul *uplink) demand(instr int32, active bool) {
+	ul.mu.Lock()
+	if active {
+		ul.wanted[instr] = true
+	} else {
+		delete(ul.wanted, instr)
+	}
+	ul.pending[instr] = active
+	ul.mu.Unlock()
+
+	select {
+	case ul.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (ul *uplink) run(ctx context.Context, self string, local ticker.Publisher) {
+	for {
+		err := ul.session(ctx, self, local)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Debug().Err(err).Str("peer", ul.peer).Msg("peer link down, reconnecting")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (ul *uplink) session(ctx context.Context, self string, local ticker.Publisher) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", ul.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	// a new session must declare the whole interest.
+	ul.mu.Lock()
+	clear(ul.pending)
+	interest := make(map[int32]bool, len(ul.wanted))
+	for instr := range ul.wanted {
+		interest[instr] = true
+	}
+	ul.mu.Unlock()
+
+	if err := writeFrame(conn, frameHello, []byte(self)); err != nil {
+		return err
+	} else if err := writeFrame(conn, frameInterest, encodeInterest(interest)); err != nil {
+		return err
+	}
+	log.Info().Str("peer", ul.peer).Int("interest", len(interest)).Msg("connected to peer")
+
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ul.notify:
+			}
+
+			ul.mu.Lock()
+			changes := ul.pending
+			ul.pending = make(map[int32]bool)
+			ul.mu.Unlock()
+
+			if len(changes) == 0 {
+				continue
+			}
+			if err := writeFrame(conn, frameInterest, encodeInterest(changes)); err != nil {
+				return
+			}
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		typ, payload, err := readFrame(r)
+		if err != nil {
+			return err
+		} else if typ != frameTicks {
+			continue
+		}
+
+		ticks, err := decodeTicks(payload)
+		if err != nil {
+			return err
+		}
+
+		opts := ticker.PublishOptions{Mode: ticker.PublishShedOldest, Timeout: publishTimeout}
+		if n, err := local.Publish(opts, ticks); err != nil {
+			log.Warn().Err(err).Int("count", len(ticks)).Int("accepted", n).Msg("failed to publish peer ticks")
+		}
+	}
+}
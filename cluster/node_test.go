@@ -0,0 +1,227 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spy16/ticktock/ticker"
+)
+
+// publishFunc is a publisher calling the function.
+type publishFunc func(opts ticker.PublishOptions, ticks []ticker.Tick) (int, error)
+
+func (fn publishFunc) Publish(opts ticker.PublishOptions, ticks []ticker.Tick) (int, error) {
+	return fn(opts, ticks)
+}
+
+// ownedBy returns count instruments owned by the node.
+func ownedBy(t *testing.T, n *Node, node string, count int) []int32 {
+	t.Helper()
+
+	var instrs []int32
+	for instr := int32(1); len(instrs) < count; instr++ {
+		if instr > instruments {
+			t.Fatalf("%s owns less than %d instruments", node, count)
+		}
+		if n.Owner(instr) == node {
+			instrs = append(instrs, instr)
+		}
+	}
+	return instrs
+}
+
+func instrumentsOf(ticks []ticker.Tick) []int32 {
+	instrs := make([]int32, len(ticks))
+	for i, tick := range ticks {
+		instrs[i] = tick.Instrument
+	}
+	return instrs
+}
+
+func TestNodePublish(t *testing.T) {
+	n := New("a", "", map[string]string{"b": ""}, nil)
+	a, b := ownedBy(t, n, "a", 3), ownedBy(t, n, "b", 2)
+	mixed := []int32{a[0], b[0], a[1], b[1], a[2]}
+
+	tests := []struct {
+		name    string
+		batch   []int32
+		mode    ticker.PublishMode
+		accept  int // number of owned ticks the local broker accepts.
+		want    int // accepted ticks of the batch.
+		local   []int32
+		forward []int32
+	}{
+		{"All", mixed, ticker.PublishBlock, 3, 5, []int32{a[0], a[1], a[2]}, []int32{a[0], a[1], a[2]}},
+		{"NoneOwned", []int32{b[0], b[1]}, ticker.PublishBlock, 0, 2, nil, nil},
+		{"Rejected", mixed, ticker.PublishBlock, 0, 0, []int32{a[0], a[1], a[2]}, nil},
+		{"Partial", mixed, ticker.PublishPartial, 2, 4, []int32{a[0], a[1], a[2]}, []int32{a[0], a[1]}},
+		{"ShedOldest", mixed, ticker.PublishShedOldest, 2, 4, []int32{a[0], a[1], a[2]}, []int32{a[1], a[2]}},
+		{"ShedAllButLast", mixed, ticker.PublishShedOldest, 1, 2, []int32{a[0], a[1], a[2]}, []int32{a[2]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var local []int32
+			n.local = publishFunc(func(opts ticker.PublishOptions, ticks []ticker.Tick) (int, error) {
+				local = instrumentsOf(ticks)
+				if tt.accept < len(ticks) {
+					return tt.accept, ticker.ErrTimeout
+				}
+				return len(ticks), nil
+			})
+
+			// the peer is interested in everything this node owns.
+			dl := &downlink{peer: "b", interest: make(map[int32]bool), out: make(chan []ticker.Tick, 1)}
+			for _, instr := range a {
+				dl.interest[instr] = true
+			}
+			n.downlinks["b"] = dl
+
+			ticks := make([]ticker.Tick, len(tt.batch))
+			for i, instr := range tt.batch {
+				ticks[i] = ticker.Tick{Instrument: instr, Data: []byte{byte(i)}}
+			}
+
+			got, err := n.Publish(ticker.PublishOptions{Mode: tt.mode}, ticks)
+			if got != tt.want {
+				t.Fatalf("Publish() = %d, want %d", got, tt.want)
+			} else if (got < len(ticks)) != (err != nil) {
+				t.Fatalf("Publish() accepted %d of %d ticks with error %v", got, len(ticks), err)
+			}
+			if !reflect.DeepEqual(local, tt.local) {
+				t.Fatalf("published %v locally, want %v", local, tt.local)
+			}
+
+			var forward []int32
+			select {
+			case fwd := <-dl.out:
+				forward = instrumentsOf(fwd)
+			default:
+			}
+			if !reflect.DeepEqual(forward, tt.forward) {
+				t.Fatalf("forwarded %v, want %v", forward, tt.forward)
+			}
+		})
+	}
+}
+
+// freeAddr returns a local address that was free.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// waitFor polls cond until it holds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	// a peer that was not listening yet is dialed again after a second.
+	for deadline := time.Now().Add(3 * retryInterval); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// interested reports whether the peer has declared its interest in the
+// instrument to the node.
+func interested(n *Node, peer string, instr int32) bool {
+	n.mu.RLock()
+	dl := n.downlinks[peer]
+	n.mu.RUnlock()
+	if dl == nil {
+		return false
+	}
+
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+	return dl.interest[instr]
+}
+
+func TestNodeLinks(t *testing.T) {
+	addrA, addrB := freeAddr(t), freeAddr(t)
+	localA, localB := make(chan []ticker.Tick, 16), make(chan []ticker.Tick, 16)
+	collect := func(ch chan []ticker.Tick) ticker.Publisher {
+		return publishFunc(func(_ ticker.PublishOptions, ticks []ticker.Tick) (int, error) {
+			ch <- ticks
+			return len(ticks), nil
+		})
+	}
+	a := New("a", addrA, map[string]string{"b": addrB}, collect(localA))
+	b := New("b", addrB, map[string]string{"a": addrA}, collect(localB))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 2)
+	for _, n := range []*Node{a, b} {
+		go func(n *Node) { done <- n.Run(ctx) }(n)
+	}
+	defer func() {
+		cancel()
+		for i := 0; i < 2; i++ {
+			if err := <-done; err != nil {
+				t.Errorf("Run() = %v", err)
+			}
+		}
+	}()
+
+	ownedA, ownedB := ownedBy(t, a, "a", 1)[0], ownedBy(t, a, "b", 1)[0]
+	expect := func(ch chan []ticker.Tick, want ...int32) {
+		t.Helper()
+		select {
+		case ticks := <-ch:
+			if got := instrumentsOf(ticks); !reflect.DeepEqual(got, want) {
+				t.Fatalf("published %v, want %v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("nothing published, want %v", want)
+		}
+	}
+	expectNone := func(ch chan []ticker.Tick) {
+		t.Helper()
+		select {
+		case ticks := <-ch:
+			t.Fatalf("unexpected ticks %v", instrumentsOf(ticks))
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	batch := []ticker.Tick{{Instrument: ownedA, Data: []byte("a")}, {Instrument: ownedB, Data: []byte("b")}}
+
+	// the interest of a in an instrument of b goes uplink to b, which
+	// forwards its ticks downlink to a. Ticks owned by the other node are
+	// dropped.
+	a.Demand(ownedB, true)
+	waitFor(t, "the interest of a", func() bool { return interested(b, "a", ownedB) })
+
+	if n, err := b.Publish(ticker.PublishOptions{}, batch); n != 2 || err != nil {
+		t.Fatalf("Publish() = %d, %v", n, err)
+	}
+	expect(localB, ownedB)
+	expect(localA, ownedB)
+
+	if n, err := a.Publish(ticker.PublishOptions{}, batch); n != 2 || err != nil {
+		t.Fatalf("Publish() = %d, %v", n, err)
+	}
+	expect(localA, ownedA)
+	expectNone(localB)
+
+	// without interest, nothing is forwarded.
+	a.Demand(ownedB, false)
+	waitFor(t, "the loss of interest of a", func() bool { return !interested(b, "a", ownedB) })
+
+	if n, err := b.Publish(ticker.PublishOptions{}, batch); n != 2 || err != nil {
+		t.Fatalf("Publish() = %d, %v", n, err)
+	}
+	expect(localB, ownedB)
+	expectNone(localA)
+}
@@ -0,0 +1,62 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// replicas is the number of virtual nodes per node on the ring.
+const replicas = 128
+
+// NewRing returns a consistent hash ring of the given nodes.
+func NewRing(nodes []string) *Ring {
+	r := &Ring{}
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			r.points = append(r.points, point{
+				hash: hashString(node + "#" + strconv.Itoa(i)),
+				node: node,
+			})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i].hash < r.points[j].hash })
+	return r
+}
+
+// Ring assigns instruments to nodes using consistent hashing, so that
+// adding or removing a node only moves the instruments of that node.
+type Ring struct {
+	points []point
+}
+
+type point struct {
+	hash uint32
+	node string
+}
+
+// Owner returns the node that owns the given instrument.
+func (r *Ring) Owner(instr int32) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(instr))
+	h := fnv.New32a()
+	_, _ = h.Write(b[:])
+	hash := h.Sum32()
+
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -0,0 +1,89 @@
+package cluster
+
+import "testing"
+
+// instruments is the number of instruments the ring tests assign.
+const instruments = 10000
+
+func TestRingOwner(t *testing.T) {
+	if owner := NewRing(nil).Owner(1); owner != "" {
+		t.Fatalf("empty ring: Owner() = %q, want none", owner)
+	}
+
+	r := NewRing([]string{"a"})
+	for instr := int32(0); instr < instruments; instr++ {
+		if owner := r.Owner(instr); owner != "a" {
+			t.Fatalf("single node ring: Owner(%d) = %q, want a", instr, owner)
+		}
+	}
+}
+
+func TestRingBalance(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []string
+	}{
+		{"Two", []string{"a", "b"}},
+		{"Three", []string{"a", "b", "c"}},
+		{"Addresses", []string{"node-1:7070", "node-2:7070", "node-3:7070", "node-4:7070"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRing(tt.nodes)
+			owned := make(map[string]int)
+			for instr := int32(0); instr < instruments; instr++ {
+				owned[r.Owner(instr)]++
+			}
+
+			// every node owns between half and twice its fair share.
+			fair := instruments / len(tt.nodes)
+			for _, node := range tt.nodes {
+				if n := owned[node]; n < fair/2 || n > 2*fair {
+					t.Errorf("%s owns %d instruments, fair share is %d", node, n, fair)
+				}
+			}
+		})
+	}
+}
+
+func TestRingMoves(t *testing.T) {
+	tests := []struct {
+		name   string
+		before []string
+		after  []string
+		// moved reports whether an instrument may move between the owners.
+		moved func(from, to string) bool
+	}{
+		{
+			name:   "Reordered",
+			before: []string{"a", "b", "c"},
+			after:  []string{"c", "a", "b"},
+			moved:  func(from, to string) bool { return false },
+		},
+		{
+			name:   "Added",
+			before: []string{"a", "b", "c"},
+			after:  []string{"a", "b", "c", "d"},
+			moved:  func(from, to string) bool { return to == "d" },
+		},
+		{
+			name:   "Removed",
+			before: []string{"a", "b", "c"},
+			after:  []string{"a", "c"},
+			moved:  func(from, to string) bool { return from == "b" },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after := NewRing(tt.before), NewRing(tt.after)
+			for instr := int32(0); instr < instruments; instr++ {
+				from, to := before.Owner(instr), after.Owner(instr)
+				if from != to && !tt.moved(from, to) {
+					t.Fatalf("instrument %d moved from %s to %s", instr, from, to)
+				}
+			}
+		})
+	}
+}
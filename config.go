@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// configFlags maps the keys of the serve config file to the flags they
+// set. Keys are `section.name` paths in the file.
+var configFlags = map[string]string{
+	"listen.addr":         "addr",
+	"listen.pprof_addr":   "pprof-addr",
+	"listen.cluster_addr": "cluster-addr",
+
+	"broker.server":          "server",
+	"broker.history":         "history",
+	"broker.session_grace":   "session-grace",
+	"broker.session_buffer":  "session-buffer",
+	"broker.groups":          "groups",
+	"broker.master":          "master",
+	"broker.tick_queue":      "tick-queue",
+	"broker.request_queue":   "request-queue",
+	"broker.client_queue":    "client-queue",
+	"broker.read_buffer":     "read-buffer",
+	"broker.write_buffer":    "write-buffer",
+	"broker.read_timeout":    "read-timeout",
+	"broker.write_interval":  "write-interval",
+	"broker.poll_batch":      "poll-batch",
+	"broker.pooled_buffers":  "pooled-buffers",
+	"broker.prepared_frames": "prepared-frames",
+	"broker.max_write_bytes": "max-write-bytes",
+	"broker.workers":         "workers",
+	"broker.trace":           "trace",
+
+	"limits.max_connections":   "max-connections",
+	"limits.max_subscriptions": "max-subscriptions",
+	"limits.memory_budget":     "memory-budget",
+	"limits.memory_shed":       "memory-shed",
+
+	"auth.tokens": "auth-tokens",
+
+	"source.type":               "source",
+	"source.addr":               "source-addr",
+	"source.file":               "source-file",
+	"source.instruments":        "instruments",
+	"source.tickrate":           "tickrate",
+	"source.trade_count":        "trade-count",
+	"source.feed_layout":        "feed-layout",
+	"source.feed_iface":         "feed-iface",
+	"source.upstream":           "upstream",
+	"source.record":             "record",
+	"source.replay_speed":       "replay-speed",
+	"source.replay_loop":        "replay-loop",
+	"source.replay_instruments": "replay-instruments",
+	"source.sim_skew":           "sim-skew",
+	"source.sim_volatility":     "sim-volatility",
+	"source.sim_open":           "sim-open",
+	"source.sim_burst":          "sim-burst",
+	"source.sim_burst_width":    "sim-burst-width",
+
+	"cluster.id":    "cluster-id",
+	"cluster.peers": "cluster-peers",
+
+	"log.level":  "log-level",
+	"log.format": "log-format",
+}
+
+// reloadableKeys are the config keys applied again on SIGHUP.
+var reloadableKeys = []string{
+	"log.level",
+	"limits.max_connections",
+	"limits.max_subscriptions",
+	"limits.memory_budget",
+	"limits.memory_shed",
+	"auth.tokens",
+}
+
+type configKey struct{}
+
+// config is a loaded config file.
+type config struct {
+	path   string
+	cli    map[string]bool // flags set on the command line.
+	values map[string]string
+}
+
+// loadConfig reads a YAML or TOML (based on the extension) config file and
+// sets the flags that were not set on the command line.
+func loadConfig(fs *pflag.FlagSet, path string) (*config, error) {
+	cfg := &config{path: path, cli: map[string]bool{}}
+	fs.Visit(func(f *pflag.Flag) { cfg.cli[f.Name] = true })
+
+	if err := cfg.read(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(cfg.values))
+	for key := range cfg.values {
+		keys = append(keys, key)
+	}
+	return cfg, cfg.apply(fs, keys, false)
+}
+
+// reload reads the config file again and applies the reloadable settings.
+// Settings removed from the file are reset to their defaults.
+func (cfg *config) reload(fs *pflag.FlagSet) error {
+	if err := cfg.read(); err != nil {
+		return err
+	}
+	return cfg.apply(fs, reloadableKeys, true)
+}
+
+func (cfg *config) read() error {
+	b, err := os.ReadFile(cfg.path)
+	if err != nil {
+		return err
+	}
+
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(cfg.path)) {
+	case ".toml":
+		err = toml.Unmarshal(b, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	default:
+		return fmt.Errorf("unsupported config file '%s' (expecting .yaml, .yml or .toml)", cfg.path)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config file '%s': %w", cfg.path, err)
+	}
+
+	values := map[string]string{}
+	if err := flattenConfig("", raw, values); err != nil {
+		return fmt.Errorf("invalid config file '%s': %w", cfg.path, err)
+	}
+	cfg.values = values
+	return nil
+}
+
+func (cfg *config) apply(fs *pflag.FlagSet, keys []string, reset bool) error {
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := configFlags[key]
+		if cfg.cli[name] {
+			continue // flags override the file.
+		}
+
+		flag := fs.Lookup(name)
+		if flag == nil {
+			return fmt.Errorf("unknown setting '%s' in '%s'", key, cfg.path)
+		}
+		value, found := cfg.values[key]
+		if !found && !reset {
+			continue
+		}
+
+		// slices are replaced since setting them again appends.
+		if sv, ok := flag.Value.(pflag.SliceValue); ok {
+			var items []string
+			if found && value != "" {
+				items = strings.Split(value, ",")
+			}
+			if err := sv.Replace(items); err != nil {
+				return fmt.Errorf("invalid value for '%s' in '%s': %w", key, cfg.path, err)
+			}
+			continue
+		}
+
+		if !found {
+			value = flag.DefValue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value for '%s' in '%s': %w", key, cfg.path, err)
+		}
+	}
+	return nil
+}
+
+// flattenConfig flattens the sections of the config into `section.name`
+// keys with values formatted like flag values.
+func flattenConfig(prefix string, raw map[string]any, values map[string]string) error {
+	for name, v := range raw {
+		key := prefix + name
+		if section, ok := v.(map[string]any); ok && key != "cluster.peers" {
+			if err := flattenConfig(key+".", section, values); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, known := configFlags[key]; !known {
+			return fmt.Errorf("unknown key '%s'", key)
+		}
+
+		switch v := v.(type) {
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+
+		case map[string]any:
+			pairs := make([]string, 0, len(v))
+			for k, item := range v {
+				pairs = append(pairs, fmt.Sprintf("%s=%v", k, item))
+			}
+			sort.Strings(pairs)
+			values[key] = strings.Join(pairs, ",")
+
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
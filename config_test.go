@@ -0,0 +1,200 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+// serveFlags returns the flags of the serve command, along with the
+// persistent flags of the root command.
+func serveFlags(t *testing.T, args ...string) *pflag.FlagSet {
+	t.Helper()
+
+	fs := cmdServe().Flags()
+	fs.String("log-level", "info", "")
+	fs.String("log-format", "text", "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigFlags(t *testing.T) {
+	fs := serveFlags(t)
+	for key, name := range configFlags {
+		if fs.Lookup(name) == nil {
+			t.Errorf("key '%s' sets the unknown flag '%s'", key, name)
+		}
+	}
+	for _, key := range reloadableKeys {
+		if _, found := configFlags[key]; !found {
+			t.Errorf("reloadable key '%s' is not a config key", key)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		args    []string
+		want    map[string]string // flag values.
+		wantErr string
+	}{
+		{
+			name:    "YAML",
+			file:    "ticktock.yaml",
+			content: "listen:\n  addr: \":9000\"\nbroker:\n  client_queue: 64\nauth:\n  tokens: [a, b]\n",
+			want:    map[string]string{"addr": ":9000", "client-queue": "64", "auth-tokens": "[a,b]"},
+		},
+		{
+			name:    "TOML",
+			file:    "ticktock.toml",
+			content: "[listen]\naddr = \":9000\"\n\n[cluster.peers]\nb = \"h2:7070\"\na = \"h1:7070\"\n",
+			want:    map[string]string{"addr": ":9000", "cluster-peers": "[a=h1:7070,b=h2:7070]"},
+		},
+		{
+			name:    "FlagsOverride",
+			file:    "ticktock.yaml",
+			content: "listen:\n  addr: \":9000\"\n",
+			args:    []string{"--addr", ":9001"},
+			want:    map[string]string{"addr": ":9001"},
+		},
+		{
+			name:    "UnknownKey",
+			file:    "ticktock.yaml",
+			content: "listen:\n  adr: \":9000\"\n",
+			wantErr: "unknown key 'listen.adr'",
+		},
+		{
+			name:    "BadValue",
+			file:    "ticktock.yaml",
+			content: "broker:\n  client_queue: many\n",
+			wantErr: "invalid value for 'broker.client_queue'",
+		},
+		{
+			name:    "BadExtension",
+			file:    "ticktock.json",
+			content: "{}",
+			wantErr: "unsupported config file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			writeConfig(t, path, tt.content)
+
+			fs := serveFlags(t, tt.args...)
+			_, err := loadConfig(fs, path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range tt.want {
+				if got := flagValue(t, fs, name); got != want {
+					t.Errorf("--%s = %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// flagValue formats the value of a flag, sorting the pairs of map flags whose
+// String is in map order.
+func flagValue(t *testing.T, fs *pflag.FlagSet, name string) string {
+	t.Helper()
+
+	if fs.Lookup(name).Value.Type() != "stringToString" {
+		return fs.Lookup(name).Value.String()
+	}
+	m, err := fs.GetStringToString(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return "[" + strings.Join(pairs, ",") + "]"
+}
+
+func TestLoadExampleConfig(t *testing.T) {
+	if _, err := loadConfig(serveFlags(t), "ticktock.example.yaml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigUnknownSetting(t *testing.T) {
+	cfg := &config{
+		path:   "ticktock.yaml",
+		cli:    map[string]bool{},
+		values: map[string]string{"listen.addr": ":9000"},
+	}
+
+	// a flag set without the flag of the key.
+	fs := pflag.NewFlagSet("serve", pflag.ContinueOnError)
+	err := cfg.apply(fs, []string{"listen.addr"}, false)
+	if err == nil || !strings.Contains(err.Error(), "unknown setting 'listen.addr'") {
+		t.Errorf("apply() error = %v, want an unknown setting", err)
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ticktock.yaml")
+	writeConfig(t, path, "listen:\n  addr: \":9000\"\nlimits:\n  max_connections: 10\n  max_subscriptions: 5\nauth:\n  tokens: [a, b]\n")
+
+	fs := serveFlags(t, "--max-subscriptions", "7")
+	cfg, err := loadConfig(fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig(t, path, "listen:\n  addr: \":9001\"\nlimits:\n  max_connections: 20\n  max_subscriptions: 6\nauth:\n  tokens: [c]\n")
+	if err := cfg.reload(fs); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"addr":              ":9000", // not reloadable.
+		"max-connections":   "20",
+		"max-subscriptions": "7", // set on the command line.
+		"auth-tokens":       "[c]",
+	}
+	for name, want := range want {
+		if got := fs.Lookup(name).Value.String(); got != want {
+			t.Errorf("--%s = %s, want %s", name, got, want)
+		}
+	}
+
+	// settings removed from the file are reset to their defaults.
+	writeConfig(t, path, "listen:\n  addr: \":9001\"\n")
+	if err := cfg.reload(fs); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"max-connections": "0", "auth-tokens": "[]"} {
+		if got := fs.Lookup(name).Value.String(); got != want {
+			t.Errorf("--%s = %s after removal, want %s", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spy16/ticktock/ticker"
+)
+
+// maxFeedPacket is the maximum size of a generated feed packet.
+const maxFeedPacket = 1400
+
+func cmdFeed() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "feed",
+		Short: "Generates a UDP market data feed for the feed source",
+	}
+
+	var addr, layoutSpec string
+	var drop, reorder float64
+	var sim ticker.Simulator
+	cmd.Flags().StringVarP(&addr, "addr", "a", "239.0.0.1:9999", "Multicast group (or unicast address) to send the feed to")
+	cmd.Flags().StringVar(&layoutSpec, "feed-layout", "", "Packet layout (see ticker.ParseFeedLayout)")
+	cmd.Flags().Float64Var(&drop, "drop", 0, "Fraction of packets to drop to simulate gaps")
+	cmd.Flags().Float64Var(&reorder, "reorder", 0, "Fraction of packets to send out of order")
+	cmd.Flags().IntVarP(&sim.Instruments, "instruments", "i", 100, "Number of instruments to simulate")
+	cmd.Flags().DurationVarP(&sim.TickRate, "tickrate", "t", 100*time.Millisecond, "Tick Rate")
+	cmd.Flags().IntVarP(&sim.TradeCount, "trade-count", "c", 500, "Number of trades to generate per tick")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		layout, err := ticker.ParseFeedLayout(layoutSpec)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid feed layout")
+		}
+
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to open feed connection")
+		}
+		defer conn.Close()
+
+		fp := &feedPublisher{
+			conn:    conn,
+			layout:  layout,
+			drop:    drop,
+			reorder: reorder,
+		}
+
+		log.Info().Str("addr", addr).Msg("sending feed")
+		if err := sim.Run(cmd.Context(), fp); err != nil {
+			log.Error().Err(err).Msg("feed exited")
+		}
+		log.Info().Uint64("packets", fp.seq).Msg("feed stopped")
+	}
+
+	return cmd
+}
+
+// feedPublisher encodes published ticks into feed packets and sends them to
+// the connection, optionally dropping or reordering packets.
+type feedPublisher struct {
+	conn    net.Conn
+	layout  ticker.FeedLayout
+	drop    float64
+	reorder float64
+
+	seq  uint64
+	held []byte
+}
+
+func (fp *feedPublisher) Publish(_ ticker.PublishOptions, ticks []ticker.Tick) (int, error) {
+	perPacket := (maxFeedPacket - fp.layout.HeaderSize) / fp.layout.RecordSize
+	if perPacket <= 0 {
+		return 0, errors.New("record size exceeds packet size")
+	}
+
+	quotes := make([]ticker.Quote, 0, len(ticks))
+	for _, tick := range ticks {
+		q, _, err := ticker.ParseQuote(tick.Data)
+		if err != nil {
+			continue
+		}
+		quotes = append(quotes, q)
+	}
+
+	for len(quotes) > 0 {
+		n := min(perPacket, len(quotes))
+		fp.seq++
+		pkt := fp.layout.Encode(fp.seq, quotes[:n])
+		quotes = quotes[n:]
+
+		if rand.Float64() < fp.drop {
+			continue
+		} else if fp.held == nil && rand.Float64() < fp.reorder {
+			fp.held = pkt // sent after the next packet.
+			continue
+		}
+
+		if _, err := fp.conn.Write(pkt); err != nil {
+			return 0, err
+		}
+		if fp.held != nil {
+			if _, err := fp.conn.Write(fp.held); err != nil {
+				return 0, err
+			}
+			fp.held = nil
+		}
+	}
+	return len(ticks), nil
+}
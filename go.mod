@@ -3,6 +3,7 @@ module github.com/spy16/ticktock
 go 1.21.3
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/antlabs/quickws v0.1.7
 	github.com/gobwas/ws v1.3.0
 	github.com/gorilla/websocket v1.5.0
@@ -10,6 +11,9 @@ require (
 	github.com/rs/zerolog v1.31.0
 	github.com/smallnest/epoller v1.1.0
 	github.com/spf13/cobra v1.7.0
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/sys v0.12.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -19,6 +23,4 @@ require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/sys v0.12.0 // indirect
 )
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spy16/ticktock/ticker"
+	"github.com/spy16/ticktock/utils"
+)
+
+// reportPercentiles are the percentiles of the latency distribution in the
+// final report.
+var reportPercentiles = []float64{0, 50, 75, 90, 95, 99, 99.9, 99.99, 100}
+
+// stageNames are the delivery stages of traced packets (see ticker.Trace):
+// from the scheduled time to the publish by the source, the publish to the
+// enqueue for the client, the enqueue to the write by the broker and the
+// write to the receipt.
+var stageNames = []string{"source", "broker_queue", "client_queue", "socket"}
+
+// clientLatency records the latencies measured by the clients, end to end
+// and by stage for traced packets.
+type clientLatency struct {
+	total  *utils.Histogram
+	stages []*utils.Histogram
+	bytes  atomic.Int64 // size of the received packets, without traces.
+}
+
+func newClientLatency() *clientLatency {
+	cl := &clientLatency{total: utils.NewHistogram()}
+	for range stageNames {
+		cl.stages = append(cl.stages, utils.NewHistogram())
+	}
+	return cl
+}
+
+// record records the latency of a message received at now and returns its
+// packets without the trace. The end-to-end latency of traced packets is
+// measured from their scheduled time, which accounts for the stalls of the
+// source, and from their timestamp otherwise.
+func (cl *clientLatency) record(msg []byte, now time.Time) []byte {
+	packet, tr, traced := ticker.ParseTrace(msg)
+	cl.bytes.Add(int64(len(packet)))
+	if !traced {
+		cl.total.Record(now.Sub(time.UnixMicro(int64(binary.BigEndian.Uint64(msg)))))
+		return msg
+	}
+
+	received := now.UnixMicro()
+	cl.total.Record(time.Duration(received-tr.Scheduled) * time.Microsecond)
+	for i, d := range []int64{
+		tr.Published - tr.Scheduled,
+		tr.Enqueued - tr.Published,
+		tr.Written - tr.Enqueued,
+		received - tr.Written,
+	} {
+		cl.stages[i].Record(time.Duration(d) * time.Microsecond)
+	}
+	return packet
+}
+
+// summarizeStages summarizes the latencies of the stages, if any packets
+// were traced.
+func (cl *clientLatency) summarizeStages() map[string]latencySummary {
+	stages := map[string]latencySummary{}
+	for i, name := range stageNames {
+		if s := cl.stages[i].Snapshot(); s.Count > 0 {
+			stages[name] = summarize(s)
+		}
+	}
+	if len(stages) == 0 {
+		return nil
+	}
+	return stages
+}
+
+// latencySummary summarizes a latency histogram. Latencies are in
+// microseconds.
+type latencySummary struct {
+	Count int64   `json:"count"`
+	Min   int64   `json:"min_us"`
+	Mean  float64 `json:"mean_us"`
+	P50   int64   `json:"p50_us"`
+	P90   int64   `json:"p90_us"`
+	P99   int64   `json:"p99_us"`
+	P999  int64   `json:"p999_us"`
+	Max   int64   `json:"max_us"`
+}
+
+func summarize(s *utils.HistogramSnapshot) latencySummary {
+	return latencySummary{
+		Count: s.Count,
+		Min:   s.Min,
+		Mean:  s.Mean(),
+		P50:   s.ValueAt(50),
+		P90:   s.ValueAt(90),
+		P99:   s.ValueAt(99),
+		P999:  s.ValueAt(99.9),
+		Max:   s.Max,
+	}
+}
+
+func (ls latencySummary) String() string {
+	us := func(v int64) time.Duration { return time.Duration(v) * time.Microsecond }
+	return fmt.Sprintf("n=%d p50=%s p90=%s p99=%s p999=%s max=%s",
+		ls.Count, us(ls.P50), us(ls.P90), us(ls.P99), us(ls.P999), us(ls.Max))
+}
+
+// latencyReport is the final report of the client command.
+type latencyReport struct {
+	latencySummary
+
+	Gaps        int64                     `json:"gaps"`
+	Missed      int64                     `json:"missed"`
+	Replayed    int64                     `json:"replayed"`
+	Load        *loadSummary              `json:"load,omitempty"`
+	Actions     *actionSummary            `json:"actions,omitempty"`
+	Stages      map[string]latencySummary `json:"stages,omitempty"`
+	Percentiles []percentileValue         `json:"percentiles"`
+}
+
+type percentileValue struct {
+	Percentile float64 `json:"percentile"`
+	Latency    int64   `json:"latency_us"`
+}
+
+func newLatencyReport(s *utils.HistogramSnapshot, gaps *gapStats) latencyReport {
+	report := latencyReport{
+		latencySummary: summarize(s),
+		Gaps:           gaps.gaps.Load(),
+		Missed:         gaps.missed.Load(),
+		Replayed:       gaps.replayed.Load(),
+	}
+	for _, p := range reportPercentiles {
+		v := s.ValueAt(p)
+		if p == 0 {
+			v = s.Min
+		}
+		report.Percentiles = append(report.Percentiles, percentileValue{Percentile: p, Latency: v})
+	}
+	return report
+}
+
+// logLatency logs a summary of the latencies recorded in each interval.
+func logLatency(ctx context.Context, h *utils.Histogram, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	prev := h.Snapshot()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-t.C:
+			cur := h.Snapshot()
+			if d := cur.Sub(prev); d.Count > 0 {
+				log.Printf("latency: %s", summarize(d))
+			}
+			prev = cur
+		}
+	}
+}
+
+// writeReport writes the report to the file as JSON or CSV. If format is
+// empty, it is picked based on the extension of the file. The CSV report
+// is the latency distribution, one percentile per row.
+func writeReport(path, format string, report latencyReport) error {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+	if format != "json" && format != "csv" {
+		return fmt.Errorf("unknown report format '%s' (expecting json or csv)", format)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+
+	case "csv":
+		w := csv.NewWriter(f)
+		_ = w.Write([]string{"percentile", "latency_us"})
+		for _, pv := range report.Percentiles {
+			_ = w.Write([]string{
+				strconv.FormatFloat(pv.Percentile, 'f', -1, 64),
+				strconv.FormatInt(pv.Latency, 10),
+			})
+		}
+		w.Flush()
+		err = w.Error()
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
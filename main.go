@@ -27,6 +27,8 @@ func main() {
 	rootCmd.AddCommand(
 		cmdServe(),
 		cmdClient(),
+		cmdFeed(),
+		cmdBench(),
 	)
 
 	var closeLogger func()
@@ -34,8 +36,19 @@ func main() {
 	var logLevel, logFormat string
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "log level")
 	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "log form")
-	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		// the config file is loaded before the logger is set up since it
+		// may configure the logger too.
+		if f := cmd.Flags().Lookup("config"); f != nil && f.Value.String() != "" {
+			cfg, err := loadConfig(cmd.Flags(), f.Value.String())
+			if err != nil {
+				return err
+			}
+			cmd.SetContext(context.WithValue(cmd.Context(), configKey{}, cfg))
+		}
+
 		closeLogger = setupLogger(cmd.Context(), logLevel, logFormat)
+		return nil
 	}
 
 	rootCmd.PersistentPostRun = func(cmd *cobra.Command, args []string) {
@@ -61,7 +74,9 @@ func setupLogger(ctx context.Context, level, format string) (closer func()) {
 		})
 	}
 
-	log.Logger = zerolog.New(wr).With().Caller().Timestamp().Logger().Level(logLevel)
+	// the level is set globally so that it can be changed while running.
+	zerolog.SetGlobalLevel(logLevel)
+	log.Logger = zerolog.New(wr).With().Caller().Timestamp().Logger()
 
 	return func() {
 		// shutdown logger on context cancellation.
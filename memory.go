@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spy16/ticktock/ticker"
+)
+
+// memoryCheckInterval is the interval at which the memory of the
+// connections is checked against the budget.
+const memoryCheckInterval = time.Second
+
+// memoryGuard keeps the estimated memory of the connections of a broker
+// within a budget: new connections are refused while over budget and, if
+// shedding is enabled, the heaviest clients are disconnected. Its settings
+// can be changed while serving.
+type memoryGuard struct {
+	srv Broker
+
+	budget atomic.Int64 // bytes, 0 for no budget.
+	shed   atomic.Bool
+	usage  atomic.Int64 // last estimate.
+}
+
+// memoryStatus is the memory report of the admin API.
+type memoryStatus struct {
+	Budget     int64              `json:"budget"`
+	Shed       bool               `json:"shed"`
+	OverBudget bool               `json:"over_budget"`
+	Usage      ticker.MemoryUsage `json:"usage"`
+}
+
+// SetBudget sets the budget in MB (0 for no budget) and whether the
+// heaviest clients are shed when over budget.
+func (mg *memoryGuard) SetBudget(mb int, shed bool) {
+	mg.budget.Store(int64(mb) << 20)
+	mg.shed.Store(shed)
+}
+
+// OverBudget reports whether the last estimate is over budget.
+func (mg *memoryGuard) OverBudget() bool {
+	budget := mg.budget.Load()
+	return budget > 0 && mg.usage.Load() >= budget
+}
+
+// Run checks the memory of the connections until the context is canceled.
+func (mg *memoryGuard) Run(ctx context.Context) {
+	tick := time.NewTicker(memoryCheckInterval)
+	defer tick.Stop()
+
+	wasOver := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-tick.C:
+			if mg.budget.Load() == 0 {
+				wasOver = false
+				continue
+			}
+
+			var shed int
+			usage, err := mg.measure(ctx, 0, func(t *ticker.Topics, usage ticker.MemoryUsage) {
+				budget := mg.budget.Load()
+				if budget > 0 && usage.Total > budget && mg.shed.Load() {
+					shed = t.Shed(usage.Total - budget)
+				}
+			})
+			if err != nil {
+				return
+			}
+
+			over := mg.OverBudget()
+			if shed > 0 {
+				log.Warn().Int("clients", shed).Int64("usage", usage.Total).Int64("budget", mg.budget.Load()).
+					Msg("shedding the heaviest clients over the memory budget")
+			}
+			if over && !wasOver {
+				log.Warn().Int64("usage", usage.Total).Int64("budget", mg.budget.Load()).
+					Msg("memory budget exceeded, refusing new connections")
+			} else if !over && wasOver {
+				log.Info().Int64("usage", usage.Total).Int64("budget", mg.budget.Load()).
+					Msg("memory back within budget")
+			}
+			wasOver = over
+		}
+	}
+}
+
+// Status returns the memory report with the top heaviest clients.
+func (mg *memoryGuard) Status(ctx context.Context, top int) (*memoryStatus, error) {
+	usage, err := mg.measure(ctx, top, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryStatus{
+		Budget:     mg.budget.Load(),
+		Shed:       mg.shed.Load(),
+		OverBudget: mg.OverBudget(),
+		Usage:      usage,
+	}, nil
+}
+
+// measure estimates the memory of the connections in sync with the broker,
+// and then runs fn (if set) with the estimate.
+func (mg *memoryGuard) measure(ctx context.Context, top int, fn func(t *ticker.Topics, usage ticker.MemoryUsage)) (ticker.MemoryUsage, error) {
+	done := make(chan ticker.MemoryUsage, 1)
+	mg.srv.Update(func(t *ticker.Topics) {
+		usage := t.Memory(top)
+		if fn != nil {
+			fn(t, usage)
+		}
+		done <- usage
+	})
+
+	select {
+	case <-ctx.Done():
+		return ticker.MemoryUsage{}, ctx.Err()
+
+	case usage := <-done:
+		mg.usage.Store(usage.Total)
+		return usage, nil
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spy16/ticktock/ticker"
+)
+
+// syncBroker runs the updates right away on its registry.
+type syncBroker struct {
+	Broker
+	topics *ticker.Topics
+}
+
+func (sb *syncBroker) Topics() *ticker.Topics           { return sb.topics }
+func (sb *syncBroker) Update(fn func(t *ticker.Topics)) { fn(sb.topics) }
+
+// heavyClient is a client of a fixed memory estimate.
+type heavyClient struct {
+	buffers int64
+	shed    bool
+}
+
+func (hc *heavyClient) EnqueuWrite([]byte)              {}
+func (hc *heavyClient) EnqueuReply([]byte)              {}
+func (hc *heavyClient) Memory() (buffers, queued int64) { return hc.buffers, 0 }
+func (hc *heavyClient) Shed()                           { hc.shed = true }
+func (hc *heavyClient) RemoteAddr() net.Addr            { return &net.TCPAddr{} }
+
+// newGuardedBroker connects clients of the sizes in MB.
+func newGuardedBroker(mbs ...int64) (*memoryGuard, []*heavyClient) {
+	srv := &syncBroker{topics: ticker.NewTopics()}
+	var clients []*heavyClient
+	for _, mb := range mbs {
+		hc := &heavyClient{buffers: mb << 20}
+		srv.topics.Connect(hc, "")
+		clients = append(clients, hc)
+	}
+	return &memoryGuard{srv: srv}, clients
+}
+
+func TestMemoryGuardStatus(t *testing.T) {
+	mg, _ := newGuardedBroker(1, 3)
+	mg.SetBudget(4, true)
+	if mg.OverBudget() {
+		t.Fatal("over budget before any estimate")
+	}
+
+	status, err := mg.Status(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Budget != 4<<20 || !status.Shed || !status.OverBudget {
+		t.Errorf("status %+v, want over the budget of 4MB", status)
+	}
+	if status.Usage.Total != 4<<20 || len(status.Usage.Heaviest) != 1 || status.Usage.Heaviest[0].Buffers != 3<<20 {
+		t.Errorf("usage %+v, want 4MB with the client of 3MB the heaviest", status.Usage)
+	}
+
+	mg.SetBudget(5, false)
+	if mg.OverBudget() {
+		t.Error("over budget after raising it")
+	}
+	mg.SetBudget(0, false)
+	if mg.OverBudget() {
+		t.Error("over budget without a budget")
+	}
+}
+
+func TestMemoryGuardStatusTimeout(t *testing.T) {
+	// a broker that is not serving never runs the update.
+	mg := &memoryGuard{srv: &stalledBroker{}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := mg.Status(ctx, 0); err == nil {
+		t.Fatal("Status() succeeded without the broker")
+	}
+}
+
+type stalledBroker struct{ Broker }
+
+func (stalledBroker) Update(func(t *ticker.Topics)) {}
+
+func TestMemoryGuardRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for a memory check")
+	}
+
+	tests := []struct {
+		name string
+		shed bool
+		want []bool
+	}{
+		{"Refuse", false, []bool{false, false, false}},
+		{"Shed", true, []bool{false, true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mg, clients := newGuardedBroker(1, 3, 2)
+			mg.SetBudget(4, tt.shed)
+
+			ctx, cancel := context.WithTimeout(context.Background(), memoryCheckInterval+500*time.Millisecond)
+			defer cancel()
+			mg.Run(ctx)
+
+			if !mg.OverBudget() {
+				t.Error("not over budget after a check")
+			}
+			for i, want := range tt.want {
+				if clients[i].shed != want {
+					t.Errorf("client %d shed = %t, want %t", i, clients[i].shed, want)
+				}
+			}
+		})
+	}
+}
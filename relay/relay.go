@@ -0,0 +1,298 @@
+// Package relay implements an upstream source that lets a ticktock server
+// run as an edge node of another (core) ticktock server.
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/rs/zerolog/log"
+	"github.com/spy16/ticktock/ticker"
+)
+
+const (
+	publishTimeout = 5 * time.Millisecond
+	retryInterval  = 1 * time.Second
+	maxBatchSize   = 4096
+)
+
+// New returns a relay to the upstream server at addr.
+func New(addr string) *Relay {
+	return &Relay{
+		addr:    addr,
+		wanted:  make(map[int32]bool),
+		pending: make(map[int32]bool),
+		seqs:    make(map[int32]uint64),
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// Relay is a source that subscribes to the upstream server only for the
+// instruments that have local subscribers and publishes the ticks received
+// to the local broker. Relay.Demand must be registered with the local
+// broker to track the local subscriptions.
+//
+// The local broker stamps its own sequence numbers, so the relay tracks
+// the upstream ones to detect and report the ticks lost upstream.
+type Relay struct {
+	addr string
+
+	mu      sync.Mutex
+	wanted  map[int32]bool   // instruments with local subscribers.
+	pending map[int32]bool   // changes not yet sent upstream.
+	seqs    map[int32]uint64 // last upstream sequence numbers.
+	notify  chan struct{}
+
+	ticks, gaps, missed atomic.Uint64
+}
+
+// Stats contains the counters of a relay.
+type Stats struct {
+	Ticks  uint64 `json:"ticks"`
+	Gaps   uint64 `json:"gaps"`   // number of gaps in the upstream sequences.
+	Missed uint64 `json:"missed"` // number of ticks missing in gaps.
+}
+
+// Stats returns the current counters of the relay.
+func (rl *Relay) Stats() Stats {
+	return Stats{
+		Ticks:  rl.ticks.Load(),
+		Gaps:   rl.gaps.Load(),
+		Missed: rl.missed.Load(),
+	}
+}
+
+// Demand records a change in the local subscribers of an instrument. The
+// change is propagated upstream asynchronously, so Demand never blocks.
+func (rl *Relay) Demand(instrument int32, active bool) {
+	rl.mu.Lock()
+	if active {
+		rl.wanted[instrument] = true
+	} else {
+		delete(rl.wanted, instrument)
+	}
+	// the ticks published upstream while unsubscribed are not a loss.
+	delete(rl.seqs, instrument)
+	rl.pending[instrument] = active
+	rl.mu.Unlock()
+
+	select {
+	case rl.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run connects to the upstream server and relays ticks until the context
+// is cancelled, reconnecting on failures.
+func (rl *Relay) Run(ctx context.Context, pub ticker.Publisher) error {
+	for {
+		err := rl.session(ctx, pub)
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Warn().Err(err).Str("upstream", rl.addr).Msg("upstream session ended, reconnecting")
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (rl *Relay) session(ctx context.Context, pub ticker.Publisher) error {
+	conn, br, _, err := ws.Dial(ctx, rl.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	log.Info().Str("upstream", rl.addr).Msg("connected to upstream")
+
+	if br != nil {
+		defer ws.PutReader(br)
+	} else {
+		br = bufio.NewReader(conn)
+	}
+	wr := &connWriter{conn: conn}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	// a new session must subscribe to everything that is wanted. The ticks
+	// missed while reconnecting are not counted, since the upstream may
+	// have restarted.
+	rl.mu.Lock()
+	clear(rl.pending)
+	clear(rl.seqs)
+	instrs := make([]int32, 0, len(rl.wanted))
+	for instr := range rl.wanted {
+		instrs = append(instrs, instr)
+	}
+	rl.mu.Unlock()
+
+	if err := sendRequest(wr, ticker.ModeFull, instrs); err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		err := rl.syncDemand(ctx, wr)
+		if err != nil {
+			_ = conn.Close() // force a reconnect.
+		}
+		errCh <- err
+	}()
+
+	// control frames written by the reader are buffered and flushed as a
+	// whole to avoid interleaving with requests.
+	var ctrl bytes.Buffer
+	rd := struct {
+		io.Reader
+		io.Writer
+	}{br, &ctrl}
+
+	var batch []ticker.Tick
+	var seqs []uint64
+	for {
+		msg, op, err := wsutil.ReadServerData(rd)
+		if ctrl.Len() > 0 {
+			if werr := wr.write(ctrl.Bytes()); werr != nil {
+				return werr
+			}
+			ctrl.Reset()
+		}
+
+		if err != nil {
+			select {
+			case werr := <-errCh:
+				return errors.Join(err, werr)
+			default:
+				return err
+			}
+		} else if op != ws.OpBinary {
+			continue
+		}
+
+		q, _, err := ticker.ParseQuote(msg)
+		if err != nil {
+			log.Warn().Err(err).Msg("ignoring unknown upstream packet")
+			continue
+		}
+		batch = append(batch, ticker.Tick{Instrument: q.Instrument, Data: msg})
+		seqs = append(seqs, q.Seq)
+
+		if br.Buffered() > 0 && len(batch) < maxBatchSize {
+			continue // more messages readily available, keep batching.
+		}
+
+		rl.track(batch, seqs)
+
+		// live ticks cannot be slowed down without stalling the upstream,
+		// so the oldest queued ticks are shed instead.
+		opts := ticker.PublishOptions{Mode: ticker.PublishShedOldest, Timeout: publishTimeout}
+		if n, err := pub.Publish(opts, batch); err != nil {
+			log.Warn().Err(err).Int("count", len(batch)).Int("accepted", n).Msg("failed to publish")
+		}
+		batch, seqs = nil, seqs[:0]
+	}
+}
+
+// track counts the gaps in the upstream sequence numbers of the ticks.
+// Unsequenced ticks and the ticks of unwanted instruments are ignored, and
+// a lower sequence number is taken as a restart of the upstream.
+func (rl *Relay) track(ticks []ticker.Tick, seqs []uint64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.ticks.Add(uint64(len(ticks)))
+	for i, tick := range ticks {
+		seq := seqs[i]
+		if seq == 0 || !rl.wanted[tick.Instrument] {
+			continue
+		}
+
+		if last := rl.seqs[tick.Instrument]; last != 0 && seq > last+1 {
+			rl.gaps.Add(1)
+			rl.missed.Add(seq - last - 1)
+			log.Warn().Int32("instrument", tick.Instrument).Uint64("expected", last+1).Uint64("got", seq).Msg("upstream sequence gap")
+		}
+		rl.seqs[tick.Instrument] = seq
+	}
+}
+
+// syncDemand propagates the changes in demand to the upstream server.
+func (rl *Relay) syncDemand(ctx context.Context, wr *connWriter) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-rl.notify:
+		}
+
+		var subs, unsubs []int32
+		rl.mu.Lock()
+		for instr, active := range rl.pending {
+			if active {
+				subs = append(subs, instr)
+			} else {
+				unsubs = append(unsubs, instr)
+			}
+		}
+		clear(rl.pending)
+		rl.mu.Unlock()
+
+		if len(subs) == 0 && len(unsubs) == 0 {
+			continue
+		}
+
+		if err := sendRequest(wr, ticker.ModeFull, subs); err != nil {
+			return err
+		}
+		if err := sendRequest(wr, ticker.ModeNone, unsubs); err != nil {
+			return err
+		}
+		log.Debug().Int("subscribed", len(subs)).Int("unsubscribed", len(unsubs)).Msg("synced upstream subscriptions")
+	}
+}
+
+func sendRequest(wr *connWriter, mode ticker.Mode, instrs []int32) error {
+	if len(instrs) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(ticker.Request{Mode: mode, Instruments: instrs})
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := wsutil.WriteClientMessage(&buf, ws.OpText, b); err != nil {
+		return err
+	}
+	return wr.write(buf.Bytes())
+}
+
+// connWriter serialises writes of whole frames to the upstream connection.
+type connWriter struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (cw *connWriter) write(frames []byte) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	_, err := cw.conn.Write(frames)
+	return err
+}
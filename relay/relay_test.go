@@ -0,0 +1,231 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/spy16/ticktock/ticker"
+)
+
+// upstream is a fake core server that records the requests of the relay.
+type upstream struct {
+	ln       net.Listener
+	requests chan ticker.Request
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newUpstream(t *testing.T) *upstream {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := &upstream{ln: ln, requests: make(chan ticker.Request, 16)}
+	t.Cleanup(func() {
+		_ = ln.Close()
+		up.mu.Lock()
+		defer up.mu.Unlock()
+		if up.conn != nil {
+			_ = up.conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := ws.Upgrade(conn); err != nil {
+				_ = conn.Close()
+				continue
+			}
+			up.mu.Lock()
+			up.conn = conn
+			up.mu.Unlock()
+
+			go func() {
+				for {
+					msg, err := wsutil.ReadClientText(conn)
+					if err != nil {
+						return
+					}
+					var req ticker.Request
+					if err := json.Unmarshal(msg, &req); err != nil {
+						t.Errorf("bad request %q: %v", msg, err)
+						return
+					}
+					up.requests <- req
+				}
+			}()
+		}
+	}()
+	return up
+}
+
+func (up *upstream) url() string { return "ws://" + up.ln.Addr().String() }
+
+// expect waits for the next request of the relay.
+func (up *upstream) expect(t *testing.T, mode ticker.Mode, instrs ...int32) {
+	t.Helper()
+
+	select {
+	case req := <-up.requests:
+		slices.Sort(req.Instruments)
+		want := ticker.Request{Mode: mode, Instruments: instrs}
+		if !reflect.DeepEqual(req, want) {
+			t.Fatalf("request = %+v, want %+v", req, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no request, want mode %v for %v", mode, instrs)
+	}
+}
+
+// expectNone checks that the relay sends no request for a while.
+func (up *upstream) expectNone(t *testing.T) {
+	t.Helper()
+
+	select {
+	case req := <-up.requests:
+		t.Fatalf("unexpected request %+v", req)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// send sends the tick packets of the instrument with the sequence numbers.
+func (up *upstream) send(t *testing.T, instr int32, seqs ...uint64) {
+	t.Helper()
+
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	for _, seq := range seqs {
+		q := ticker.Quote{Instrument: instr, Seq: seq, LastPrice: 100}
+		if err := wsutil.WriteServerBinary(up.conn, q.AppendPacket(nil, ticker.ModeFull)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// publishFunc is a publisher calling the function.
+type publishFunc func(opts ticker.PublishOptions, ticks []ticker.Tick) (int, error)
+
+func (fn publishFunc) Publish(opts ticker.PublishOptions, ticks []ticker.Tick) (int, error) {
+	return fn(opts, ticks)
+}
+
+func discard(_ ticker.PublishOptions, ticks []ticker.Tick) (int, error) { return len(ticks), nil }
+
+// run runs the relay until the test ends.
+func run(t *testing.T, rl *Relay, pub ticker.Publisher) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- rl.Run(ctx, pub) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run() = %v", err)
+		}
+	})
+}
+
+// testSub is a local subscriber, named since pointers to empty structs
+// may be equal.
+type testSub struct{ name string }
+
+func (*testSub) EnqueuWrite([]byte) {}
+func (*testSub) EnqueuReply([]byte) {}
+
+func TestRelayDemand(t *testing.T) {
+	up := newUpstream(t)
+	rl := New(up.url())
+	rl.Demand(1, true)
+	rl.Demand(2, true)
+	run(t, rl, publishFunc(discard))
+
+	// a new session subscribes to all the wanted instruments.
+	up.expect(t, ticker.ModeFull, 1, 2)
+
+	// the upstream subscription follows the first and last local ones.
+	topics := ticker.NewTopics()
+	topics.OnDemand = rl.Demand
+	a, b := &testSub{"a"}, &testSub{"b"}
+
+	topics.Apply(a, ticker.Request{Mode: ticker.ModeLTP, Instruments: []int32{3}})
+	up.expect(t, ticker.ModeFull, 3)
+
+	topics.Apply(b, ticker.Request{Mode: ticker.ModeFull, Instruments: []int32{3}})
+	topics.Apply(a, ticker.Request{Mode: ticker.ModeNone, Instruments: []int32{3}})
+	up.expectNone(t)
+
+	topics.Apply(b, ticker.Request{Mode: ticker.ModeNone, Instruments: []int32{3}})
+	up.expect(t, ticker.ModeNone, 3)
+
+	rl.Demand(1, false)
+	up.expect(t, ticker.ModeNone, 1)
+}
+
+func TestRelayGaps(t *testing.T) {
+	up := newUpstream(t)
+	rl := New(up.url())
+	rl.Demand(1, true)
+
+	var mu sync.Mutex
+	var published []int32
+	run(t, rl, publishFunc(func(_ ticker.PublishOptions, ticks []ticker.Tick) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, tick := range ticks {
+			published = append(published, tick.Instrument)
+		}
+		return len(ticks), nil
+	}))
+	up.expect(t, ticker.ModeFull, 1)
+
+	waitTicks := func(n uint64) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); rl.Stats().Ticks < n; {
+			if time.Now().After(deadline) {
+				t.Fatalf("relayed %d of %d ticks", rl.Stats().Ticks, n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	up.send(t, 1, 1, 2, 5, 6)
+	up.send(t, 2, 7) // not wanted, so not tracked.
+	waitTicks(5)
+	if got, want := rl.Stats(), (Stats{Ticks: 5, Gaps: 1, Missed: 2}); got != want {
+		t.Fatalf("Stats() = %+v, want %+v", got, want)
+	}
+
+	// the ticks published while unsubscribed are not a loss, and a lower
+	// sequence number is a restart of the upstream.
+	rl.Demand(1, false)
+	up.expect(t, ticker.ModeNone, 1)
+	rl.Demand(1, true)
+	up.expect(t, ticker.ModeFull, 1)
+	up.send(t, 1, 10, 11, 1, 2)
+	waitTicks(9)
+	if got, want := rl.Stats(), (Stats{Ticks: 9, Gaps: 1, Missed: 2}); got != want {
+		t.Fatalf("Stats() = %+v, want %+v", got, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []int32{1, 1, 1, 1, 2, 1, 1, 1, 1}; !reflect.DeepEqual(published, want) {
+		t.Fatalf("published %v, want %v", published, want)
+	}
+}
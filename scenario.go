@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/spy16/ticktock/ticker"
+	"gopkg.in/yaml.v3"
+)
+
+var scenarioModes = map[string]ticker.Mode{
+	"ltp":   ticker.ModeLTP,
+	"quote": ticker.ModeQuote,
+	"full":  ticker.ModeFull,
+}
+
+// scenario describes a load test run by the client command.
+type scenario struct {
+	Addr     string        `yaml:"addr"`
+	Token    string        `yaml:"token"`
+	Duration time.Duration `yaml:"duration"` // 0 runs until interrupted.
+
+	Clients int     `yaml:"clients"`
+	RampUp  float64 `yaml:"ramp_up"` // connections per second, 0 for all at once.
+
+	// each client subscribes to Subscriptions random instruments out of
+	// [0, Instruments) in a mode picked from the weighted Modes.
+	Instruments   int                `yaml:"instruments"`
+	Subscriptions int                `yaml:"subscriptions"`
+	Modes         map[string]float64 `yaml:"modes"`
+
+	// every Churn, ChurnRatio of the subscriptions of a client are replaced
+	// with other instruments.
+	Churn      time.Duration `yaml:"churn"`
+	ChurnRatio float64       `yaml:"churn_ratio"`
+
+	// clients disconnect after Session (0 to stay connected), and reconnect
+	// after ReconnectDelay if Reconnect is set (also when dropped). With
+	// Resume, clients reconnect with their session token.
+	Session        time.Duration `yaml:"session"`
+	Reconnect      bool          `yaml:"reconnect"`
+	ReconnectDelay time.Duration `yaml:"reconnect_delay"`
+	Resume         bool          `yaml:"resume"`
+
+	// clients pause for a random time of ThinkTime on average before each
+	// request.
+	ThinkTime  time.Duration `yaml:"think_time"`
+	ReplayGaps bool          `yaml:"replay_gaps"`
+}
+
+// loadScenario reads a YAML (or JSON) scenario file.
+func loadScenario(path string) (*scenario, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &scenario{
+		Clients:       100,
+		Instruments:   10,
+		Subscriptions: 3,
+		ChurnRatio:    0.5,
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(sc); err != nil {
+		return nil, fmt.Errorf("invalid scenario '%s': %w", path, err)
+	}
+
+	if len(sc.Modes) == 0 {
+		sc.Modes = map[string]float64{"ltp": 1}
+	}
+	for name, weight := range sc.Modes {
+		if _, found := scenarioModes[name]; !found {
+			return nil, fmt.Errorf("invalid scenario '%s': unknown mode '%s' (expecting ltp, quote or full)", path, name)
+		} else if weight < 0 {
+			return nil, fmt.Errorf("invalid scenario '%s': negative weight of mode '%s'", path, name)
+		}
+	}
+	if sc.Clients <= 0 || sc.Instruments <= 0 || sc.Subscriptions <= 0 {
+		return nil, fmt.Errorf("invalid scenario '%s': clients, instruments and subscriptions must be positive", path)
+	}
+	return sc, nil
+}
+
+// loadStats counts the events of a load test.
+type loadStats struct {
+	active    atomic.Int64 // number of connected clients.
+	connects  atomic.Int64 // number of connections.
+	failures  atomic.Int64 // number of failed connection attempts.
+	drops     atomic.Int64 // number of connections closed by the server.
+	resumed   atomic.Int64 // number of sessions resumed on reconnect.
+	requests  atomic.Int64 // number of subscription requests sent.
+	rejected  atomic.Int64 // number of subscriptions rejected by the server.
+	startedAt time.Time
+}
+
+// loadSummary is the summary of a load test in the report.
+type loadSummary struct {
+	Duration    float64 `json:"duration_s"`
+	Connects    int64   `json:"connects"`
+	Failures    int64   `json:"failures"`
+	Drops       int64   `json:"drops"`
+	Resumed     int64   `json:"resumed"`
+	Requests    int64   `json:"requests"`
+	Rejected    int64   `json:"rejected"`
+	MessageRate float64 `json:"messages_per_s"`
+}
+
+func (ls *loadStats) summarize(messages int64) *loadSummary {
+	elapsed := time.Since(ls.startedAt).Seconds()
+	return &loadSummary{
+		Duration:    elapsed,
+		Connects:    ls.connects.Load(),
+		Failures:    ls.failures.Load(),
+		Drops:       ls.drops.Load(),
+		Resumed:     ls.resumed.Load(),
+		Requests:    ls.requests.Load(),
+		Rejected:    ls.rejected.Load(),
+		MessageRate: float64(messages) / elapsed,
+	}
+}
+
+// countReply counts the resumed sessions and rejected subscriptions of a
+// reply. It returns the reply if it is a session reply.
+func (ls *loadStats) countReply(msg []byte) *ticker.Session {
+	var reply struct {
+		Action string `json:"a"`
+	}
+	if err := json.Unmarshal(msg, &reply); err != nil {
+		return nil
+	}
+
+	switch reply.Action {
+	case ticker.ActionSession:
+		var session ticker.Session
+		if err := json.Unmarshal(msg, &session); err != nil {
+			return nil
+		}
+		if session.Resumed {
+			ls.resumed.Add(1)
+		}
+		return &session
+
+	case ticker.ActionAck:
+		var ack ticker.Ack
+		if err := json.Unmarshal(msg, &ack); err != nil {
+			return nil
+		}
+		rejected := len(ack.Rejected) + len(ack.RejectedSymbols) + len(ack.RejectedGroups)
+		ls.rejected.Add(int64(rejected))
+	}
+	return nil
+}
+
+// runScenario runs the load test until its duration is over or the context
+// is canceled.
+func runScenario(ctx context.Context, sc *scenario, stats *loadStats, gaps *gapStats, latency *clientLatency) {
+	if sc.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sc.Duration)
+		defer cancel()
+	}
+
+	var interval time.Duration
+	if sc.RampUp > 0 {
+		interval = time.Duration(float64(time.Second) / sc.RampUp)
+	}
+
+	log.Printf("starting %d clients (ramp-up=%.1f/s)", sc.Clients, sc.RampUp)
+	stats.startedAt = time.Now()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < sc.Clients; i++ {
+		if i > 0 && !sleep(ctx, interval) {
+			break
+		}
+
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			sc.runClient(ctx, id, stats, gaps, latency)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// logLoad logs the number of connected clients and the connection churn of
+// each interval.
+func logLoad(ctx context.Context, stats *loadStats, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	var connects, failures int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-t.C:
+			c, f := stats.connects.Load(), stats.failures.Load()
+			log.Printf("clients: active=%d connects=%d failures=%d", stats.active.Load(), c-connects, f-failures)
+			connects, failures = c, f
+		}
+	}
+}
+
+// runClient runs a simulated client, reconnecting as configured until the
+// context is canceled.
+func (sc *scenario) runClient(ctx context.Context, id int, stats *loadStats, gaps *gapStats, latency *clientLatency) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(id)))
+
+	cl := &scenarioClient{
+		sc:          sc,
+		rng:         rng,
+		stats:       stats,
+		latency:     latency,
+		tracker:     &seqTracker{stats: gaps, last: make(map[int32]uint64)},
+		mode:        sc.pickMode(rng),
+		instruments: sc.pickInstruments(rng, sc.Subscriptions, nil),
+	}
+
+	for {
+		if err := cl.runSession(ctx); err != nil {
+			log.Printf("client %d failed: %v", id, err)
+		}
+
+		if ctx.Err() != nil || !sc.Reconnect || !sleep(ctx, sc.ReconnectDelay) {
+			return
+		}
+	}
+}
+
+func (sc *scenario) pickMode(rng *rand.Rand) ticker.Mode {
+	names := make([]string, 0, len(sc.Modes))
+	var total float64
+	for name, weight := range sc.Modes {
+		names = append(names, name)
+		total += weight
+	}
+	sort.Strings(names)
+
+	x := rng.Float64() * total
+	for _, name := range names {
+		if x -= sc.Modes[name]; x < 0 {
+			return scenarioModes[name]
+		}
+	}
+	return scenarioModes[names[len(names)-1]]
+}
+
+// pickInstruments picks n distinct random instruments that are not in the
+// excluded ones.
+func (sc *scenario) pickInstruments(rng *rand.Rand, n int, exclude []int32) []int32 {
+	picked := make(map[int32]bool, n+len(exclude))
+	for _, instr := range exclude {
+		picked[instr] = true
+	}
+
+	n = min(n, sc.Instruments-len(picked))
+	instruments := make([]int32, 0, n)
+	for len(instruments) < n {
+		instr := rng.Int31n(int32(sc.Instruments))
+		if !picked[instr] {
+			picked[instr] = true
+			instruments = append(instruments, instr)
+		}
+	}
+	return instruments
+}
+
+// scenarioClient is a client simulated by a scenario. Its subscriptions and
+// session outlive its connections.
+type scenarioClient struct {
+	sc      *scenario
+	rng     *rand.Rand
+	stats   *loadStats
+	latency *clientLatency
+	tracker *seqTracker
+
+	mode        ticker.Mode
+	instruments []int32
+
+	mu      sync.Mutex
+	session string
+}
+
+// runSession connects the client, subscribes and churns its subscriptions
+// until the session is over, the connection is closed by the server or the
+// context is canceled.
+func (cl *scenarioClient) runSession(ctx context.Context) error {
+	sc := cl.sc
+
+	addr := sc.Addr
+	cl.mu.Lock()
+	session := cl.session
+	cl.mu.Unlock()
+	if sc.Resume && session != "" {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return err
+		}
+		q := u.Query()
+		q.Set("session", session)
+		u.RawQuery = q.Encode()
+		addr = u.String()
+	}
+
+	cc, err := dialServer(ctx, addr, sc.Token)
+	if err != nil {
+		cl.stats.failures.Add(1)
+		return err
+	}
+
+	cl.stats.connects.Add(1)
+	cl.stats.active.Add(1)
+	defer cl.stats.active.Add(-1)
+
+	if !sc.Resume {
+		// gaps across connections are expected without resumption.
+		cl.tracker.forget(cl.instruments...)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cc.readTicks(cl.tracker, cl.latency, sc.ReplayGaps, cl.onReply)
+	}()
+	defer func() {
+		_ = cc.Close()
+		<-done
+	}()
+
+	var sessionEnd <-chan time.Time
+	if sc.Session > 0 {
+		t := time.NewTimer(sc.Session)
+		defer t.Stop()
+		sessionEnd = t.C
+	}
+
+	var churn <-chan time.Time
+	if sc.Churn > 0 {
+		t := time.NewTicker(sc.Churn)
+		defer t.Stop()
+		churn = t.C
+	}
+
+	leave := func() error {
+		_ = cc.write(ws.OpClose, nil)
+		return nil
+	}
+
+	if !cl.think(ctx) {
+		return leave()
+	}
+	if err := cl.subscribe(cc, cl.mode, cl.instruments); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-done:
+			if ctx.Err() == nil {
+				cl.stats.drops.Add(1)
+			}
+			return nil
+
+		case <-ctx.Done():
+			return leave()
+
+		case <-sessionEnd:
+			return leave()
+
+		case <-churn:
+			n := max(int(math.Round(sc.ChurnRatio*float64(len(cl.instruments)))), 1)
+			n = min(n, len(cl.instruments))
+			cl.rng.Shuffle(len(cl.instruments), func(i, j int) {
+				cl.instruments[i], cl.instruments[j] = cl.instruments[j], cl.instruments[i]
+			})
+			dropped := cl.instruments[:n]
+			added := cl.sc.pickInstruments(cl.rng, n, cl.instruments)
+
+			if !cl.think(ctx) {
+				return leave()
+			}
+			if err := cl.subscribe(cc, ticker.ModeNone, dropped); err != nil {
+				return err
+			}
+			if !cl.think(ctx) {
+				return leave()
+			}
+			cl.tracker.forget(added...)
+			if err := cl.subscribe(cc, cl.mode, added); err != nil {
+				return err
+			}
+			cl.instruments = append(cl.instruments[n:], added...)
+		}
+	}
+}
+
+func (cl *scenarioClient) subscribe(cc *clientConn, mode ticker.Mode, instruments []int32) error {
+	if len(instruments) == 0 {
+		return nil
+	}
+	cl.stats.requests.Add(1)
+	return cc.write(ws.OpText, jsonStr(ticker.Request{Mode: mode, Instruments: instruments}))
+}
+
+// onReply keeps the session token of the client.
+func (cl *scenarioClient) onReply(msg []byte) {
+	if session := cl.stats.countReply(msg); session != nil {
+		cl.mu.Lock()
+		cl.session = session.Token
+		cl.mu.Unlock()
+	}
+}
+
+// think pauses for a random time of ThinkTime on average. It returns false
+// if the context is canceled meanwhile.
+func (cl *scenarioClient) think(ctx context.Context) bool {
+	if cl.sc.ThinkTime <= 0 {
+		return ctx.Err() == nil
+	}
+	return sleep(ctx, time.Duration(cl.rng.Int63n(int64(2*cl.sc.ThinkTime))))
+}
+
+// sleep waits for d. It returns false if the context is canceled meanwhile.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
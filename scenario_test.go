@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spy16/ticktock/ticker"
+)
+
+// serveBroker serves a broker on a loopback port until the test ends and
+// returns its websocket address.
+func serveBroker(t *testing.T, serverType string) (Broker, string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := setupBroker(ctx, serverType, "", brokerOptions{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = srv.Serve(ctx, addr)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("the broker is not listening: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return srv, "ws://" + addr
+}
+
+func TestLoadScenario(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    *scenario
+		err     string
+	}{
+		{
+			name:    "Defaults",
+			content: "addr: ws://localhost:8080\n",
+			want: &scenario{
+				Addr:          "ws://localhost:8080",
+				Clients:       100,
+				Instruments:   10,
+				Subscriptions: 3,
+				ChurnRatio:    0.5,
+				Modes:         map[string]float64{"ltp": 1},
+			},
+		},
+		{
+			name: "Full",
+			content: `addr: ws://localhost:8080
+duration: 1m
+clients: 5
+ramp_up: 2.5
+instruments: 100
+subscriptions: 10
+modes: {ltp: 3, full: 1}
+churn: 5s
+churn_ratio: 0.2
+session: 30s
+reconnect: true
+reconnect_delay: 1s
+resume: true
+think_time: 10ms
+replay_gaps: true
+`,
+			want: &scenario{
+				Addr:           "ws://localhost:8080",
+				Duration:       time.Minute,
+				Clients:        5,
+				RampUp:         2.5,
+				Instruments:    100,
+				Subscriptions:  10,
+				Modes:          map[string]float64{"ltp": 3, "full": 1},
+				Churn:          5 * time.Second,
+				ChurnRatio:     0.2,
+				Session:        30 * time.Second,
+				Reconnect:      true,
+				ReconnectDelay: time.Second,
+				Resume:         true,
+				ThinkTime:      10 * time.Millisecond,
+				ReplayGaps:     true,
+			},
+		},
+		{name: "UnknownField", content: "clients: 5\nusers: 5\n", err: "field users not found"},
+		{name: "UnknownMode", content: "modes: {depth: 1}\n", err: "unknown mode 'depth'"},
+		{name: "NegativeWeight", content: "modes: {ltp: -1}\n", err: "negative weight of mode 'ltp'"},
+		{name: "NoClients", content: "clients: 0\n", err: "must be positive"},
+		{name: "NoSubscriptions", content: "subscriptions: -1\n", err: "must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scenario.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			sc, err := loadScenario(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("loadScenario() error = %v, want %q", err, tt.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sc, tt.want) {
+				t.Fatalf("loadScenario() = %+v, want %+v", sc, tt.want)
+			}
+		})
+	}
+}
+
+func TestScenarioPickMode(t *testing.T) {
+	const picks = 10000
+
+	sc := &scenario{Modes: map[string]float64{"ltp": 3, "quote": 0, "full": 1}}
+	rng := rand.New(rand.NewSource(1))
+	counts := make(map[ticker.Mode]int)
+	for i := 0; i < picks; i++ {
+		counts[sc.pickMode(rng)]++
+	}
+
+	if counts[ticker.ModeQuote] != 0 {
+		t.Errorf("picked the mode of weight 0 %d times", counts[ticker.ModeQuote])
+	}
+	if ratio := float64(counts[ticker.ModeLTP]) / picks; math.Abs(ratio-0.75) > 0.02 {
+		t.Errorf("picked ltp %.1f%% of the times, want 75%%", 100*ratio)
+	}
+}
+
+func TestScenarioPickInstruments(t *testing.T) {
+	sc := &scenario{Instruments: 10}
+	rng := rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		name    string
+		n       int
+		exclude []int32
+		want    int
+	}{
+		{"Some", 3, nil, 3},
+		{"All", 10, nil, 10},
+		{"MoreThanAll", 20, nil, 10},
+		{"Excluded", 5, []int32{0, 1, 2}, 5},
+		{"AllButExcluded", 10, []int32{0, 1, 2}, 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sc.pickInstruments(rng, tt.n, tt.exclude)
+			if len(got) != tt.want {
+				t.Fatalf("picked %d instruments, want %d", len(got), tt.want)
+			}
+
+			seen := make(map[int32]bool)
+			for _, instr := range tt.exclude {
+				seen[instr] = true
+			}
+			for _, instr := range got {
+				if instr < 0 || instr >= 10 || seen[instr] {
+					t.Fatalf("picked %v out of [0, 10) excluding %v", got, tt.exclude)
+				}
+				seen[instr] = true
+			}
+		})
+	}
+}
+
+func TestLoadStatsCountReply(t *testing.T) {
+	var stats loadStats
+	replies := []any{
+		ticker.Session{Action: ticker.ActionSession, Token: "a"},
+		ticker.Session{Action: ticker.ActionSession, Token: "b", Resumed: true},
+		ticker.Ack{Action: ticker.ActionAck, Rejected: []int32{1, 2}, RejectedSymbols: []string{"X"}},
+		ticker.Ack{Action: ticker.ActionAck, RejectedGroups: []string{"G"}},
+		ticker.List{Action: ticker.ActionList},
+	}
+
+	var tokens []string
+	for _, reply := range replies {
+		if session := stats.countReply(jsonStr(reply)); session != nil {
+			tokens = append(tokens, session.Token)
+		}
+	}
+	if stats.countReply([]byte("not json")) != nil {
+		t.Error("countReply() returned a session for an invalid reply")
+	}
+
+	if !reflect.DeepEqual(tokens, []string{"a", "b"}) {
+		t.Errorf("session tokens %v, want [a b]", tokens)
+	}
+	if stats.resumed.Load() != 1 || stats.rejected.Load() != 4 {
+		t.Errorf("counted %d resumed sessions and %d rejections, want 1 and 4", stats.resumed.Load(), stats.rejected.Load())
+	}
+}
+
+func TestRunScenario(t *testing.T) {
+	_, addr := serveBroker(t, "gorillav1")
+
+	sc := &scenario{
+		Addr:           addr,
+		Duration:       500 * time.Millisecond,
+		Clients:        3,
+		Instruments:    10,
+		Subscriptions:  3,
+		Modes:          map[string]float64{"ltp": 1},
+		Churn:          50 * time.Millisecond,
+		ChurnRatio:     0.5,
+		Session:        200 * time.Millisecond,
+		Reconnect:      true,
+		ReconnectDelay: 10 * time.Millisecond,
+	}
+	var stats loadStats
+	runScenario(context.Background(), sc, &stats, &gapStats{}, newClientLatency())
+
+	// every client reconnects at least once after its session, and churns
+	// its subscriptions during each session.
+	if connects := stats.connects.Load(); connects < 6 {
+		t.Errorf("%d connections, want at least 6", connects)
+	}
+	if requests := stats.requests.Load(); requests <= stats.connects.Load() {
+		t.Errorf("%d requests in %d connections, want churns", requests, stats.connects.Load())
+	}
+	if stats.failures.Load() != 0 || stats.drops.Load() != 0 || stats.rejected.Load() != 0 {
+		t.Errorf("%d failures, %d drops and %d rejections, want none", stats.failures.Load(), stats.drops.Load(), stats.rejected.Load())
+	}
+	if active := stats.active.Load(); active != 0 {
+		t.Errorf("%d clients still active", active)
+	}
+}
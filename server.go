@@ -3,17 +3,26 @@ package main
 import (
 	"context"
 	_ "embed"
+	"expvar"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spy16/ticktock/brokers/gobwasv1"
 	"github.com/spy16/ticktock/brokers/gobwasv2"
 	"github.com/spy16/ticktock/brokers/gorillav1"
 	"github.com/spy16/ticktock/brokers/gorillav2"
 	"github.com/spy16/ticktock/brokers/gorillav3"
+	"github.com/spy16/ticktock/brokers/netpollv1"
 	"github.com/spy16/ticktock/brokers/quickwsv1"
+	"github.com/spy16/ticktock/cluster"
+	"github.com/spy16/ticktock/relay"
 	"github.com/spy16/ticktock/ticker"
 	"github.com/spy16/ticktock/utils"
 )
@@ -26,21 +35,66 @@ type Server interface {
 	Serve(ctx context.Context, addr string) error
 }
 
+// Broker is a websocket server that publishes ticks to its subscribers.
+type Broker interface {
+	Server
+	ticker.Publisher
+
+	// Topics returns the subscription registry of the broker for
+	// configuration before serving.
+	Topics() *ticker.Topics
+
+	// Update runs fn with the subscription registry while serving, in sync
+	// with the subscription requests of the clients.
+	Update(fn func(t *ticker.Topics))
+}
+
 func cmdServe() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "serve",
 		Short: "Starts the socket server",
 	}
 
-	var addr, serverType, brokerType string
-	var count, tradeCount int
-	var tickRate time.Duration
+	var addr, pprofAddr, serverType, brokerType string
+	var groupsFile, masterFile string
+	var configFile string
+	var authTokens []string
+	var maxConns, maxSubs, memoryBudget int
+	var memoryShed bool
+	var trace bool
+	var historySize, sessionBuffer int
+	var source sourceOptions
+	var tuning brokerOptions
+	var sessionGrace time.Duration
+	cmd.Flags().StringVar(&configFile, "config", "", "YAML or TOML config file (flags override the file, SIGHUP reloads the log level, limits and auth)")
 	cmd.Flags().StringVarP(&addr, "addr", "a", ":8080", "server address")
-	cmd.Flags().IntVarP(&count, "instruments", "i", 100, "Number of instruments to stream")
+	cmd.Flags().StringVar(&pprofAddr, "pprof-addr", ":6060", "pprof and debug server address (empty to disable)")
 	cmd.Flags().StringVarP(&serverType, "server", "s", "gorillav1", "Server Model to be Used")
 	cmd.Flags().StringVarP(&brokerType, "broker", "b", "lockbased", "Broker Model to be Used")
-	cmd.Flags().DurationVarP(&tickRate, "tickrate", "t", 100*time.Millisecond, "Tick Rate")
-	cmd.Flags().IntVarP(&tradeCount, "trade-count", "c", 5000, "Number of trades to generate per tick")
+	cmd.Flags().StringVar(&groupsFile, "groups", "", "JSON file with the instrument groups clients can subscribe to (reloaded on SIGHUP)")
+	cmd.Flags().StringVar(&masterFile, "master", "", "Instrument master (CSV or JSON) to validate subscriptions and resolve symbols with (reloaded on SIGHUP)")
+	cmd.Flags().DurationVar(&sessionGrace, "session-grace", 0, "How long the sessions of disconnected clients are kept for resumption (0 disables sessions)")
+	cmd.Flags().IntVar(&sessionBuffer, "session-buffer", 1000, "Maximum number of packets buffered for a disconnected session")
+	cmd.Flags().IntVar(&maxConns, "max-connections", 0, "Maximum number of connected clients (0 for no limit)")
+	cmd.Flags().IntVar(&maxSubs, "max-subscriptions", 0, "Maximum number of instruments a client can subscribe to (0 for no limit)")
+	cmd.Flags().IntVar(&memoryBudget, "memory-budget", 0, "Estimated memory in MB the connections may use before new ones are refused (0 for no budget, see /admin/memory)")
+	cmd.Flags().BoolVar(&memoryShed, "memory-shed", false, "Disconnect the heaviest clients when over the memory budget")
+	cmd.Flags().StringSliceVar(&authTokens, "auth-tokens", nil, "Tokens clients must present as a bearer token or the token query parameter (empty disables auth)")
+	cmd.Flags().BoolVar(&trace, "trace", false, "Append the times of the delivery stages to the packets (see ticktock client)")
+	cmd.Flags().IntVar(&historySize, "history", 100, "Number of recent ticks per instrument kept for replays")
+	cmd.Flags().IntVar(&tuning.TickQueue, "tick-queue", 0, "Number of published ticks buffered by the broker (0 for the broker default)")
+	cmd.Flags().IntVar(&tuning.RequestQueue, "request-queue", 0, "Capacity of the subscription request queue of the broker (0 for the broker default)")
+	cmd.Flags().IntVar(&tuning.ClientQueue, "client-queue", 0, "Number of packets buffered per client (0 for the broker default)")
+	cmd.Flags().IntVar(&tuning.ReadBufferSize, "read-buffer", 0, "Read buffer size per connection of the gorilla servers (0 for the default)")
+	cmd.Flags().IntVar(&tuning.WriteBufferSize, "write-buffer", 0, "Write buffer size per connection of the gorilla servers (0 for the default)")
+	cmd.Flags().DurationVar(&tuning.ReadTimeout, "read-timeout", 0, "Idle time after which silent clients are disconnected by the gorilla and quickws servers (0 for the default)")
+	cmd.Flags().DurationVar(&tuning.WriteInterval, "write-interval", 0, "Interval at which gorillav3 flushes the packets queued for a client (0 for the default)")
+	cmd.Flags().IntVar(&tuning.PollBatch, "poll-batch", 0, "Maximum number of ready connections gobwasv2 and netpollv1 take from the poller at once (0 for the default)")
+	cmd.Flags().BoolVar(&tuning.PreparedFrames, "prepared-frames", false, "Frame each packet once per tick and mode for all the subscribers in the gobwas, gorillav1/v2 and netpoll servers")
+	cmd.Flags().IntVar(&tuning.MaxWriteBytes, "max-write-bytes", 0, "Maximum size of the packets the gobwas and netpoll servers send to a client in a single vectored write (0 for the default)")
+	cmd.Flags().IntVar(&tuning.Workers, "workers", 0, "Number of goroutines netpollv1 reads and writes the ready connections with (0 for the default)")
+	cmd.Flags().BoolVar(&tuning.PooledBuffers, "pooled-buffers", false, "Release the per-connection buffers of the gobwas servers after the upgrade and borrow pooled ones per frame")
+	source.addFlags(cmd.Flags())
 
 	cmd.Run = func(cmd *cobra.Command, args []string) {
 		if v, err := utils.SetMaxFdLimit(); err != nil {
@@ -49,74 +103,437 @@ func cmdServe() *cobra.Command {
 			log.Info().Uint64("max_open_files", v).Msg("max open files set")
 		}
 
-		go func() {
-			http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-				w.Write([]byte(indexFile))
+		if pprofAddr != "" {
+			go func() {
+				http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(indexFile))
+				})
+				log.Info().Str("addr", pprofAddr).Msg("starting pprof server")
+				if err := http.ListenAndServe(pprofAddr, nil); err != nil {
+					log.Fatal().Err(err).Msg("pprof server exited")
+				}
+			}()
+		}
+
+		adm := &utils.Admission{}
+		adm.SetMaxConns(maxConns)
+		adm.SetTokens(authTokens)
+		tuning.Admission = adm
+
+		srv := setupBroker(cmd.Context(), serverType, brokerType, tuning)
+		adm.Conns = srv.Topics().Connected
+		if wr, ok := srv.(interface{ WriteStats() ticker.WriteStats }); ok {
+			expvar.Publish("writes", expvar.Func(func() any { return wr.WriteStats() }))
+		}
+		if qb, ok := srv.(interface{ Queue() *ticker.Queue }); ok {
+			qb.Queue().OnWatermark(func(depth, capacity int, high bool) {
+				if high {
+					log.Warn().Int("depth", depth).Int("capacity", capacity).Msg("broker queue is congested")
+				} else {
+					log.Info().Int("depth", depth).Int("capacity", capacity).Msg("broker queue recovered")
+				}
 			})
-			addr := ":6060"
-			log.Info().Str("addr", addr).Msg("starting pprof server")
-			if err := http.ListenAndServe(addr, nil); err != nil {
-				log.Fatal().Err(err).Msg("pprof server exited")
-			}
-		}()
+		}
 
-		srv, publisher := setupServerAndPublisher(cmd.Context(), serverType, brokerType)
+		if groupsFile != "" {
+			setupGroups(cmd.Context(), srv, groupsFile)
+		}
+		if masterFile != "" {
+			setupMaster(cmd.Context(), srv, masterFile)
+		}
+
+		if sessionGrace > 0 {
+			srv.Topics().Sessions = ticker.NewSessions(sessionGrace, sessionBuffer)
+			go expireSessions(cmd.Context(), srv)
+		}
+
+		srv.Topics().MaxSubscriptions = maxSubs
+		srv.Topics().Trace = trace
+
+		mg := &memoryGuard{srv: srv}
+		mg.SetBudget(memoryBudget, memoryShed)
+		adm.OverBudget = mg.OverBudget
+		registerMemoryAdmin(http.DefaultServeMux, mg)
+		go mg.Run(cmd.Context())
 
-		// start a tick source that publishes random data to the broker.
-		ts := &ticker.Ticker{
-			TickRate:   tickRate,
-			Publisher:  publisher,
-			TradeCount: tradeCount,
+		if cfg, ok := cmd.Context().Value(configKey{}).(*config); ok {
+			go onHangup(cmd.Context(), func() {
+				if err := cfg.reload(cmd.Flags()); err != nil {
+					log.Error().Err(err).Msg("failed to reload config")
+					return
+				}
+
+				if level, err := zerolog.ParseLevel(cmd.Flag("log-level").Value.String()); err == nil {
+					zerolog.SetGlobalLevel(level)
+				}
+				adm.SetMaxConns(maxConns)
+				adm.SetTokens(authTokens)
+				mg.SetBudget(memoryBudget, memoryShed)
+				srv.Update(func(t *ticker.Topics) { t.MaxSubscriptions = maxSubs })
+				log.Info().Str("path", cfg.path).Msg("reloaded config")
+			})
 		}
-		go ts.Run(cmd.Context())
+
+		seq := ticker.NewSequencer(srv, historySize)
+		srv.Topics().History = seq
+
+		ts, publisher, cleanup := setupSource(cmd.Context(), srv, seq, source)
+		defer cleanup()
+
+		go func() {
+			if err := ts.Run(cmd.Context(), publisher); err != nil {
+				log.Error().Err(err).Str("source", source.name()).Msg("tick source exited")
+				return
+			}
+			log.Info().Str("source", source.name()).Msg("tick source finished")
+		}()
 
 		log.Info().Str("addr", addr).Msg("starting server")
 		if err := srv.Serve(cmd.Context(), addr); err != nil {
-			log.Fatal().Err(err).Msg("server exited")
+			log.Error().Err(err).Msg("server exited")
 		}
 	}
 
 	return cmd
 }
 
-func setupServerAndPublisher(ctx context.Context, serverType, brokerType string) (Server, ticker.Publisher) {
+// brokerOptions are the tuning knobs of the brokers. Each broker uses the
+// ones that apply to it, zero values use its defaults.
+type brokerOptions struct {
+	TickQueue       int
+	RequestQueue    int
+	ClientQueue     int
+	ReadBufferSize  int
+	WriteBufferSize int
+	ReadTimeout     time.Duration
+	WriteInterval   time.Duration
+	PollBatch       int
+	PooledBuffers   bool
+	PreparedFrames  bool
+	MaxWriteBytes   int
+	Workers         int
+	Admission       *utils.Admission
+}
+
+func setupBroker(ctx context.Context, serverType, brokerType string, opts brokerOptions) Broker {
 	switch serverType {
 
 	case "gorillav1":
-		srv, err := gorillav1.New()
+		srv, err := gorillav1.New(gorillav1.Options{
+			ClientQueue:     opts.ClientQueue,
+			ReadBufferSize:  opts.ReadBufferSize,
+			WriteBufferSize: opts.WriteBufferSize,
+			ReadTimeout:     opts.ReadTimeout,
+			PreparedFrames:  opts.PreparedFrames,
+			Admission:       opts.Admission,
+		})
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to create server")
 		}
-		return srv, srv
+		return srv
 
 	case "gorillav2":
-		srv, err := gorillav2.New()
+		srv, err := gorillav2.New(gorillav2.Options{
+			TickQueue:       opts.TickQueue,
+			RequestQueue:    opts.RequestQueue,
+			ClientQueue:     opts.ClientQueue,
+			ReadBufferSize:  opts.ReadBufferSize,
+			WriteBufferSize: opts.WriteBufferSize,
+			ReadTimeout:     opts.ReadTimeout,
+			PreparedFrames:  opts.PreparedFrames,
+			Admission:       opts.Admission,
+		})
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to create server")
 		}
-		return srv, srv
+		return srv
 
 	case "gorillav3":
-		srv, err := gorillav3.New()
+		srv, err := gorillav3.New(gorillav3.Options{
+			TickQueue:       opts.TickQueue,
+			RequestQueue:    opts.RequestQueue,
+			ClientQueue:     opts.ClientQueue,
+			ReadBufferSize:  opts.ReadBufferSize,
+			WriteBufferSize: opts.WriteBufferSize,
+			ReadTimeout:     opts.ReadTimeout,
+			WriteInterval:   opts.WriteInterval,
+			Admission:       opts.Admission,
+		})
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to create server")
 		}
-		return srv, srv
+		return srv
 
 	case "gobwasv1":
-		srv := gobwasv1.New()
-		return srv, srv
+		srv := gobwasv1.New(gobwasv1.Options{
+			TickQueue:      opts.TickQueue,
+			RequestQueue:   opts.RequestQueue,
+			ClientQueue:    opts.ClientQueue,
+			PooledBuffers:  opts.PooledBuffers,
+			PreparedFrames: opts.PreparedFrames,
+			MaxWriteBytes:  opts.MaxWriteBytes,
+			Admission:      opts.Admission,
+		})
+		return srv
 
 	case "gobwasv2":
-		srv := gobwasv2.New()
-		return srv, srv
+		srv := gobwasv2.New(gobwasv2.Options{
+			TickQueue:      opts.TickQueue,
+			RequestQueue:   opts.RequestQueue,
+			ClientQueue:    opts.ClientQueue,
+			PollBatch:      opts.PollBatch,
+			PooledBuffers:  opts.PooledBuffers,
+			PreparedFrames: opts.PreparedFrames,
+			MaxWriteBytes:  opts.MaxWriteBytes,
+			Admission:      opts.Admission,
+		})
+		return srv
+
+	case "netpollv1":
+		srv := netpollv1.New(netpollv1.Options{
+			TickQueue:      opts.TickQueue,
+			RequestQueue:   opts.RequestQueue,
+			ClientQueue:    opts.ClientQueue,
+			Workers:        opts.Workers,
+			PollBatch:      opts.PollBatch,
+			PreparedFrames: opts.PreparedFrames,
+			MaxWriteBytes:  opts.MaxWriteBytes,
+			Admission:      opts.Admission,
+		})
+		return srv
 
 	case "quickwsv1":
-		srv := quickwsv1.New()
-		return srv, srv
+		srv := quickwsv1.New(quickwsv1.Options{
+			TickQueue:    opts.TickQueue,
+			RequestQueue: opts.RequestQueue,
+			ClientQueue:  opts.ClientQueue,
+			ReadTimeout:  opts.ReadTimeout,
+			Admission:    opts.Admission,
+		})
+		return srv
 
 	default:
 		log.Fatal().Str("server", serverType).Msg("unknown server type")
-		return nil, nil
+		return nil
+	}
+}
+
+// expireSessions periodically removes the disconnected sessions whose grace
+// period is over.
+func expireSessions(ctx context.Context, srv Broker) {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case now := <-tick.C:
+			srv.Update(func(t *ticker.Topics) { t.Expire(now) })
+		}
+	}
+}
+
+// onHangup invokes fn on every SIGHUP until the context is cancelled.
+func onHangup(ctx context.Context, fn func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hup:
+			fn()
+		}
+	}
+}
+
+// setupGroups loads the instrument groups of the broker and reloads them on
+// SIGHUP.
+func setupGroups(ctx context.Context, srv Broker, path string) {
+	groups, err := ticker.LoadGroups(path)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load instrument groups")
+	}
+	srv.Topics().SetGroups(groups)
+	log.Info().Int("groups", len(groups)).Str("path", path).Msg("loaded instrument groups")
+
+	go onHangup(ctx, func() {
+		groups, err := ticker.LoadGroups(path)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to reload instrument groups")
+			return
+		}
+		srv.Update(func(t *ticker.Topics) { t.SetGroups(groups) })
+		log.Info().Int("groups", len(groups)).Str("path", path).Msg("reloaded instrument groups")
+	})
+}
+
+// setupMaster loads the instrument master of the broker and reloads it on
+// SIGHUP.
+func setupMaster(ctx context.Context, srv Broker, path string) {
+	master, err := ticker.LoadMaster(path)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load instrument master")
+	}
+	srv.Topics().Registry = master
+	registerAdmin(http.DefaultServeMux, master)
+	log.Info().Int("instruments", master.Len()).Str("path", path).Msg("loaded instrument master")
+
+	go onHangup(ctx, func() {
+		if err := master.Reload(); err != nil {
+			log.Error().Err(err).Msg("failed to reload instrument master")
+			return
+		}
+		log.Info().Int("instruments", master.Len()).Str("path", path).Msg("reloaded instrument master")
+	})
+}
+
+// sourceOptions select the tick source of the server and the path its ticks
+// are published through.
+type sourceOptions struct {
+	Type        string
+	Addr        string
+	File        string
+	Instruments int
+	TickRate    time.Duration
+	TradeCount  int
+
+	Upstream   string
+	FeedLayout string
+	FeedIface  string
+	Replay     ticker.Replay // Path is --source-file.
+	Sim        ticker.Simulator
+
+	Record       string
+	ClusterID    string
+	ClusterAddr  string
+	ClusterPeers map[string]string
+}
+
+func (so *sourceOptions) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&so.Type, "source", "synthetic", "Tick source (synthetic, sim, file, replay, stdin, tcp, udp, feed)")
+	fs.StringVar(&so.Addr, "source-addr", ":9090", "Listen address for tcp/udp tick sources or the feed group")
+	fs.StringVar(&so.File, "source-file", "", "File to read ticks from for the file and replay sources")
+	fs.IntVarP(&so.Instruments, "instruments", "i", 100, "Number of instruments to stream")
+	fs.DurationVarP(&so.TickRate, "tickrate", "t", 100*time.Millisecond, "Tick Rate")
+	fs.IntVarP(&so.TradeCount, "trade-count", "c", 5000, "Number of trades to generate per tick")
+
+	fs.StringVar(&so.Upstream, "upstream", "", "Run as an edge node relaying ticks from this ticktock server (e.g., ws://core:8080)")
+	fs.StringVar(&so.FeedLayout, "feed-layout", "", "Packet layout of the feed source (see ticker.ParseFeedLayout)")
+	fs.StringVar(&so.FeedIface, "feed-iface", "", "Network interface to join the multicast feed on")
+	fs.Float64Var(&so.Replay.Speed, "replay-speed", 1, "Replay speed multiplier (0 replays as fast as possible)")
+	fs.BoolVar(&so.Replay.Loop, "replay-loop", false, "Restart the replay once the capture file is exhausted")
+	fs.Int32SliceVar(&so.Replay.Instruments, "replay-instruments", nil, "Only replay ticks for these instruments")
+	fs.Float64Var(&so.Sim.Skew, "sim-skew", 1.1, "Zipf exponent of the simulated instrument activity")
+	fs.Float64Var(&so.Sim.Volatility, "sim-volatility", 0.0005, "Standard deviation of the relative price change per trade")
+	fs.DurationSliceVar(&so.Sim.Opens, "sim-open", nil, "Market open events as offsets from the start of the simulation")
+	fs.Float64Var(&so.Sim.Burst, "sim-burst", 5, "Peak activity multiplier around market open events")
+	fs.DurationVar(&so.Sim.BurstWidth, "sim-burst-width", 10*time.Second, "Width of the activity burst around market open events")
+
+	fs.StringVar(&so.Record, "record", "", "Append every published batch to this capture file")
+	fs.StringVar(&so.ClusterID, "cluster-id", "", "Run as a cluster node with this id")
+	fs.StringVar(&so.ClusterAddr, "cluster-addr", ":7070", "Address to accept cluster peers on")
+	fs.StringToStringVar(&so.ClusterPeers, "cluster-peers", nil, "Other cluster nodes as id=addr pairs")
+}
+
+// name returns the name of the source for the logs.
+func (so *sourceOptions) name() string {
+	if so.Upstream != "" {
+		return "upstream"
+	}
+	return so.Type
+}
+
+// setupSource returns the tick source selected by the options along with
+// the publisher it publishes to: pub behind the capture recorder and the
+// cluster node, if enabled. cleanup must be called once the source exited.
+func setupSource(ctx context.Context, srv Broker, pub ticker.Publisher, opts sourceOptions) (ts ticker.Source, publisher ticker.Publisher, cleanup func()) {
+	cleanup = func() {}
+	if opts.Record != "" {
+		rec, err := ticker.NewRecorder(opts.Record, pub)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to open capture file")
+		}
+		cleanup = func() { _ = rec.Close() }
+		pub = rec
+		log.Info().Str("path", opts.Record).Msg("recording published ticks")
+	}
+
+	if opts.ClusterID != "" {
+		if opts.Upstream != "" {
+			log.Fatal().Msg("--cluster-id and --upstream are mutually exclusive")
+		}
+
+		node := cluster.New(opts.ClusterID, opts.ClusterAddr, opts.ClusterPeers, pub)
+		srv.Topics().OnDemand = node.Demand
+		pub = node
+
+		go func() {
+			if err := node.Run(ctx); err != nil {
+				log.Fatal().Err(err).Msg("cluster node exited")
+			}
+		}()
+	}
+
+	if opts.Upstream != "" {
+		rl := relay.New(opts.Upstream)
+		srv.Topics().OnDemand = rl.Demand
+		expvar.Publish("relay", expvar.Func(func() any { return rl.Stats() }))
+		return rl, pub, cleanup
+	}
+
+	switch opts.Type {
+
+	case "synthetic":
+		// publishes random data to the broker.
+		ts = &ticker.Ticker{
+			TickRate:   opts.TickRate,
+			TradeCount: opts.TradeCount,
+		}
+
+	case "sim":
+		sim := opts.Sim
+		sim.Instruments = opts.Instruments
+		sim.TickRate = opts.TickRate
+		sim.TradeCount = opts.TradeCount
+		ts = &sim
+
+	case "file":
+		if opts.File == "" {
+			log.Fatal().Msg("--source-file is required for the file source")
+		}
+		ts = &ticker.File{Path: opts.File, Interval: opts.TickRate}
+
+	case "replay":
+		if opts.File == "" {
+			log.Fatal().Msg("--source-file is required for the replay source")
+		}
+		replay := opts.Replay
+		replay.Path = opts.File
+		ts = &replay
+
+	case "feed":
+		layout, err := ticker.ParseFeedLayout(opts.FeedLayout)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid feed layout")
+		}
+		feed := &ticker.Feed{Addr: opts.Addr, Interface: opts.FeedIface, Layout: layout}
+		expvar.Publish("feed", expvar.Func(func() any { return feed.Stats() }))
+		ts = feed
+
+	case "stdin":
+		ts = &ticker.Reader{R: os.Stdin}
+
+	case "tcp", "udp":
+		ts = &ticker.Listener{Network: opts.Type, Addr: opts.Addr}
+
+	default:
+		log.Fatal().Str("source", opts.Type).Msg("unknown source type")
 	}
+	return ts, pub, cleanup
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spy16/ticktock/cluster"
+	"github.com/spy16/ticktock/relay"
+	"github.com/spy16/ticktock/ticker"
+)
+
+func TestSetupSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   sourceOptions
+		source any // a value of the type of the source.
+		pub    any // a value of the type of the publisher.
+		check  func(t *testing.T, ts ticker.Source)
+	}{
+		{name: "Synthetic", opts: sourceOptions{Type: "synthetic"}, source: &ticker.Ticker{}},
+		{
+			name:   "Sim",
+			opts:   sourceOptions{Type: "sim", Instruments: 7, Sim: ticker.Simulator{Skew: 2}},
+			source: &ticker.Simulator{},
+			check: func(t *testing.T, ts ticker.Source) {
+				if sim := ts.(*ticker.Simulator); sim.Instruments != 7 || sim.Skew != 2 {
+					t.Errorf("simulator of %d instruments and skew %g, want 7 and 2", sim.Instruments, sim.Skew)
+				}
+			},
+		},
+		{name: "File", opts: sourceOptions{Type: "file", File: "ticks.csv"}, source: &ticker.File{}},
+		{
+			name:   "Replay",
+			opts:   sourceOptions{Type: "replay", File: "ticks.cap", Replay: ticker.Replay{Speed: 2}},
+			source: &ticker.Replay{},
+			check: func(t *testing.T, ts ticker.Source) {
+				if rp := ts.(*ticker.Replay); rp.Path != "ticks.cap" || rp.Speed != 2 {
+					t.Errorf("replay of '%s' at %gx, want ticks.cap at 2x", rp.Path, rp.Speed)
+				}
+			},
+		},
+		{name: "Feed", opts: sourceOptions{Type: "feed", Addr: "127.0.0.1:0"}, source: &ticker.Feed{}},
+		{name: "Stdin", opts: sourceOptions{Type: "stdin"}, source: &ticker.Reader{}},
+		{name: "TCP", opts: sourceOptions{Type: "tcp", Addr: "127.0.0.1:0"}, source: &ticker.Listener{}},
+		{name: "Upstream", opts: sourceOptions{Type: "synthetic", Upstream: "ws://core:8080"}, source: &relay.Relay{}},
+		{
+			name:   "Record",
+			opts:   sourceOptions{Type: "synthetic", Record: "ticks.cap"},
+			source: &ticker.Ticker{},
+			pub:    &ticker.Recorder{},
+		},
+		{
+			name:   "Cluster",
+			opts:   sourceOptions{Type: "synthetic", ClusterID: "a", ClusterAddr: "127.0.0.1:0"},
+			source: &ticker.Ticker{},
+			pub:    &cluster.Node{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if tt.opts.Record != "" {
+				tt.opts.Record = filepath.Join(t.TempDir(), tt.opts.Record)
+			}
+			srv := setupBroker(ctx, "gorillav1", "", brokerOptions{})
+			seq := ticker.NewSequencer(srv, 0)
+
+			ts, pub, cleanup := setupSource(ctx, srv, seq, tt.opts)
+			defer cleanup()
+
+			if got, want := reflect.TypeOf(ts), reflect.TypeOf(tt.source); got != want {
+				t.Fatalf("source of type %v, want %v", got, want)
+			}
+			want := reflect.TypeOf(seq)
+			if tt.pub != nil {
+				want = reflect.TypeOf(tt.pub)
+			}
+			if got := reflect.TypeOf(pub); got != want {
+				t.Fatalf("publisher of type %v, want %v", got, want)
+			}
+			if tt.check != nil {
+				tt.check(t, ts)
+			}
+		})
+	}
+}
+
+func TestSetupSourceRecordCleanup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ticks.cap")
+	srv := setupBroker(context.Background(), "gorillav1", "", brokerOptions{})
+
+	_, pub, cleanup := setupSource(context.Background(), srv, ticker.NewSequencer(srv, 0), sourceOptions{Type: "synthetic", Record: path})
+	opts := ticker.PublishOptions{Mode: ticker.PublishPartial, Timeout: time.Millisecond}
+	if _, err := pub.Publish(opts, []ticker.Tick{{Instrument: 1, Data: []byte("tick")}}); err != nil {
+		t.Fatal(err)
+	}
+	cleanup()
+
+	// the capture file is complete once cleaned up.
+	var got []int32
+	rp := &ticker.Replay{Path: path}
+	err := rp.Run(context.Background(), publishFunc(func(_ ticker.PublishOptions, ticks []ticker.Tick) (int, error) {
+		for _, tick := range ticks {
+			got = append(got, tick.Instrument)
+		}
+		return len(ticks), nil
+	}))
+	if err != nil || !reflect.DeepEqual(got, []int32{1}) {
+		t.Fatalf("replayed %v: %v, want [1]", got, err)
+	}
+}
+
+// publishFunc is a publisher calling the function.
+type publishFunc func(opts ticker.PublishOptions, ticks []ticker.Tick) (int, error)
+
+func (fn publishFunc) Publish(opts ticker.PublishOptions, ticks []ticker.Tick) (int, error) {
+	return fn(opts, ticks)
+}
@@ -0,0 +1,165 @@
+package ticker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// captureMagic is written at the beginning of every capture file.
+var captureMagic = []byte("TTCAP1\n")
+
+// maxCaptureBatch bounds the batch and tick sizes accepted while reading
+// capture data, to avoid huge allocations from corrupt input.
+const maxCaptureBatch = 1 << 24
+
+// ErrBadCapture is returned when the capture data is not in the expected
+// format.
+var ErrBadCapture = errors.New("bad capture data")
+
+// WriteBatch encodes a batch of ticks with the given timestamp to w. Each
+// batch is encoded as `ts(8) count(4)` followed by `instrument(4) len(4)
+// data(len)` for every tick. All integers are big-endian.
+func WriteBatch(w io.Writer, at time.Time, ticks []Tick) error {
+	size := 12
+	for _, tick := range ticks {
+		size += 8 + len(tick.Data)
+	}
+
+	b := make([]byte, 0, size)
+	b = binary.BigEndian.AppendUint64(b, uint64(at.UnixNano()))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(ticks)))
+	for _, tick := range ticks {
+		b = binary.BigEndian.AppendUint32(b, uint32(tick.Instrument))
+		b = binary.BigEndian.AppendUint32(b, uint32(len(tick.Data)))
+		b = append(b, tick.Data...)
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+// ReadBatch decodes a single batch written by WriteBatch. io.EOF is returned
+// when no more batches are available.
+func ReadBatch(r io.Reader) (time.Time, []Tick, error) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return time.Time{}, nil, ErrBadCapture
+		}
+		return time.Time{}, nil, err
+	}
+
+	at := time.Unix(0, int64(binary.BigEndian.Uint64(hdr[0:8])))
+	count := binary.BigEndian.Uint32(hdr[8:12])
+	if count > maxCaptureBatch {
+		return time.Time{}, nil, fmt.Errorf("%w: batch of %d ticks", ErrBadCapture, count)
+	}
+
+	ticks := make([]Tick, count)
+	for i := range ticks {
+		var th [8]byte
+		if _, err := io.ReadFull(r, th[:]); err != nil {
+			return time.Time{}, nil, ErrBadCapture
+		}
+
+		size := binary.BigEndian.Uint32(th[4:8])
+		if size > maxCaptureBatch {
+			return time.Time{}, nil, fmt.Errorf("%w: tick of %d bytes", ErrBadCapture, size)
+		}
+
+		ticks[i].Instrument = int32(binary.BigEndian.Uint32(th[0:4]))
+		ticks[i].Data = make([]byte, size)
+		if _, err := io.ReadFull(r, ticks[i].Data); err != nil {
+			return time.Time{}, nil, ErrBadCapture
+		}
+	}
+
+	return at, ticks, nil
+}
+
+// Recorder is a publisher that appends the ticks the underlying publisher
+// accepts to a capture file.
+type Recorder struct {
+	Publisher
+
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+// NewRecorder opens (or creates) the capture file at path for appending and
+// returns a recorder wrapping pub.
+func NewRecorder(path string, pub Publisher) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	if fi.Size() == 0 {
+		if _, err := f.Write(captureMagic); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	} else {
+		magic := make([]byte, len(captureMagic))
+		if _, err := f.ReadAt(magic, 0); err != nil || !bytes.Equal(magic, captureMagic) {
+			_ = f.Close()
+			return nil, fmt.Errorf("%w: '%s' is not a capture file", ErrBadCapture, path)
+		}
+	}
+
+	return &Recorder{
+		Publisher: pub,
+		f:         f,
+		w:         bufio.NewWriterSize(f, 64*1024),
+	}, nil
+}
+
+// Publish publishes the ticks to the underlying publisher and records the
+// ones it accepted, so that retries and rejected ticks are not recorded.
+func (rec *Recorder) Publish(opts PublishOptions, ticks []Tick) (int, error) {
+	n, err := rec.Publisher.Publish(opts, ticks)
+	if n == 0 {
+		return 0, err
+	}
+
+	if rerr := rec.record(time.Now(), AcceptedTicks(opts, ticks, n)); rerr != nil {
+		return n, errors.Join(err, fmt.Errorf("failed to record: %w", rerr))
+	}
+	return n, err
+}
+
+// Close flushes pending batches and closes the capture file.
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if err := rec.w.Flush(); err != nil {
+		_ = rec.f.Close()
+		return err
+	}
+	return rec.f.Close()
+}
+
+func (rec *Recorder) record(at time.Time, ticks []Tick) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if err := WriteBatch(rec.w, at, ticks); err != nil {
+		return err
+	}
+	return rec.w.Flush()
+}
@@ -0,0 +1,136 @@
+package ticker
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// queuePublisher publishes to a queue, like the brokers do.
+type queuePublisher struct{ *Queue }
+
+func (qp queuePublisher) Publish(opts PublishOptions, ticks []Tick) (int, error) {
+	return qp.Push(opts, ticks)
+}
+
+func TestRecorder(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		mode     PublishMode
+		retry    bool // publish the rest of the batch again, like publishBlocking.
+		want     []int32
+	}{
+		{"Block", 8, PublishBlock, false, []int32{1, 2, 3, 4, 5}},
+		{"BlockRejected", 3, PublishBlock, false, nil},
+		{"Partial", 3, PublishPartial, false, []int32{1, 2, 3}},
+		{"PartialRetry", 3, PublishPartial, true, []int32{1, 2, 3}},
+		{"ShedOldest", 3, PublishShedOldest, false, []int32{3, 4, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "capture")
+			rec, err := NewRecorder(path, queuePublisher{NewQueue(tt.capacity)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ticks := make([]Tick, 5)
+			for i := range ticks {
+				ticks[i] = Tick{Instrument: int32(i + 1), Data: []byte{byte(i + 1)}}
+			}
+
+			opts := PublishOptions{Mode: tt.mode, Timeout: time.Millisecond}
+			n, err := rec.Publish(opts, ticks)
+			if err != nil && !errors.Is(err, ErrTimeout) && !errors.Is(err, ErrBatchTooLarge) {
+				t.Fatalf("Publish() error = %v", err)
+			}
+			if tt.retry {
+				// the queue is still full, so the retry is rejected.
+				if n, err := rec.Publish(opts, ticks[n:]); n != 0 || !errors.Is(err, ErrTimeout) {
+					t.Fatalf("Publish() = %d, %v, want 0, ErrTimeout", n, err)
+				}
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readCapture(t, path); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("recorded %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteReadBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	at := time.Unix(0, 1700000000123456789)
+	ticks := []Tick{{Instrument: 7, Data: []byte("abc")}, {Instrument: -1, Data: []byte{}}}
+	if err := WriteBatch(f, at, ticks); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	gotAt, got, err := ReadBatch(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotAt.Equal(at) || !reflect.DeepEqual(got, ticks) {
+		t.Errorf("ReadBatch() = %v, %v, want %v, %v", gotAt, got, at, ticks)
+	}
+	if _, _, err := ReadBatch(f); !errors.Is(err, io.EOF) {
+		t.Errorf("ReadBatch() error = %v, want io.EOF", err)
+	}
+
+	// a truncated batch is not mistaken for the end of the capture.
+	if _, _, err := ReadBatch(io.LimitReader(mustOpen(t, path), 14)); !errors.Is(err, ErrBadCapture) {
+		t.Errorf("ReadBatch() error = %v, want ErrBadCapture", err)
+	}
+}
+
+// readCapture returns the instruments of the ticks recorded at path.
+func readCapture(t *testing.T, path string) []int32 {
+	t.Helper()
+
+	f := mustOpen(t, path)
+	if _, err := f.Seek(int64(len(captureMagic)), io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	var instrs []int32
+	for {
+		_, ticks, err := ReadBatch(f)
+		if errors.Is(err, io.EOF) {
+			return instrs
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		for _, tick := range ticks {
+			instrs = append(instrs, tick.Instrument)
+		}
+	}
+}
+
+func mustOpen(t *testing.T, path string) *os.File {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
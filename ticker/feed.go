@@ -0,0 +1,359 @@
+package ticker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultFeedLayout is the layout used by the bundled feed generator. The
+// header carries a sequence number (8) and a record count (2), followed by
+// records of instrument (4), price (8) and volume (8).
+var DefaultFeedLayout = FeedLayout{
+	ByteOrder:  binary.BigEndian,
+	HeaderSize: 10,
+	Seq:        Field{Offset: 0, Size: 8},
+	Count:      Field{Offset: 8, Size: 2},
+	RecordSize: 20,
+	Instrument: Field{Offset: 0, Size: 4},
+	Price:      Field{Offset: 4, Size: 8},
+	Volume:     Field{Offset: 12, Size: 8},
+}
+
+// FeedLayout describes the binary layout of the packets of a feed. Every
+// packet has a fixed size header followed by fixed size records, one per
+// tick. Offsets of header fields are relative to the packet and offsets of
+// record fields are relative to the record. Fields with zero size are not
+// present in the packet.
+type FeedLayout struct {
+	ByteOrder binary.ByteOrder
+
+	HeaderSize int
+	Seq        Field
+	Count      Field // if absent, the count is derived from the packet size.
+
+	RecordSize int
+	Instrument Field
+	Price      Field
+	Volume     Field
+	Timestamp  Field // unix micro. If absent, the receive time is used.
+}
+
+// Field is an unsigned integer field of 1, 2, 4 or 8 bytes in a packet.
+type Field struct {
+	Offset int
+	Size   int
+}
+
+// ParseFeedLayout parses a layout specification of the form
+// `key=offset[:size],...` with the keys header, seq, count, record,
+// instrument, price, volume and timestamp, plus `order=big|little`. The
+// header and record keys take only a size. Keys that are not specified are
+// taken from the DefaultFeedLayout; a size of 0 removes a field.
+func ParseFeedLayout(spec string) (FeedLayout, error) {
+	fl := DefaultFeedLayout
+	if strings.TrimSpace(spec) == "" {
+		return fl, nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return fl, fmt.Errorf("invalid layout entry '%s'", part)
+		}
+
+		var err error
+		switch key {
+		case "order":
+			switch val {
+			case "big":
+				fl.ByteOrder = binary.BigEndian
+			case "little":
+				fl.ByteOrder = binary.LittleEndian
+			default:
+				err = fmt.Errorf("invalid byte order '%s'", val)
+			}
+		case "header":
+			fl.HeaderSize, err = strconv.Atoi(val)
+		case "record":
+			fl.RecordSize, err = strconv.Atoi(val)
+		case "seq":
+			fl.Seq, err = parseField(val)
+		case "count":
+			fl.Count, err = parseField(val)
+		case "instrument":
+			fl.Instrument, err = parseField(val)
+		case "price":
+			fl.Price, err = parseField(val)
+		case "volume":
+			fl.Volume, err = parseField(val)
+		case "timestamp":
+			fl.Timestamp, err = parseField(val)
+		default:
+			err = fmt.Errorf("unknown layout key '%s'", key)
+		}
+		if err != nil {
+			return fl, err
+		}
+	}
+
+	return fl, fl.validate()
+}
+
+func parseField(s string) (Field, error) {
+	off, size, hasSize := strings.Cut(s, ":")
+
+	var f Field
+	var err error
+	if f.Offset, err = strconv.Atoi(off); err != nil {
+		return f, err
+	}
+
+	f.Size = 4
+	if hasSize {
+		if f.Size, err = strconv.Atoi(size); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+func (fl FeedLayout) validate() error {
+	if fl.HeaderSize < 0 {
+		return errors.New("header size must not be negative")
+	} else if fl.RecordSize <= 0 {
+		return errors.New("record size must be positive")
+	} else if fl.Instrument.Size == 0 || fl.Price.Size == 0 {
+		return errors.New("instrument and price fields are required")
+	}
+
+	check := func(name string, f Field, limit int) error {
+		switch f.Size {
+		case 0:
+			return nil
+		case 1, 2, 4, 8:
+		default:
+			return fmt.Errorf("%s: invalid size %d", name, f.Size)
+		}
+		if f.Offset < 0 || f.Offset+f.Size > limit {
+			return fmt.Errorf("%s: field out of bounds", name)
+		}
+		return nil
+	}
+
+	return errors.Join(
+		check("seq", fl.Seq, fl.HeaderSize),
+		check("count", fl.Count, fl.HeaderSize),
+		check("instrument", fl.Instrument, fl.RecordSize),
+		check("price", fl.Price, fl.RecordSize),
+		check("volume", fl.Volume, fl.RecordSize),
+		check("timestamp", fl.Timestamp, fl.RecordSize),
+	)
+}
+
+func (fl FeedLayout) get(b []byte, f Field) uint64 {
+	b = b[f.Offset : f.Offset+f.Size]
+	switch f.Size {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(fl.ByteOrder.Uint16(b))
+	case 4:
+		return uint64(fl.ByteOrder.Uint32(b))
+	case 8:
+		return fl.ByteOrder.Uint64(b)
+	default:
+		return 0
+	}
+}
+
+func (fl FeedLayout) put(b []byte, f Field, v uint64) {
+	b = b[f.Offset : f.Offset+f.Size]
+	switch f.Size {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		fl.ByteOrder.PutUint16(b, uint16(v))
+	case 4:
+		fl.ByteOrder.PutUint32(b, uint32(v))
+	case 8:
+		fl.ByteOrder.PutUint64(b, v)
+	}
+}
+
+// Decode decodes a packet into its sequence number and quotes. Quotes
+// without a timestamp field are stamped with the given time.
+func (fl FeedLayout) Decode(b []byte, now time.Time) (uint64, []Quote, error) {
+	if len(b) < fl.HeaderSize {
+		return 0, nil, ErrBadPacket
+	}
+
+	seq := fl.get(b, fl.Seq)
+	records := uint64((len(b) - fl.HeaderSize) / fl.RecordSize)
+	count := records
+	if fl.Count.Size > 0 {
+		// compared unsigned, since a count of 8 bytes overflows an int.
+		if count = fl.get(b, fl.Count); count > records {
+			return seq, nil, ErrBadPacket
+		}
+	}
+
+	quotes := make([]Quote, count)
+	for i := range quotes {
+		rec := b[fl.HeaderSize+i*fl.RecordSize:]
+		q := &quotes[i]
+		q.Instrument = int32(fl.get(rec, fl.Instrument))
+		q.LastPrice = int64(fl.get(rec, fl.Price))
+		q.Volume = int64(fl.get(rec, fl.Volume))
+		if fl.Timestamp.Size > 0 {
+			q.Timestamp = int64(fl.get(rec, fl.Timestamp))
+		} else {
+			q.Timestamp = now.UnixMicro()
+		}
+	}
+	return seq, quotes, nil
+}
+
+// Encode encodes quotes into a packet with the given sequence number.
+func (fl FeedLayout) Encode(seq uint64, quotes []Quote) []byte {
+	b := make([]byte, fl.HeaderSize+len(quotes)*fl.RecordSize)
+	fl.put(b, fl.Seq, seq)
+	fl.put(b, fl.Count, uint64(len(quotes)))
+	for i, q := range quotes {
+		rec := b[fl.HeaderSize+i*fl.RecordSize:]
+		fl.put(rec, fl.Instrument, uint64(q.Instrument))
+		fl.put(rec, fl.Price, uint64(q.LastPrice))
+		fl.put(rec, fl.Volume, uint64(q.Volume))
+		fl.put(rec, fl.Timestamp, uint64(q.Timestamp))
+	}
+	return b
+}
+
+// FeedStats are the statistics of a feed.
+type FeedStats struct {
+	Packets    uint64 `json:"packets"`
+	Ticks      uint64 `json:"ticks"`
+	Malformed  uint64 `json:"malformed"`
+	Gaps       uint64 `json:"gaps"`         // number of detected gaps.
+	Missed     uint64 `json:"missed"`       // number of packets missing in gaps.
+	OutOfOrder uint64 `json:"out_of_order"` // late or duplicate packets (dropped).
+	LastSeq    uint64 `json:"last_seq"`
+}
+
+// Feed is a source that ingests a UDP feed. If Addr is a multicast group
+// address, the group is joined on the given interface (or the system
+// default). Otherwise, Feed listens for unicast datagrams on Addr, which is
+// useful for testing. Since a feed cannot be slowed down, the oldest queued
+// ticks are shed when the broker queue is full.
+type Feed struct {
+	Addr      string
+	Interface string
+	Layout    FeedLayout
+
+	packets, ticks, malformed atomic.Uint64
+	gaps, missed, outOfOrder  atomic.Uint64
+	lastSeq                   atomic.Uint64
+}
+
+// Stats returns a snapshot of the feed statistics.
+func (fd *Feed) Stats() FeedStats {
+	return FeedStats{
+		Packets:    fd.packets.Load(),
+		Ticks:      fd.ticks.Load(),
+		Malformed:  fd.malformed.Load(),
+		Gaps:       fd.gaps.Load(),
+		Missed:     fd.missed.Load(),
+		OutOfOrder: fd.outOfOrder.Load(),
+		LastSeq:    fd.lastSeq.Load(),
+	}
+}
+
+// Run joins the feed and publishes the decoded ticks until the context is
+// cancelled.
+func (fd *Feed) Run(ctx context.Context, pub Publisher) error {
+	if err := fd.Layout.validate(); err != nil {
+		return fmt.Errorf("invalid feed layout: %w", err)
+	}
+
+	conn, err := fd.listen()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	_ = conn.SetReadBuffer(4 << 20)
+
+	var expected uint64
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		fd.packets.Add(1)
+
+		seq, quotes, err := fd.Layout.Decode(buf[:n], time.Now())
+		if err != nil {
+			fd.malformed.Add(1)
+			continue
+		}
+
+		if fd.Layout.Seq.Size > 0 {
+			if expected != 0 && seq == 1 && expected > 2 {
+				log.Warn().Uint64("expected", expected).Msg("feed sequence reset")
+			} else if expected != 0 && seq < expected {
+				fd.outOfOrder.Add(1)
+				continue
+			} else if expected != 0 && seq > expected {
+				fd.gaps.Add(1)
+				fd.missed.Add(seq - expected)
+				log.Warn().Uint64("expected", expected).Uint64("got", seq).Msg("feed sequence gap")
+			}
+			expected = seq + 1
+			fd.lastSeq.Store(seq)
+		}
+
+		ticks := make([]Tick, len(quotes))
+		for i := range quotes {
+			ticks[i] = quotes[i].Tick()
+		}
+		fd.ticks.Add(uint64(len(ticks)))
+		publish(pub, PublishShedOldest, ticks)
+	}
+}
+
+func (fd *Feed) listen() (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", fd.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if addr.IP == nil || !addr.IP.IsMulticast() {
+		log.Info().Str("addr", fd.Addr).Msg("listening for unicast feed")
+		return net.ListenUDP("udp", addr)
+	}
+
+	var ifi *net.Interface
+	if fd.Interface != "" {
+		if ifi, err = net.InterfaceByName(fd.Interface); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Info().Str("group", fd.Addr).Str("iface", fd.Interface).Msg("joining multicast feed")
+	return net.ListenMulticastUDP("udp", ifi, addr)
+}
@@ -0,0 +1,262 @@
+package ticker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// publishFunc is a publisher calling the function.
+type publishFunc func(opts PublishOptions, ticks []Tick) (int, error)
+
+func (fn publishFunc) Publish(opts PublishOptions, ticks []Tick) (int, error) {
+	return fn(opts, ticks)
+}
+
+func TestParseFeedLayout(t *testing.T) {
+	// layout returns the default layout changed by fn.
+	layout := func(fn func(fl *FeedLayout)) FeedLayout {
+		fl := DefaultFeedLayout
+		fn(&fl)
+		return fl
+	}
+
+	tests := []struct {
+		name    string
+		spec    string
+		want    FeedLayout
+		wantErr string
+	}{
+		{name: "Empty", spec: " ", want: DefaultFeedLayout},
+		{
+			name: "Fields",
+			spec: "header=4, seq=0:4, count=0:0, record=16, price=4:8, volume=12",
+			want: layout(func(fl *FeedLayout) {
+				fl.HeaderSize, fl.Seq, fl.Count = 4, Field{0, 4}, Field{}
+				fl.RecordSize, fl.Price, fl.Volume = 16, Field{4, 8}, Field{12, 4}
+			}),
+		},
+		{
+			name: "Timestamp",
+			spec: "record=28,timestamp=20:8",
+			want: layout(func(fl *FeedLayout) { fl.RecordSize, fl.Timestamp = 28, Field{20, 8} }),
+		},
+		{
+			name: "LittleEndian",
+			spec: "order=little",
+			want: layout(func(fl *FeedLayout) { fl.ByteOrder = binary.LittleEndian }),
+		},
+		{name: "BadOrder", spec: "order=middle", wantErr: "invalid byte order"},
+		{name: "UnknownKey", spec: "bid=4:8", wantErr: "unknown layout key"},
+		{name: "NoValue", spec: "seq", wantErr: "invalid layout entry"},
+		{name: "BadOffset", spec: "price=x:8", wantErr: "invalid syntax"},
+		{name: "BadSize", spec: "price=4:3", wantErr: "price: invalid size 3"},
+		{name: "OutOfRecord", spec: "volume=16:8", wantErr: "volume: field out of bounds"},
+		{name: "OutOfHeader", spec: "header=8", wantErr: "count: field out of bounds"},
+		{name: "NoPrice", spec: "price=0:0", wantErr: "instrument and price fields are required"},
+		{name: "NoRecord", spec: "record=0", wantErr: "record size must be positive"},
+		{name: "NegativeHeader", spec: "header=-1,seq=0:0,count=0:0", wantErr: "header size must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFeedLayout(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseFeedLayout() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParseFeedLayout() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFeedLayoutDecode(t *testing.T) {
+	now := time.UnixMicro(1_700_000_000_000_000)
+	quotes := []Quote{
+		{Instrument: 1, LastPrice: 100, Volume: 10, Timestamp: 42},
+		{Instrument: 2, LastPrice: 200, Volume: 20, Timestamp: 43},
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		// stamped reports whether the quotes carry their timestamp rather
+		// than the receive time.
+		stamped bool
+	}{
+		{name: "Default"},
+		{name: "LittleEndian", spec: "order=little"},
+		{name: "NoCount", spec: "count=0:0"},
+		{name: "Timestamp", spec: "record=28,timestamp=20:8", stamped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fl, err := ParseFeedLayout(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			seq, got, err := fl.Decode(fl.Encode(7, quotes), now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := append([]Quote(nil), quotes...)
+			if !tt.stamped {
+				for i := range want {
+					want[i].Timestamp = now.UnixMicro()
+				}
+			}
+			if seq != 7 || !reflect.DeepEqual(got, want) {
+				t.Fatalf("Decode() = %d, %+v, want 7, %+v", seq, got, want)
+			}
+		})
+	}
+}
+
+func TestFeedLayoutDecodeMalformed(t *testing.T) {
+	wide, err := ParseFeedLayout("header=16,seq=0:8,count=8:8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// withCount returns a packet of the layout with two records and the
+	// given count.
+	withCount := func(fl FeedLayout, count uint64) []byte {
+		packet := fl.Encode(7, []Quote{{Instrument: 1}, {Instrument: 2}})
+		fl.put(packet, fl.Count, count)
+		return packet
+	}
+
+	fl := DefaultFeedLayout
+	packet := fl.Encode(7, []Quote{{Instrument: 1}, {Instrument: 2}})
+
+	tests := []struct {
+		name   string
+		layout FeedLayout
+		packet []byte
+	}{
+		{"ShortHeader", fl, packet[:fl.HeaderSize-1]},
+		{"MissingRecord", fl, packet[:len(packet)-fl.RecordSize]},
+		{"TruncatedRecord", fl, packet[:len(packet)-1]},
+		{"CountTooLarge", fl, withCount(fl, 3)},
+		{"CountOverflow", wide, withCount(wide, ^uint64(0))},
+		{"CountNegative", wide, withCount(wide, 1<<63)},
+		{"CountProductOverflow", wide, withCount(wide, 1<<62)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := tt.layout.Decode(tt.packet, time.Now()); !errors.Is(err, ErrBadPacket) {
+				t.Fatalf("Decode() error = %v, want %v", err, ErrBadPacket)
+			}
+		})
+	}
+}
+
+func TestFeedSequence(t *testing.T) {
+	tests := []struct {
+		name string
+		seqs []uint64 // 0 sends a malformed packet.
+		want FeedStats
+	}{
+		{"InOrder", []uint64{1, 2, 3}, FeedStats{Ticks: 3, LastSeq: 3}},
+		{"Gap", []uint64{1, 2, 5}, FeedStats{Ticks: 3, Gaps: 1, Missed: 2, LastSeq: 5}},
+		{"Late", []uint64{1, 3, 2}, FeedStats{Ticks: 2, Gaps: 1, Missed: 1, OutOfOrder: 1, LastSeq: 3}},
+		{"Duplicate", []uint64{1, 2, 2}, FeedStats{Ticks: 2, OutOfOrder: 1, LastSeq: 2}},
+		{"Reset", []uint64{1, 2, 3, 1}, FeedStats{Ticks: 4, LastSeq: 1}},
+		{"Malformed", []uint64{1, 0, 2}, FeedStats{Ticks: 2, Malformed: 1, LastSeq: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the feed listens on the address of a released socket.
+			probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := probe.LocalAddr().String()
+			probe.Close()
+
+			var published atomic.Uint64
+			fd := &Feed{Addr: addr, Layout: DefaultFeedLayout}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				done <- fd.Run(ctx, publishFunc(func(_ PublishOptions, ticks []Tick) (int, error) {
+					published.Add(uint64(len(ticks)))
+					return len(ticks), nil
+				}))
+			}()
+			defer func() {
+				cancel()
+				if err := <-done; err != nil {
+					t.Errorf("Run() = %v", err)
+				}
+			}()
+
+			// unconnected, so that packets sent before the feed listens don't
+			// fail the later writes.
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			dst := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: probe.LocalAddr().(*net.UDPAddr).Port}
+
+			// the feed may not be listening yet: the first packet is resent
+			// until it is received.
+			send := func(seq uint64) {
+				packet := []byte("bad")
+				if seq != 0 {
+					packet = fd.Layout.Encode(seq, []Quote{{Instrument: 1, LastPrice: 100}})
+				}
+				if _, err := conn.WriteTo(packet, dst); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for deadline := time.Now().Add(time.Second); fd.Stats().Packets == 0; {
+				if time.Now().After(deadline) {
+					t.Fatal("the feed received no packet")
+				}
+				send(tt.seqs[0])
+				time.Sleep(5 * time.Millisecond)
+			}
+			if fd.Stats().Packets != 1 {
+				t.Skip("the first packet was received more than once")
+			}
+			for _, seq := range tt.seqs[1:] {
+				send(seq)
+			}
+
+			want := tt.want
+			want.Packets = uint64(len(tt.seqs))
+			for deadline := time.Now().Add(time.Second); fd.Stats().Packets < want.Packets; {
+				if time.Now().After(deadline) {
+					t.Fatalf("the feed received %d of %d packets", fd.Stats().Packets, want.Packets)
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			if got := fd.Stats(); got != want {
+				t.Fatalf("Stats() = %+v, want %+v", got, want)
+			}
+			if published.Load() != want.Ticks {
+				t.Fatalf("published %d ticks, want %d", published.Load(), want.Ticks)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package ticker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Groups maps the names of instrument groups (e.g., the constituents of an
+// index) to their member instruments.
+type Groups map[string][]int32
+
+// LoadGroups reads the instrument groups from a JSON file mapping group
+// names to instrument tokens, e.g., `{"NIFTY50": [256265, 408065]}`.
+func LoadGroups(path string) (Groups, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups Groups
+	if err := json.Unmarshal(b, &groups); err != nil {
+		return nil, fmt.Errorf("invalid groups file '%s': %w", path, err)
+	}
+	return groups, nil
+}
+
+// members returns the member sets of the groups.
+func (g Groups) members() map[string]map[int32]struct{} {
+	members := make(map[string]map[int32]struct{}, len(g))
+	for name, instrs := range g {
+		set := make(map[int32]struct{}, len(instrs))
+		for _, instr := range instrs {
+			set[instr] = struct{}{}
+		}
+		members[name] = set
+	}
+	return members
+}
@@ -0,0 +1,104 @@
+package ticker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Listener is a source that accepts ticks over the network. With "tcp",
+// every accepted connection is a stream of newline-delimited ticks. With
+// "udp", every datagram carries one or more newline-delimited ticks. Since
+// datagrams cannot be slowed down, the oldest queued ticks are shed when the
+// broker queue is full.
+type Listener struct {
+	Network string
+	Addr    string
+}
+
+// Run starts the listener and blocks until the context is cancelled.
+func (ls *Listener) Run(ctx context.Context, pub Publisher) error {
+	switch ls.Network {
+	case "tcp":
+		return ls.runTCP(ctx, pub)
+
+	case "udp":
+		return ls.runUDP(ctx, pub)
+
+	default:
+		return fmt.Errorf("unsupported network '%s'", ls.Network)
+	}
+}
+
+func (ls *Listener) runTCP(ctx context.Context, pub Publisher) error {
+	l, err := net.Listen("tcp", ls.Addr)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("addr", l.Addr().String()).Msg("accepting ticks over tcp")
+
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+
+			stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+			defer stop()
+
+			rd := &Reader{R: conn}
+			if err := rd.Run(ctx, pub); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Warn().Err(err).Str("remote", conn.RemoteAddr().String()).Msg("tick stream failed")
+			}
+		}()
+	}
+}
+
+func (ls *Listener) runUDP(ctx context.Context, pub Publisher) error {
+	conn, err := net.ListenPacket("udp", ls.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	log.Info().Str("addr", conn.LocalAddr().String()).Msg("accepting ticks over udp")
+
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		var batch []Tick
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			ticks, err := ParseTicks(line)
+			if err != nil {
+				log.Warn().Err(err).Msg("ignoring invalid tick line")
+				continue
+			}
+			batch = append(batch, ticks...)
+		}
+		publish(pub, PublishShedOldest, batch)
+	}
+}
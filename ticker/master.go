@@ -0,0 +1,216 @@
+package ticker
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Instrument is an entry of the instrument master.
+type Instrument struct {
+	Token    int32   `json:"instrument_token"`
+	Symbol   string  `json:"tradingsymbol"`
+	Exchange string  `json:"exchange"`
+	Segment  string  `json:"segment"`
+	LotSize  int     `json:"lot_size"`
+	TickSize float64 `json:"tick_size"`
+}
+
+// Key returns the "EXCHANGE:SYMBOL" key the instrument is looked up by.
+func (in Instrument) Key() string {
+	return strings.ToUpper(in.Exchange + ":" + in.Symbol)
+}
+
+// LoadMaster loads the instrument master from a JSON (array of instruments)
+// or CSV file, based on the extension of the file. CSV files must have a
+// header row naming the columns like the JSON fields. Other columns are
+// ignored.
+func LoadMaster(path string) (*Master, error) {
+	m := &Master{path: path}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Master is the registry of known instruments. It is safe for concurrent
+// use and can be reloaded at runtime.
+type Master struct {
+	path string
+
+	mu          sync.RWMutex
+	instruments []Instrument // sorted by token.
+	byToken     map[int32]int
+	byKey       map[string]int32
+}
+
+// Reload reloads the instrument master from its file. The current master
+// is kept if the file is invalid.
+func (m *Master) Reload() error {
+	f, err := os.Open(m.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var instruments []Instrument
+	if strings.EqualFold(filepath.Ext(m.path), ".json") {
+		err = json.NewDecoder(f).Decode(&instruments)
+	} else {
+		instruments, err = readMasterCSV(f)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid instrument master '%s': %w", m.path, err)
+	}
+
+	sort.Slice(instruments, func(i, j int) bool {
+		return instruments[i].Token < instruments[j].Token
+	})
+
+	byToken := make(map[int32]int, len(instruments))
+	byKey := make(map[string]int32, len(instruments))
+	for i, in := range instruments {
+		byToken[in.Token] = i
+		byKey[in.Key()] = in.Token
+	}
+
+	m.mu.Lock()
+	m.instruments, m.byToken, m.byKey = instruments, byToken, byKey
+	m.mu.Unlock()
+	return nil
+}
+
+// Len returns the number of instruments in the master.
+func (m *Master) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.instruments)
+}
+
+// Get returns the instrument with the token.
+func (m *Master) Get(token int32) (Instrument, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i, found := m.byToken[token]
+	if !found {
+		return Instrument{}, false
+	}
+	return m.instruments[i], true
+}
+
+// Known reports whether the token is in the master.
+func (m *Master) Known(token int32) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, found := m.byToken[token]
+	return found
+}
+
+// Lookup returns the token of the instrument with the "EXCHANGE:SYMBOL" key
+// (e.g., "NSE:INFY"). Keys are case-insensitive.
+func (m *Master) Lookup(key string) (int32, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	token, found := m.byKey[strings.ToUpper(key)]
+	return token, found
+}
+
+// Search returns up to limit instruments whose trading symbol contains the
+// query (case-insensitive), optionally restricted to an exchange and a
+// segment. Instruments are returned in the order of their tokens.
+func (m *Master) Search(query, exchange, segment string, limit int) []Instrument {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	query = strings.ToUpper(query)
+	var found []Instrument
+	for _, in := range m.instruments {
+		if len(found) >= limit {
+			break
+		}
+
+		if exchange != "" && !strings.EqualFold(in.Exchange, exchange) {
+			continue
+		} else if segment != "" && !strings.EqualFold(in.Segment, segment) {
+			continue
+		} else if !strings.Contains(strings.ToUpper(in.Symbol), query) {
+			continue
+		}
+		found = append(found, in)
+	}
+	return found
+}
+
+func readMasterCSV(r io.Reader) ([]Instrument, error) {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"instrument_token", "tradingsymbol", "exchange"} {
+		if _, found := cols[name]; !found {
+			return nil, fmt.Errorf("missing column '%s'", name)
+		}
+	}
+
+	field := func(rec []string, name string) string {
+		if i, found := cols[name]; found && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	var instruments []Instrument
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return instruments, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		token, err := strconv.ParseInt(field(rec, "instrument_token"), 10, 32)
+		if err != nil {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %d: invalid instrument token: %w", line, err)
+		}
+
+		in := Instrument{
+			Token:    int32(token),
+			Symbol:   field(rec, "tradingsymbol"),
+			Exchange: field(rec, "exchange"),
+			Segment:  field(rec, "segment"),
+		}
+		if v := field(rec, "lot_size"); v != "" {
+			if in.LotSize, err = strconv.Atoi(v); err != nil {
+				line, _ := cr.FieldPos(0)
+				return nil, fmt.Errorf("line %d: invalid lot size: %w", line, err)
+			}
+		}
+		if v := field(rec, "tick_size"); v != "" {
+			if in.TickSize, err = strconv.ParseFloat(v, 64); err != nil {
+				line, _ := cr.FieldPos(0)
+				return nil, fmt.Errorf("line %d: invalid tick size: %w", line, err)
+			}
+		}
+		instruments = append(instruments, in)
+	}
+}
@@ -0,0 +1,257 @@
+package ticker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const masterCSV = `instrument_token,exchange_token,tradingsymbol,exchange,segment,lot_size,tick_size
+408065,1594,INFY,NSE,NSE,1,0.05
+2953217,11536,TCS,NSE,NSE,1,0.05
+128053508,500209,INFY,BSE,BSE,1,0.05
+12517890,48898,NIFTY24JANFUT,NFO,NFO-FUT,50,0.05
+`
+
+var masterInstruments = []Instrument{
+	{Token: 408065, Symbol: "INFY", Exchange: "NSE", Segment: "NSE", LotSize: 1, TickSize: 0.05},
+	{Token: 2953217, Symbol: "TCS", Exchange: "NSE", Segment: "NSE", LotSize: 1, TickSize: 0.05},
+	{Token: 12517890, Symbol: "NIFTY24JANFUT", Exchange: "NFO", Segment: "NFO-FUT", LotSize: 50, TickSize: 0.05},
+	{Token: 128053508, Symbol: "INFY", Exchange: "BSE", Segment: "BSE", LotSize: 1, TickSize: 0.05},
+}
+
+// writeMaster writes the instrument master file with the name.
+func writeMaster(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadMaster(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []Instrument
+		err     string
+	}{
+		{name: "CSV", file: "master.csv", content: masterCSV, want: masterInstruments},
+		{
+			name: "JSON",
+			file: "master.JSON",
+			content: `[
+				{"instrument_token": 2953217, "tradingsymbol": "TCS", "exchange": "NSE", "segment": "NSE", "lot_size": 1, "tick_size": 0.05},
+				{"instrument_token": 408065, "tradingsymbol": "INFY", "exchange": "NSE"}
+			]`,
+			want: []Instrument{
+				{Token: 408065, Symbol: "INFY", Exchange: "NSE"},
+				{Token: 2953217, Symbol: "TCS", Exchange: "NSE", Segment: "NSE", LotSize: 1, TickSize: 0.05},
+			},
+		},
+		{
+			name:    "CSVOptionalColumns",
+			file:    "master.csv",
+			content: "exchange, tradingsymbol ,instrument_token\nNSE,INFY,408065\n",
+			want:    []Instrument{{Token: 408065, Symbol: "INFY", Exchange: "NSE"}},
+		},
+		{name: "Empty", file: "master.csv", content: "instrument_token,tradingsymbol,exchange\n"},
+		{name: "MissingColumn", file: "master.csv", content: "instrument_token,tradingsymbol\n1,A\n", err: "missing column 'exchange'"},
+		{name: "InvalidToken", file: "master.csv", content: "instrument_token,tradingsymbol,exchange\nx,A,NSE\n", err: "line 2: invalid instrument token"},
+		{name: "InvalidLotSize", file: "master.csv", content: "instrument_token,tradingsymbol,exchange,lot_size\n1,A,NSE,x\n", err: "line 2: invalid lot size"},
+		{name: "InvalidTickSize", file: "master.csv", content: "instrument_token,tradingsymbol,exchange,tick_size\n1,A,NSE,x\n", err: "line 2: invalid tick size"},
+		{name: "InvalidJSON", file: "master.json", content: `{"instrument_token": 1}`, err: "invalid instrument master"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := LoadMaster(writeMaster(t, tt.file, tt.content))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("LoadMaster() error = %v, want %q", err, tt.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if m.Len() != len(tt.want) {
+				t.Fatalf("Len() = %d, want %d", m.Len(), len(tt.want))
+			}
+			if got := m.Search("", "", "", len(tt.want)+1); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("instruments %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadMaster(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Fatal("LoadMaster() of a missing file succeeded")
+	}
+}
+
+func TestMasterLookup(t *testing.T) {
+	m, err := LoadMaster(writeMaster(t, "master.csv", masterCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key   string
+		token int32
+		found bool
+	}{
+		{"NSE:INFY", 408065, true},
+		{"bse:infy", 128053508, true},
+		{"NFO:NIFTY24JANFUT", 12517890, true},
+		{"INFY", 0, false},
+		{"NSE:WIPRO", 0, false},
+	}
+	for _, tt := range tests {
+		if token, found := m.Lookup(tt.key); token != tt.token || found != tt.found {
+			t.Errorf("Lookup(%q) = %d, %t, want %d, %t", tt.key, token, found, tt.token, tt.found)
+		}
+	}
+
+	if in, found := m.Get(2953217); !found || in != masterInstruments[1] {
+		t.Errorf("Get(2953217) = %+v, %t", in, found)
+	}
+	if _, found := m.Get(1); found || m.Known(1) {
+		t.Error("unknown token found")
+	}
+	if !m.Known(408065) {
+		t.Error("Known(408065) = false")
+	}
+}
+
+func TestMasterSearch(t *testing.T) {
+	m, err := LoadMaster(writeMaster(t, "master.csv", masterCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		exchange string
+		segment  string
+		limit    int
+		want     []int32
+	}{
+		{"Symbol", "infy", "", "", 10, []int32{408065, 128053508}},
+		{"Substring", "FUT", "", "", 10, []int32{12517890}},
+		{"Exchange", "INFY", "bse", "", 10, []int32{128053508}},
+		{"Segment", "", "", "NFO-FUT", 10, []int32{12517890}},
+		{"Limit", "", "", "", 2, []int32{408065, 2953217}},
+		{"NotFound", "WIPRO", "", "", 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int32
+			for _, in := range m.Search(tt.query, tt.exchange, tt.segment, tt.limit) {
+				got = append(got, in.Token)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Search() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMasterReload(t *testing.T) {
+	path := writeMaster(t, "master.csv", masterCSV)
+	m, err := LoadMaster(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an invalid file keeps the current master.
+	if err := os.WriteFile(path, []byte("instrument_token\n1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err == nil {
+		t.Fatal("Reload() of an invalid file succeeded")
+	}
+	if m.Len() != len(masterInstruments) {
+		t.Fatalf("Len() = %d after a failed reload, want %d", m.Len(), len(masterInstruments))
+	}
+
+	if err := os.WriteFile(path, []byte("instrument_token,tradingsymbol,exchange\n3045,SBIN,NSE\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if token, found := m.Lookup("NSE:SBIN"); m.Len() != 1 || !found || token != 3045 {
+		t.Fatalf("after a reload: Len() = %d, Lookup() = %d, %t", m.Len(), token, found)
+	}
+	if m.Known(408065) {
+		t.Fatal("removed instrument still known after a reload")
+	}
+}
+
+func TestTopicsRegistry(t *testing.T) {
+	m, err := LoadMaster(writeMaster(t, "master.csv", masterCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  Request
+		want Ack
+		subs map[int32]Mode
+	}{
+		{
+			name: "Known",
+			req:  Request{Mode: ModeLTP, Instruments: []int32{408065}},
+			want: Ack{Action: ActionAck, Mode: ModeLTP, Instruments: []int32{408065}},
+			subs: map[int32]Mode{408065: ModeLTP},
+		},
+		{
+			name: "Unknown",
+			req:  Request{Mode: ModeLTP, Instruments: []int32{408065, 1}},
+			want: Ack{Action: ActionAck, Mode: ModeLTP, Instruments: []int32{408065}, Rejected: []int32{1}},
+			subs: map[int32]Mode{408065: ModeLTP},
+		},
+		{
+			name: "Symbols",
+			req:  Request{Mode: ModeQuote, Instruments: []int32{2953217}, Symbols: []string{"nse:infy", "NSE:WIPRO"}},
+			want: Ack{Action: ActionAck, Mode: ModeQuote, Instruments: []int32{2953217, 408065}, RejectedSymbols: []string{"NSE:WIPRO"}},
+			subs: map[int32]Mode{2953217: ModeQuote, 408065: ModeQuote},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topics := NewTopics()
+			topics.Registry = m
+
+			sub := &testSub{}
+			topics.Apply(sub, tt.req)
+
+			var ack Ack
+			sub.lastReply(t, &ack)
+			if !reflect.DeepEqual(ack, tt.want) {
+				t.Fatalf("ack %+v, want %+v", ack, tt.want)
+			}
+			checkSubscriptions(t, topics, sub, tt.subs)
+		})
+	}
+
+	// without a registry, symbols cannot be resolved.
+	topics := NewTopics()
+	sub := &testSub{}
+	topics.Apply(sub, Request{Mode: ModeLTP, Symbols: []string{"NSE:INFY"}})
+
+	var ack Ack
+	sub.lastReply(t, &ack)
+	if want := (Ack{Action: ActionAck, Mode: ModeLTP, Instruments: []int32{}, RejectedSymbols: []string{"NSE:INFY"}}); !reflect.DeepEqual(ack, want) {
+		t.Fatalf("ack %+v without a registry, want %+v", ack, want)
+	}
+}
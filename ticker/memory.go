@@ -0,0 +1,168 @@
+package ticker
+
+import (
+	"container/heap"
+	"net"
+	"sort"
+	"unsafe"
+)
+
+// Estimates of the memory used by connections.
+const (
+	// QueueSlotSize is the size of a slot of a packet queue, i.e., of a
+	// slice header.
+	QueueSlotSize = int64(unsafe.Sizeof([]byte(nil)))
+
+	// StackSize is the estimated stack size of a connection goroutine.
+	StackSize = 8 << 10
+
+	// subscriptionSize is the estimated size of a subscription, in the
+	// index of the client and in the subscribers of the topic.
+	subscriptionSize = 64
+)
+
+// MemoryUser is a Subscriber that estimates the memory used by its
+// connection. The clients of the brokers implement it so that the memory of
+// the connections can be accounted for (see Topics.Memory).
+type MemoryUser interface {
+	Subscriber
+
+	// Memory returns the estimated memory used by the buffers, queues and
+	// goroutines of the connection, and the size of the packets queued for
+	// it. It must be safe for concurrent use.
+	Memory() (buffers, queued int64)
+
+	// Shed closes the connection to release its memory. The client is then
+	// removed as if it disconnected.
+	Shed()
+
+	// RemoteAddr returns the address of the client.
+	RemoteAddr() net.Addr
+}
+
+// ConnMemory is the estimated memory used by a connection.
+type ConnMemory struct {
+	Addr          string `json:"addr,omitempty"`
+	Buffers       int64  `json:"buffers"`
+	Queued        int64  `json:"queued"`
+	Subscriptions int64  `json:"subscriptions"`
+}
+
+// Total returns the total memory used by the connection.
+func (cm ConnMemory) Total() int64 {
+	return cm.Buffers + cm.Queued + cm.Subscriptions
+}
+
+// MemoryUsage is the estimated memory used by the connected clients, in
+// bytes.
+type MemoryUsage struct {
+	Connections   int          `json:"connections"`
+	Shedding      int          `json:"shedding"` // shed but not removed yet.
+	Total         int64        `json:"total"`
+	Buffers       int64        `json:"buffers"`
+	Queued        int64        `json:"queued"`
+	Subscriptions int64        `json:"subscriptions"`
+	Heaviest      []ConnMemory `json:"heaviest,omitempty"`
+}
+
+// Memory estimates the memory used by the connected clients, along with the
+// top heaviest ones. Only the clients implementing MemoryUser are accounted
+// for.
+func (t *Topics) Memory(top int) MemoryUsage {
+	var usage MemoryUsage
+	heaviest := &connHeap{}
+	for sub := range t.conns {
+		mu, ok := sub.(MemoryUser)
+		if !ok {
+			continue
+		}
+
+		cm := t.connMemory(mu)
+		usage.Connections++
+		usage.Buffers += cm.Buffers
+		usage.Queued += cm.Queued
+		usage.Subscriptions += cm.Subscriptions
+		if _, shed := t.shed[sub]; shed {
+			usage.Shedding++
+		}
+
+		if top > 0 {
+			heap.Push(heaviest, connUsage{ConnMemory: cm, sub: mu})
+			if heaviest.Len() > top {
+				heap.Pop(heaviest)
+			}
+		}
+	}
+	usage.Total = usage.Buffers + usage.Queued + usage.Subscriptions
+
+	for heaviest.Len() > 0 {
+		cu := heap.Pop(heaviest).(connUsage)
+		cu.Addr = cu.sub.RemoteAddr().String()
+		usage.Heaviest = append([]ConnMemory{cu.ConnMemory}, usage.Heaviest...)
+	}
+	return usage
+}
+
+// Shed sheds the heaviest clients until their estimated memory adds up to
+// excess, counting the clients shed earlier that are not removed yet. It
+// returns the number of clients shed.
+func (t *Topics) Shed(excess int64) int {
+	var candidates []connUsage
+	for sub := range t.conns {
+		mu, ok := sub.(MemoryUser)
+		if !ok {
+			continue
+		}
+
+		cm := t.connMemory(mu)
+		if _, shed := t.shed[sub]; shed {
+			excess -= cm.Total()
+			continue
+		}
+		candidates = append(candidates, connUsage{ConnMemory: cm, sub: mu})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Total() > candidates[j].Total()
+	})
+
+	n := 0
+	for _, cu := range candidates {
+		if excess <= 0 {
+			break
+		}
+		t.shed[cu.sub] = struct{}{}
+		cu.sub.Shed()
+		excess -= cu.Total()
+		n++
+	}
+	return n
+}
+
+func (t *Topics) connMemory(mu MemoryUser) ConnMemory {
+	cm := ConnMemory{}
+	cm.Buffers, cm.Queued = mu.Memory()
+	if c := t.clients[mu]; c != nil {
+		cm.Subscriptions = int64(len(c.instruments)+len(c.direct)+len(c.groups)) * subscriptionSize
+	}
+	return cm
+}
+
+type connUsage struct {
+	ConnMemory
+	sub MemoryUser
+}
+
+// connHeap is a min-heap of connections by their memory, used to keep the
+// heaviest ones.
+type connHeap []connUsage
+
+func (h connHeap) Len() int           { return len(h) }
+func (h connHeap) Less(i, j int) bool { return h[i].Total() < h[j].Total() }
+func (h connHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *connHeap) Push(x any)        { *h = append(*h, x.(connUsage)) }
+func (h *connHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
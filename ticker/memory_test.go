@@ -0,0 +1,95 @@
+package ticker
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+// memSub is a subscriber with a fixed memory estimate.
+type memSub struct {
+	testSub
+	port    int
+	buffers int64
+	queued  int64
+	shed    bool
+}
+
+func (s *memSub) Memory() (buffers, queued int64) { return s.buffers, s.queued }
+func (s *memSub) Shed()                           { s.shed = true }
+func (s *memSub) RemoteAddr() net.Addr            { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: s.port} }
+
+// connectMemSubs connects subscribers of the buffers (in KB), each
+// subscribed to one instrument.
+func connectMemSubs(topics *Topics, buffers ...int64) []*memSub {
+	var subs []*memSub
+	for i, kb := range buffers {
+		sub := &memSub{port: i + 1, buffers: kb << 10}
+		topics.Connect(sub, "")
+		topics.Apply(sub, Request{Mode: ModeLTP, Instruments: []int32{int32(i)}})
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+func TestTopicsMemory(t *testing.T) {
+	topics := NewTopics()
+	subs := connectMemSubs(topics, 1, 4, 2)
+	subs[0].queued = 100
+
+	// subscribers that do not estimate their memory are not accounted for.
+	plain := &testSub{}
+	topics.Connect(plain, "")
+	topics.Apply(plain, Request{Mode: ModeLTP, Instruments: []int32{1, 2}})
+
+	// a direct subscription is in both indexes of the client.
+	const subSize = 2 * subscriptionSize
+	usage := topics.Memory(2)
+	want := MemoryUsage{
+		Connections:   3,
+		Total:         7<<10 + 100 + 3*subSize,
+		Buffers:       7 << 10,
+		Queued:        100,
+		Subscriptions: 3 * subSize,
+		Heaviest: []ConnMemory{
+			{Addr: "127.0.0.1:2", Buffers: 4 << 10, Subscriptions: subSize},
+			{Addr: "127.0.0.1:3", Buffers: 2 << 10, Subscriptions: subSize},
+		},
+	}
+	if !reflect.DeepEqual(usage, want) {
+		t.Fatalf("Memory() = %+v, want %+v", usage, want)
+	}
+
+	topics.Remove(subs[1])
+	if usage := topics.Memory(0); usage.Connections != 2 || usage.Heaviest != nil {
+		t.Fatalf("Memory() = %+v after a removal, want 2 connections", usage)
+	}
+}
+
+func TestTopicsShed(t *testing.T) {
+	topics := NewTopics()
+	subs := connectMemSubs(topics, 1, 4, 2, 3)
+
+	// the heaviest clients are shed until the excess is covered.
+	if n := topics.Shed(5 << 10); n != 2 {
+		t.Fatalf("Shed() = %d, want 2", n)
+	}
+	for i, want := range []bool{false, true, false, true} {
+		if subs[i].shed != want {
+			t.Errorf("client %d shed = %t, want %t", i, subs[i].shed, want)
+		}
+	}
+	if usage := topics.Memory(0); usage.Shedding != 2 {
+		t.Fatalf("%d clients shedding, want 2", usage.Shedding)
+	}
+
+	// the clients shed earlier count towards the excess until removed.
+	if n := topics.Shed(7 << 10); n != 0 {
+		t.Fatalf("Shed() = %d with the excess already shed, want 0", n)
+	}
+	topics.Remove(subs[1])
+	topics.Remove(subs[3])
+	if n := topics.Shed(1); n != 1 || !subs[2].shed || subs[0].shed {
+		t.Fatalf("Shed() = %d after the removals, want the client of 2KB shed", n)
+	}
+}
@@ -0,0 +1,140 @@
+package ticker
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// DepthLevels is the number of price levels on each side of the market
+// depth.
+const DepthLevels = 5
+
+// Packet sizes for each mode. A packet for a mode is a prefix of the packet
+// of the next mode, which lets Tick.Compute slice a single encoded packet.
+const (
+	ltpPacketSize   = 8 + 4 + 8 + 8
+	quotePacketSize = ltpPacketSize + 7*8
+	fullPacketSize  = quotePacketSize + 2*DepthLevels*16
+)
+
+// seqOffset is the offset of the sequence number in a packet.
+const seqOffset = 12
+
+// ErrBadPacket is returned when a packet cannot be decoded.
+var ErrBadPacket = errors.New("bad packet")
+
+// Quote is the typed market data of an instrument. All prices are in the
+// smallest unit of the currency (e.g., paise).
+type Quote struct {
+	Timestamp  int64 // unix micro
+	Instrument int32
+	Seq        uint64 // per-instrument sequence number stamped by the Sequencer.
+	LastPrice  int64
+
+	Volume  int64
+	Open    int64
+	High    int64
+	Low     int64
+	Close   int64
+	BuyQty  int64
+	SellQty int64
+
+	Bids [DepthLevels]DepthLevel
+	Asks [DepthLevels]DepthLevel
+}
+
+// DepthLevel is a single price level of the market depth.
+type DepthLevel struct {
+	Price  int64
+	Qty    int32
+	Orders int32
+}
+
+// PacketSize returns the size of the packet sent for the given mode.
+func PacketSize(mode Mode) int {
+	switch mode {
+	case ModeLTP:
+		return ltpPacketSize
+	case ModeQuote:
+		return quotePacketSize
+	case ModeFull:
+		return fullPacketSize
+	default:
+		return 0
+	}
+}
+
+// Tick returns a tick carrying the full packet of the quote.
+func (q *Quote) Tick() Tick {
+	return Tick{Instrument: q.Instrument, Data: q.AppendPacket(nil, ModeFull)}
+}
+
+// AppendPacket appends the binary packet of the quote for the given mode to
+// b. The layout is:
+//
+//	LTP:   timestamp(8) instrument(4) seq(8) last_price(8)
+//	Quote: LTP + volume(8) open(8) high(8) low(8) close(8) buy_qty(8) sell_qty(8)
+//	Full:  Quote + 5 bids and 5 asks of price(8) qty(4) orders(4)
+//
+// All integers are big-endian.
+func (q *Quote) AppendPacket(b []byte, mode Mode) []byte {
+	b = binary.BigEndian.AppendUint64(b, uint64(q.Timestamp))
+	b = binary.BigEndian.AppendUint32(b, uint32(q.Instrument))
+	b = binary.BigEndian.AppendUint64(b, q.Seq)
+	b = binary.BigEndian.AppendUint64(b, uint64(q.LastPrice))
+	if mode < ModeQuote {
+		return b
+	}
+
+	for _, v := range []int64{q.Volume, q.Open, q.High, q.Low, q.Close, q.BuyQty, q.SellQty} {
+		b = binary.BigEndian.AppendUint64(b, uint64(v))
+	}
+	if mode < ModeFull {
+		return b
+	}
+
+	for _, side := range [][DepthLevels]DepthLevel{q.Bids, q.Asks} {
+		for _, lvl := range side {
+			b = binary.BigEndian.AppendUint64(b, uint64(lvl.Price))
+			b = binary.BigEndian.AppendUint32(b, uint32(lvl.Qty))
+			b = binary.BigEndian.AppendUint32(b, uint32(lvl.Orders))
+		}
+	}
+	return b
+}
+
+// ParseQuote decodes a packet produced by AppendPacket. The returned mode
+// indicates which fields of the quote are populated.
+func ParseQuote(b []byte) (Quote, Mode, error) {
+	var q Quote
+	if len(b) < ltpPacketSize {
+		return q, ModeNone, ErrBadPacket
+	}
+
+	q.Timestamp = int64(binary.BigEndian.Uint64(b[0:8]))
+	q.Instrument = int32(binary.BigEndian.Uint32(b[8:12]))
+	q.Seq = binary.BigEndian.Uint64(b[12:20])
+	q.LastPrice = int64(binary.BigEndian.Uint64(b[20:28]))
+	if len(b) < quotePacketSize {
+		return q, ModeLTP, nil
+	}
+
+	b = b[ltpPacketSize:]
+	for _, v := range []*int64{&q.Volume, &q.Open, &q.High, &q.Low, &q.Close, &q.BuyQty, &q.SellQty} {
+		*v = int64(binary.BigEndian.Uint64(b))
+		b = b[8:]
+	}
+	if len(b) < fullPacketSize-quotePacketSize {
+		return q, ModeQuote, nil
+	}
+
+	for _, side := range []*[DepthLevels]DepthLevel{&q.Bids, &q.Asks} {
+		for i := range side {
+			side[i].Price = int64(binary.BigEndian.Uint64(b[0:8]))
+			side[i].Qty = int32(binary.BigEndian.Uint32(b[8:12]))
+			side[i].Orders = int32(binary.BigEndian.Uint32(b[12:16]))
+			b = b[16:]
+		}
+	}
+	return q, ModeFull, nil
+}
@@ -0,0 +1,198 @@
+package ticker
+
+import (
+	"sync"
+	"time"
+)
+
+// NewQueue returns a tick queue that holds up to capacity ticks.
+func NewQueue(capacity int) *Queue {
+	capacity = max(capacity, 1)
+	return &Queue{
+		ticks: make([]Tick, capacity),
+		ready: make(chan struct{}, 1),
+		high:  capacity * 8 / 10,
+		low:   capacity / 2,
+	}
+}
+
+// Queue is a bounded FIFO of ticks between publishers and the broker. It
+// implements the publish modes on behalf of the brokers.
+type Queue struct {
+	mu        sync.Mutex
+	ticks     []Tick // ring buffer.
+	head      int
+	size      int
+	ready     chan struct{}
+	space     chan struct{} // closed when space is freed, if non-nil.
+	high, low int
+	congested bool
+	watermark WatermarkFunc
+}
+
+// WatermarkFunc is invoked when the depth of a queue rises to the high
+// watermark (high=true) and when it falls back to the low watermark
+// (high=false).
+type WatermarkFunc func(depth, capacity int, high bool)
+
+// OnWatermark registers fn to be notified when the queue depth crosses its
+// watermarks. It must be called before the queue is used.
+func (q *Queue) OnWatermark(fn WatermarkFunc) {
+	q.watermark = fn
+}
+
+// Len returns the number of ticks in the queue.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// Cap returns the capacity of the queue.
+func (q *Queue) Cap() int {
+	return len(q.ticks)
+}
+
+// Ready returns a channel that receives a value when ticks are available.
+func (q *Queue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Push enqueues the ticks according to the publish options and returns the
+// number of ticks accepted. ErrTimeout is returned if not all ticks were
+// accepted in time. In the block mode, a batch larger than the queue is
+// rejected with ErrBatchTooLarge. In the shed-oldest mode, Push never blocks
+// and only a batch larger than the queue is truncated (to its newest ticks).
+func (q *Queue) Push(opts PublishOptions, ticks []Tick) (int, error) {
+	if opts.Mode == PublishBlock && len(ticks) > len(q.ticks) {
+		return 0, ErrBatchTooLarge
+	}
+
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	accepted := 0
+	for {
+		q.mu.Lock()
+		free := len(q.ticks) - q.size
+		switch opts.Mode {
+		case PublishShedOldest:
+			if shed := len(ticks) - free; shed > 0 {
+				q.drop(min(shed, q.size))
+			}
+			ticks = ticks[max(0, len(ticks)-len(q.ticks)):]
+			accepted += q.push(ticks)
+			ticks = nil
+
+		case PublishPartial:
+			n := q.push(ticks[:min(free, len(ticks))])
+			accepted += n
+			ticks = ticks[n:]
+
+		default:
+			if len(ticks) <= free {
+				accepted += q.push(ticks)
+				ticks = nil
+			}
+		}
+
+		if len(ticks) == 0 {
+			q.mu.Unlock()
+			return accepted, nil
+		}
+
+		if q.space == nil {
+			q.space = make(chan struct{})
+		}
+		space := q.space
+		q.mu.Unlock()
+
+		if timer == nil {
+			timer = time.NewTimer(opts.Timeout)
+		}
+
+		select {
+		case <-space:
+		case <-timer.C:
+			return accepted, ErrTimeout
+		}
+	}
+}
+
+// Pop dequeues up to limit ticks.
+func (q *Queue) Pop(limit int) []Tick {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := min(limit, q.size)
+	ticks := make([]Tick, n)
+	for i := range ticks {
+		idx := (q.head + i) % len(q.ticks)
+		ticks[i] = q.ticks[idx]
+		q.ticks[idx] = Tick{}
+	}
+	q.head = (q.head + n) % len(q.ticks)
+	q.size -= n
+
+	if q.size > 0 {
+		q.signal()
+	}
+	q.freed()
+	return ticks
+}
+
+// push appends the ticks that fit and returns the number of ticks pushed.
+// Must be called with the lock held.
+func (q *Queue) push(ticks []Tick) int {
+	n := min(len(ticks), len(q.ticks)-q.size)
+	for i := 0; i < n; i++ {
+		q.ticks[(q.head+q.size+i)%len(q.ticks)] = ticks[i]
+	}
+	q.size += n
+
+	if n > 0 {
+		q.signal()
+	}
+	if !q.congested && q.size >= q.high {
+		q.congested = true
+		if q.watermark != nil {
+			q.watermark(q.size, len(q.ticks), true)
+		}
+	}
+	return n
+}
+
+// drop discards the n oldest ticks. Must be called with the lock held.
+func (q *Queue) drop(n int) {
+	for i := 0; i < n; i++ {
+		q.ticks[(q.head+i)%len(q.ticks)] = Tick{}
+	}
+	q.head = (q.head + n) % len(q.ticks)
+	q.size -= n
+}
+
+// freed wakes up the blocked publishers. Must be called with the lock held.
+func (q *Queue) freed() {
+	if q.space != nil {
+		close(q.space)
+		q.space = nil
+	}
+
+	if q.congested && q.size <= q.low {
+		q.congested = false
+		if q.watermark != nil {
+			q.watermark(q.size, len(q.ticks), false)
+		}
+	}
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,141 @@
+package ticker
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// instrumentTicks returns a tick of each instrument.
+func instrumentTicks(instrs ...int32) []Tick {
+	ticks := make([]Tick, len(instrs))
+	for i, instr := range instrs {
+		ticks[i] = Tick{Instrument: instr}
+	}
+	return ticks
+}
+
+// popInstruments pops all the ticks of the queue and returns their
+// instruments.
+func popInstruments(q *Queue) []int32 {
+	var instrs []int32
+	for _, tick := range q.Pop(q.Len()) {
+		instrs = append(instrs, tick.Instrument)
+	}
+	return instrs
+}
+
+func TestQueuePush(t *testing.T) {
+	tests := []struct {
+		name     string
+		queued   []int32
+		mode     PublishMode
+		push     []int32
+		accepted int
+		err      error
+		want     []int32
+	}{
+		{"Block", []int32{10}, PublishBlock, []int32{1, 2, 3}, 3, nil, []int32{10, 1, 2, 3}},
+		{"BlockFull", []int32{10, 11, 12}, PublishBlock, []int32{1, 2}, 0, ErrTimeout, []int32{10, 11, 12}},
+		{"BlockCapacity", nil, PublishBlock, []int32{1, 2, 3, 4}, 4, nil, []int32{1, 2, 3, 4}},
+		{"BlockTooLarge", nil, PublishBlock, []int32{1, 2, 3, 4, 5}, 0, ErrBatchTooLarge, nil},
+		{"Partial", []int32{10, 11, 12}, PublishPartial, []int32{1, 2, 3}, 1, ErrTimeout, []int32{10, 11, 12, 1}},
+		{"PartialEmpty", nil, PublishPartial, []int32{1, 2}, 2, nil, []int32{1, 2}},
+		{"ShedOldest", []int32{10, 11, 12}, PublishShedOldest, []int32{1, 2}, 2, nil, []int32{11, 12, 1, 2}},
+		{"ShedOldestAll", []int32{10, 11}, PublishShedOldest, []int32{1, 2, 3, 4}, 4, nil, []int32{1, 2, 3, 4}},
+		{"ShedOldestTruncated", []int32{10, 11}, PublishShedOldest, []int32{1, 2, 3, 4, 5, 6}, 4, nil, []int32{3, 4, 5, 6}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(4)
+			if _, err := q.Push(PublishOptions{Mode: PublishBlock}, instrumentTicks(tt.queued...)); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := PublishOptions{Mode: tt.mode, Timeout: 10 * time.Millisecond}
+			accepted, err := q.Push(opts, instrumentTicks(tt.push...))
+			if accepted != tt.accepted || !errors.Is(err, tt.err) {
+				t.Fatalf("Push() = %d, %v, want %d, %v", accepted, err, tt.accepted, tt.err)
+			}
+			if got := popInstruments(q); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("queued %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueBlockUntilPop(t *testing.T) {
+	q := NewQueue(2)
+	if _, err := q.Push(PublishOptions{Mode: PublishBlock}, instrumentTicks(10, 11)); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := q.Push(PublishOptions{Mode: PublishBlock, Timeout: time.Second}, instrumentTicks(1, 2))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Push() returned %v on a full queue", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	if got := popInstruments(q); !reflect.DeepEqual(got, []int32{10, 11}) {
+		t.Fatalf("popped %v, want [10 11]", got)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Push() = %v after the queue was emptied", err)
+	}
+	if got := popInstruments(q); !reflect.DeepEqual(got, []int32{1, 2}) {
+		t.Fatalf("popped %v, want [1 2]", got)
+	}
+}
+
+func TestQueueWatermarks(t *testing.T) {
+	type event struct {
+		depth int
+		high  bool
+	}
+
+	// the queue of 10 ticks is congested from 8 ticks until it drains to 5.
+	tests := []struct {
+		name  string
+		steps []int // the number of ticks pushed, or popped if negative.
+		want  []event
+	}{
+		{"BelowHigh", []int{7, -7}, nil},
+		{"High", []int{7, 1}, []event{{8, true}}},
+		{"HighOnce", []int{8, 1, -1, 1}, []event{{8, true}}},
+		{"AboveLow", []int{8, -2}, []event{{8, true}}},
+		{"Low", []int{8, -3}, []event{{8, true}, {5, false}}},
+		{"HighAgain", []int{9, -9, 8}, []event{{9, true}, {0, false}, {8, true}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []event
+			q := NewQueue(10)
+			q.OnWatermark(func(depth, capacity int, high bool) {
+				if capacity != 10 {
+					t.Errorf("capacity = %d, want 10", capacity)
+				}
+				got = append(got, event{depth, high})
+			})
+
+			for _, n := range tt.steps {
+				if n < 0 {
+					q.Pop(-n)
+				} else if _, err := q.Push(PublishOptions{Mode: PublishPartial}, make([]Tick, n)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("watermarks %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
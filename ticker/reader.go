@@ -0,0 +1,93 @@
+package ticker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Reader is a source that reads newline-delimited ticks (see ParseTicks)
+// from R. Lines that are already buffered are published as a single batch.
+// Reading slows down to the pace of the broker when its queue is full.
+type Reader struct {
+	R io.Reader
+
+	// Interval is the delay between consecutive lines. If zero, lines are
+	// published as soon as they are read.
+	Interval time.Duration
+}
+
+// Run starts reading ticks until the reader is exhausted or the context is
+// cancelled.
+func (rd *Reader) Run(ctx context.Context, pub Publisher) error {
+	br := bufio.NewReaderSize(rd.R, 64*1024)
+
+	var pacer <-chan time.Time
+	if rd.Interval > 0 {
+		t := time.NewTicker(rd.Interval)
+		defer t.Stop()
+		pacer = t.C
+	}
+
+	var batch []Tick
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			ticks, perr := ParseTicks(line)
+			if perr != nil {
+				log.Warn().Err(perr).Msg("ignoring invalid tick line")
+			} else {
+				batch = append(batch, ticks...)
+			}
+		}
+
+		if err != nil {
+			publishBlocking(ctx, pub, batch)
+			if errors.Is(err, io.EOF) || errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if pacer == nil && br.Buffered() > 0 {
+			continue // more lines readily available, keep batching.
+		}
+
+		if pacer != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-pacer:
+			}
+		} else if ctx.Err() != nil {
+			return nil
+		}
+
+		publishBlocking(ctx, pub, batch)
+		batch = nil
+	}
+}
+
+// File is a source that replays newline-delimited ticks from a file,
+// publishing one line per Interval.
+type File struct {
+	Path     string
+	Interval time.Duration
+}
+
+// Run replays the file once.
+func (fs *File) Run(ctx context.Context, pub Publisher) error {
+	f, err := os.Open(fs.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rd := &Reader{R: f, Interval: fs.Interval}
+	return rd.Run(ctx, pub)
+}
@@ -0,0 +1,122 @@
+package ticker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Replay is a source that publishes batches from a capture file written by
+// a Recorder.
+type Replay struct {
+	Path string
+
+	// Speed is the replay speed relative to the recorded timing (e.g., 2
+	// replays twice as fast). If zero or negative, batches are published
+	// as fast as possible.
+	Speed float64
+
+	// Instruments, if not empty, restricts the replay to these instruments.
+	Instruments []int32
+
+	// Loop restarts the replay from the beginning once the file is
+	// exhausted.
+	Loop bool
+}
+
+// Run replays the capture file until it is exhausted (unless looping) or
+// the context is cancelled.
+func (rp *Replay) Run(ctx context.Context, pub Publisher) error {
+	var filter map[int32]bool
+	if len(rp.Instruments) > 0 {
+		filter = make(map[int32]bool, len(rp.Instruments))
+		for _, instr := range rp.Instruments {
+			filter[instr] = true
+		}
+	}
+
+	for {
+		batches, err := rp.replayOnce(ctx, pub, filter)
+		if err != nil {
+			return err
+		} else if ctx.Err() != nil {
+			return nil
+		}
+		log.Info().Int("batches", batches).Str("path", rp.Path).Msg("replay finished")
+
+		if !rp.Loop {
+			return nil
+		} else if batches == 0 {
+			return fmt.Errorf("nothing to replay in '%s'", rp.Path)
+		}
+	}
+}
+
+func (rp *Replay) replayOnce(ctx context.Context, pub Publisher, filter map[int32]bool) (int, error) {
+	f, err := os.Open(rp.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 64*1024)
+
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, captureMagic) {
+		return 0, fmt.Errorf("%w: '%s' is not a capture file", ErrBadCapture, rp.Path)
+	}
+
+	var first time.Time
+	var start time.Time
+	batches := 0
+	for ctx.Err() == nil {
+		at, ticks, err := ReadBatch(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return batches, nil
+			}
+			return batches, err
+		}
+
+		if batches == 0 {
+			first, start = at, time.Now()
+		}
+		batches++
+
+		if rp.Speed > 0 {
+			offset := time.Duration(float64(at.Sub(first)) / rp.Speed)
+			if wait := time.Until(start.Add(offset)); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return batches, nil
+				case <-time.After(wait):
+				}
+			}
+		}
+
+		if filter != nil {
+			filtered := ticks[:0]
+			for _, tick := range ticks {
+				if filter[tick.Instrument] {
+					filtered = append(filtered, tick)
+				}
+			}
+			ticks = filtered
+		}
+
+		if rp.Speed > 0 {
+			publish(pub, PublishBlock, ticks)
+		} else {
+			publishBlocking(ctx, pub, ticks)
+		}
+	}
+
+	return batches, nil
+}
@@ -0,0 +1,143 @@
+package ticker
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// NewSequencer returns a sequencer publishing to pub that keeps the last
+// historySize ticks of every instrument for replays.
+func NewSequencer(pub Publisher, historySize int) *Sequencer {
+	return &Sequencer{
+		Publisher: pub,
+		size:      historySize,
+		seqs:      make(map[int32]uint64),
+		history:   make(map[int32]*ring),
+	}
+}
+
+// Sequencer is a publisher that stamps every tick packet with a
+// monotonically increasing per-instrument sequence number before handing
+// it over to the underlying publisher. It also keeps a short history of
+// the stamped packets of every instrument to serve replays of missed
+// sequences.
+type Sequencer struct {
+	Publisher
+
+	// publish serialises the publishes, so that the ticks reach the
+	// underlying publisher in the order of their sequence numbers.
+	publish sync.Mutex
+	seqs    map[int32]uint64 // guarded by publish.
+
+	mu      sync.RWMutex
+	size    int
+	history map[int32]*ring
+}
+
+// Publish stamps the ticks and publishes them. Ticks too short to carry a
+// sequence number are published as is. Ticks rejected by the underlying
+// publisher leave their sequence numbers to the next ticks, except in the
+// shed-oldest mode: the ticks shed from the batch were stamped after the
+// ones queued before, so clients see their loss as a gap, which cannot be
+// replayed since only the accepted ticks are kept in the history.
+func (sq *Sequencer) Publish(opts PublishOptions, ticks []Tick) (int, error) {
+	sq.publish.Lock()
+	defer sq.publish.Unlock()
+
+	stamped := make([]Tick, len(ticks))
+	last := make(map[int32]uint64) // provisional sequence numbers.
+	for i, tick := range ticks {
+		stamped[i] = tick
+		if len(tick.Data) < ltpPacketSize {
+			continue
+		}
+
+		seq, found := last[tick.Instrument]
+		if !found {
+			seq = sq.seqs[tick.Instrument]
+		}
+		seq++
+		last[tick.Instrument] = seq
+
+		// packets may be shared with other consumers (e.g., cluster peers),
+		// so the stamp goes on a copy.
+		data := append([]byte(nil), tick.Data...)
+		binary.BigEndian.PutUint64(data[seqOffset:], seq)
+		stamped[i].Data = data
+	}
+
+	n, err := sq.Publisher.Publish(opts, stamped)
+	accepted := AcceptedTicks(opts, stamped, n)
+
+	if opts.Mode == PublishShedOldest {
+		for instr, seq := range last {
+			sq.seqs[instr] = seq
+		}
+	}
+
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	for _, tick := range accepted {
+		if len(tick.Data) < ltpPacketSize {
+			continue
+		}
+
+		seq := binary.BigEndian.Uint64(tick.Data[seqOffset:])
+		sq.seqs[tick.Instrument] = max(sq.seqs[tick.Instrument], seq)
+		if sq.size > 0 {
+			h := sq.history[tick.Instrument]
+			if h == nil {
+				h = &ring{packets: make([][]byte, sq.size), seqs: make([]uint64, sq.size)}
+				sq.history[tick.Instrument] = h
+			}
+			h.push(seq, tick.Data)
+		}
+	}
+	return n, err
+}
+
+// Since returns the packets of the instrument with sequence numbers from
+// seq onwards that are still in the history, oldest first.
+func (sq *Sequencer) Since(instr int32, seq uint64) [][]byte {
+	sq.mu.RLock()
+	defer sq.mu.RUnlock()
+
+	h := sq.history[instr]
+	if h == nil {
+		return nil
+	}
+	return h.since(seq)
+}
+
+// ring is a fixed size ring of sequenced packets. Sequence numbers that
+// were never pushed leave holes, which are skipped.
+type ring struct {
+	packets [][]byte
+	seqs    []uint64 // sequence numbers of the packets.
+	last    uint64   // sequence number of the latest packet.
+}
+
+func (r *ring) push(seq uint64, packet []byte) {
+	i := seq % uint64(len(r.packets))
+	r.packets[i], r.seqs[i] = packet, seq
+	r.last = seq
+}
+
+func (r *ring) since(seq uint64) [][]byte {
+	oldest := uint64(1)
+	if r.last > uint64(len(r.packets)) {
+		oldest = r.last - uint64(len(r.packets)) + 1
+	}
+	seq = max(seq, oldest)
+	if seq > r.last {
+		return nil
+	}
+
+	packets := make([][]byte, 0, r.last-seq+1)
+	for ; seq <= r.last; seq++ {
+		if i := seq % uint64(len(r.packets)); r.seqs[i] == seq {
+			packets = append(packets, r.packets[i])
+		}
+	}
+	return packets
+}
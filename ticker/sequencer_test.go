@@ -0,0 +1,205 @@
+package ticker
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// quoteTick returns a tick of the instrument with an unstamped LTP packet.
+func quoteTick(instr int32) Tick {
+	q := Quote{Instrument: instr, LastPrice: 100}
+	return Tick{Instrument: instr, Data: q.AppendPacket(nil, ModeLTP)}
+}
+
+// packetSeqs returns the sequence numbers of the packets.
+func packetSeqs(t *testing.T, packets [][]byte) []uint64 {
+	t.Helper()
+
+	var seqs []uint64
+	for _, packet := range packets {
+		q, _, err := ParseQuote(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, q.Seq)
+	}
+	return seqs
+}
+
+func TestSequencerPublish(t *testing.T) {
+	var published [][]byte
+	accept := true
+	sq := NewSequencer(publishFunc(func(_ PublishOptions, ticks []Tick) (int, error) {
+		if !accept {
+			return 0, ErrTimeout
+		}
+		for _, tick := range ticks {
+			published = append(published, tick.Data)
+		}
+		return len(ticks), nil
+	}), 0)
+
+	short := Tick{Instrument: 1, Data: []byte("short")}
+	batch := []Tick{quoteTick(1), quoteTick(2), quoteTick(1), short}
+	if _, err := sq.Publish(PublishOptions{}, batch); err != nil {
+		t.Fatal(err)
+	}
+	if got := packetSeqs(t, published[:3]); !reflect.DeepEqual(got, []uint64{1, 1, 2}) {
+		t.Fatalf("sequence numbers %v, want [1 1 2]", got)
+	}
+	if string(published[3]) != "short" {
+		t.Fatalf("short tick published as %q", published[3])
+	}
+	if got := packetSeqs(t, [][]byte{batch[0].Data}); got[0] != 0 {
+		t.Fatal("the packet of the batch was stamped in place")
+	}
+
+	// rejected ticks leave their sequence numbers to the next ones.
+	accept = false
+	_, _ = sq.Publish(PublishOptions{}, []Tick{quoteTick(1)})
+	accept = true
+	published = nil
+	if _, err := sq.Publish(PublishOptions{}, []Tick{quoteTick(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := packetSeqs(t, published); !reflect.DeepEqual(got, []uint64{3}) {
+		t.Fatalf("sequence numbers %v after a rejected tick, want [3]", got)
+	}
+}
+
+func TestSequencerRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    PublishMode
+		batch   []int32
+		accept  int
+		next    uint64   // sequence number of the next tick of instrument 1.
+		history []uint64 // of instrument 1 after the next tick.
+	}{
+		{"Block", PublishBlock, []int32{1, 2, 1}, 0, 1, []uint64{1}},
+		{"Partial", PublishPartial, []int32{1, 2, 1}, 2, 2, []uint64{1, 2}},
+		{"PartialNone", PublishPartial, []int32{1, 2, 1}, 0, 1, []uint64{1}},
+		// the shed tick 1 is a gap, but is not in the history.
+		{"ShedOldest", PublishShedOldest, []int32{1, 2, 1}, 2, 3, []uint64{2, 3}},
+		{"ShedAll", PublishShedOldest, []int32{1, 2, 1}, 0, 3, []uint64{3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accept := tt.accept
+			var published [][]byte
+			sq := NewSequencer(publishFunc(func(opts PublishOptions, ticks []Tick) (int, error) {
+				n := min(accept, len(ticks))
+				published = nil
+				for _, tick := range AcceptedTicks(opts, ticks, n) {
+					published = append(published, tick.Data)
+				}
+				if n < len(ticks) {
+					return n, ErrTimeout
+				}
+				return n, nil
+			}), 8)
+
+			batch := make([]Tick, len(tt.batch))
+			for i, instr := range tt.batch {
+				batch[i] = quoteTick(instr)
+			}
+			if n, _ := sq.Publish(PublishOptions{Mode: tt.mode}, batch); n != tt.accept {
+				t.Fatalf("Publish() = %d, want %d", n, tt.accept)
+			}
+
+			accept = 1
+			if _, err := sq.Publish(PublishOptions{Mode: tt.mode}, []Tick{quoteTick(1)}); err != nil {
+				t.Fatal(err)
+			}
+			if got := packetSeqs(t, published); !reflect.DeepEqual(got, []uint64{tt.next}) {
+				t.Fatalf("next sequence number %v, want %d", got, tt.next)
+			}
+			if got := packetSeqs(t, sq.Since(1, 0)); !reflect.DeepEqual(got, tt.history) {
+				t.Fatalf("Since(1, 0) = %v, want %v", got, tt.history)
+			}
+		})
+	}
+}
+
+func TestSequencerConcurrent(t *testing.T) {
+	const publishers, batches = 8, 100
+
+	// the underlying publisher must see the sequence numbers in order.
+	var mu sync.Mutex
+	last := make(map[int32]uint64)
+	sq := NewSequencer(publishFunc(func(_ PublishOptions, ticks []Tick) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, tick := range ticks {
+			q, _, err := ParseQuote(tick.Data)
+			if err != nil {
+				return 0, err
+			}
+			if q.Seq != last[q.Instrument]+1 {
+				t.Errorf("instrument %d: sequence number %d after %d", q.Instrument, q.Seq, last[q.Instrument])
+			}
+			last[q.Instrument] = q.Seq
+		}
+		return len(ticks), nil
+	}), 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < publishers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < batches; j++ {
+				if _, err := sq.Publish(PublishOptions{}, []Tick{quoteTick(1), quoteTick(2), quoteTick(1)}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if want := map[int32]uint64{1: 2 * publishers * batches, 2: publishers * batches}; !reflect.DeepEqual(last, want) {
+		t.Fatalf("last sequence numbers %v, want %v", last, want)
+	}
+}
+
+func TestSequencerHistory(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		published int // ticks of instrument 1.
+		instr     int32
+		from      uint64
+		want      []uint64
+	}{
+		{"Empty", 3, 0, 1, 1, nil},
+		{"All", 3, 2, 1, 1, []uint64{1, 2}},
+		{"FromZero", 3, 2, 1, 0, []uint64{1, 2}},
+		{"Latest", 3, 2, 1, 2, []uint64{2}},
+		{"Ahead", 3, 2, 1, 3, nil},
+		{"Full", 3, 3, 1, 1, []uint64{1, 2, 3}},
+		{"Wrapped", 3, 5, 1, 1, []uint64{3, 4, 5}},
+		{"WrappedFrom", 3, 5, 1, 4, []uint64{4, 5}},
+		{"UnknownInstrument", 3, 5, 2, 1, nil},
+		{"NoHistory", 0, 5, 1, 1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sq := NewSequencer(publishFunc(func(_ PublishOptions, ticks []Tick) (int, error) {
+				return len(ticks), nil
+			}), tt.size)
+			for i := 0; i < tt.published; i++ {
+				if _, err := sq.Publish(PublishOptions{}, []Tick{quoteTick(1)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := packetSeqs(t, sq.Since(tt.instr, tt.from)); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Since(%d, %d) = %v, want %v", tt.instr, tt.from, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package ticker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// ActionSession is the action of the Session reply sent on connect.
+const ActionSession = "session"
+
+// Session is the reply sent to a client on connect with its session token.
+// Resumed is set if the subscriptions of a previous connection were
+// restored, and Dropped is the number of packets that did not fit in the
+// buffer while the client was away.
+type Session struct {
+	Action  string `json:"a"`
+	Token   string `json:"t"`
+	Resumed bool   `json:"r,omitempty"`
+	Dropped int    `json:"d,omitempty"`
+}
+
+// NewSessions returns a session registry that keeps disconnected sessions
+// for the grace period, buffering up to buffer packets for each of them.
+func NewSessions(grace time.Duration, buffer int) *Sessions {
+	return &Sessions{
+		Grace:  grace,
+		Buffer: buffer,
+		active: make(map[string]Subscriber),
+		tokens: make(map[Subscriber]string),
+		parked: make(map[string]*parked),
+	}
+}
+
+// Sessions tracks the sessions of clients so that they can be resumed
+// after a reconnect. Like Topics, it must be guarded by the broker.
+type Sessions struct {
+	Grace  time.Duration
+	Buffer int
+
+	active map[string]Subscriber
+	tokens map[Subscriber]string
+	parked map[string]*parked
+}
+
+// parked is a disconnected session. It stands in for the client in the
+// subscriptions and buffers the packets until the session is resumed or
+// expires.
+type parked struct {
+	expires time.Time
+	limit   int
+	packets [][]byte
+	dropped int
+}
+
+func (p *parked) EnqueuWrite(msg []byte) {
+	if len(p.packets) >= p.limit {
+		p.packets = p.packets[1:]
+		p.dropped++
+	}
+	p.packets = append(p.packets, msg)
+}
+
+func (p *parked) EnqueuReply([]byte) {}
+
+// Connect registers a newly connected client. If sessions are enabled, the
+// session of the token (if any, and not expired) is resumed: its
+// subscriptions move over to the client and the buffered packets are
+// replayed (see Topics.ClientQueue). Otherwise, a new session is started. Either way, the client is
+// sent its session token.
+func (t *Topics) Connect(sub Subscriber, token string) {
+	t.connected.Add(1)
+	t.conns[sub] = struct{}{}
+
+	ss := t.Sessions
+	if ss == nil {
+		return
+	}
+
+	var buffered [][]byte
+	reply := Session{Action: ActionSession, Token: token}
+	if p := ss.parked[token]; token != "" && p != nil {
+		delete(ss.parked, token)
+		t.rebind(p, sub)
+		buffered = p.packets
+		reply.Resumed, reply.Dropped = true, p.dropped
+		if t.ClientQueue > 0 && len(buffered) > t.ClientQueue {
+			reply.Dropped += len(buffered) - t.ClientQueue
+			buffered = buffered[len(buffered)-t.ClientQueue:]
+		}
+	} else if old := ss.active[token]; token != "" && old != nil {
+		// the previous connection is not known to be closed yet (e.g., a
+		// network blip), the session is taken over from it.
+		delete(ss.tokens, old)
+		t.rebind(old, sub)
+		reply.Resumed = true
+	} else {
+		reply.Token = newToken()
+	}
+
+	ss.active[reply.Token] = sub
+	ss.tokens[sub] = reply.Token
+	t.reply(sub, reply)
+
+	for _, packet := range buffered {
+		sub.EnqueuWrite(packet)
+	}
+}
+
+// Expire removes the disconnected sessions whose grace period is over.
+func (t *Topics) Expire(now time.Time) {
+	ss := t.Sessions
+	if ss == nil {
+		return
+	}
+
+	for token, p := range ss.parked {
+		if now.After(p.expires) {
+			delete(ss.parked, token)
+			t.drop(p)
+		}
+	}
+}
+
+// park replaces the client with a parked session if it has one. It returns
+// false if the client has no session.
+func (t *Topics) park(sub Subscriber) bool {
+	ss := t.Sessions
+	if ss == nil {
+		return false
+	}
+
+	token, found := ss.tokens[sub]
+	if !found {
+		return false
+	}
+	delete(ss.tokens, sub)
+	delete(ss.active, token)
+
+	p := &parked{
+		expires: time.Now().Add(ss.Grace),
+		limit:   max(ss.Buffer, 1),
+	}
+	ss.parked[token] = p
+	t.rebind(sub, p)
+	return true
+}
+
+// rebind moves the subscriptions of a client over to another one.
+func (t *Topics) rebind(from, to Subscriber) {
+	c := t.clients[from]
+	if c == nil {
+		return
+	}
+	delete(t.clients, from)
+	t.clients[to] = c
+
+	for instr, mode := range c.instruments {
+		subs := t.topics[instr]
+		delete(subs, from)
+		subs[to] = mode
+	}
+	for name, mode := range c.groups {
+		subs := t.groupSubs[name]
+		delete(subs, from)
+		subs[to] = mode
+	}
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
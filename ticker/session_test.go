@@ -0,0 +1,125 @@
+package ticker
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// queueSub is a subscriber with a bounded write queue that blocks when full,
+// like the clients of the brokers before their writer is started.
+type queueSub struct {
+	writes  chan []byte
+	replies chan []byte
+}
+
+func newQueueSub(size int) *queueSub {
+	return &queueSub{writes: make(chan []byte, size), replies: make(chan []byte, 16)}
+}
+
+func (s *queueSub) EnqueuWrite(msg []byte) { s.writes <- msg }
+func (s *queueSub) EnqueuReply(msg []byte) { s.replies <- msg }
+
+func TestSessionResume(t *testing.T) {
+	tests := []struct {
+		name        string
+		buffer      int
+		clientQueue int
+		want        []uint64 // sequence numbers of the replayed packets.
+		wantDropped int
+	}{
+		{"Replayed", 10, 16, []uint64{1, 2, 3, 4, 5, 6, 7, 8}, 0},
+		{"BufferFull", 3, 16, []uint64{6, 7, 8}, 5},
+		{"BufferLargerThanQueue", 10, 4, []uint64{5, 6, 7, 8}, 4},
+		{"BothFull", 6, 4, []uint64{5, 6, 7, 8}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topics := NewTopics()
+			topics.Sessions = NewSessions(time.Minute, tt.buffer)
+			topics.ClientQueue = tt.clientQueue
+
+			first := &testSub{}
+			topics.Connect(first, "")
+			var session Session
+			first.lastReply(t, &session)
+
+			topics.Apply(first, Request{Mode: ModeLTP, Instruments: []int32{1}})
+			topics.Remove(first)
+			for seq := uint64(1); seq <= 8; seq++ {
+				q := Quote{Instrument: 1, Seq: seq}
+				topics.Dispatch([]Tick{q.Tick()})
+			}
+
+			// the writer of the client only starts once connected.
+			resumed := newQueueSub(tt.clientQueue)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				topics.Connect(resumed, session.Token)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("Connect() blocked on the write queue")
+			}
+
+			var got []uint64
+			for len(resumed.writes) > 0 {
+				q, _, err := ParseQuote(<-resumed.writes)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, q.Seq)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("replayed %v, want %v", got, tt.want)
+			}
+
+			var reply Session
+			if err := json.Unmarshal(<-resumed.replies, &reply); err != nil {
+				t.Fatal(err)
+			}
+			if !reply.Resumed || reply.Token != session.Token || reply.Dropped != tt.wantDropped {
+				t.Errorf("reply = %+v, want resumed session %s with %d dropped", reply, session.Token, tt.wantDropped)
+			}
+
+			subs, _ := topics.Subscriptions(resumed)
+			if !reflect.DeepEqual(subs, map[int32]Mode{1: ModeLTP}) {
+				t.Errorf("Subscriptions() = %v, want the subscriptions of the session", subs)
+			}
+		})
+	}
+}
+
+func TestSessionExpire(t *testing.T) {
+	topics := NewTopics()
+	topics.Sessions = NewSessions(time.Minute, 10)
+
+	sub := &testSub{}
+	topics.Connect(sub, "")
+	var session Session
+	sub.lastReply(t, &session)
+
+	topics.Apply(sub, Request{Mode: ModeLTP, Instruments: []int32{1}})
+	topics.Remove(sub)
+	if _, found := topics.topics[1]; !found {
+		t.Fatal("the parked session is not subscribed")
+	}
+
+	topics.Expire(time.Now().Add(2 * time.Minute))
+	if len(topics.topics) != 0 || len(topics.clients) != 0 {
+		t.Errorf("topics = %v, clients = %v after expiry, want none", topics.topics, topics.clients)
+	}
+
+	// the expired token starts a new session.
+	next := &testSub{}
+	topics.Connect(next, session.Token)
+	var reply Session
+	next.lastReply(t, &reply)
+	if reply.Resumed || reply.Token == session.Token {
+		t.Errorf("reply = %+v, want a new session", reply)
+	}
+}
@@ -0,0 +1,185 @@
+package ticker
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/paulbellamy/ratecounter"
+	"github.com/rs/zerolog/log"
+)
+
+// priceTick is the minimum price movement (in paise) used by the simulator.
+const priceTick = 5
+
+// Simulator is a source that simulates a market. Every instrument follows
+// a random walk, the activity across instruments follows a Zipf distribution
+// and the activity bursts around market open events.
+type Simulator struct {
+	Instruments int
+	TickRate    time.Duration
+
+	// TradeCount is the average number of trades per tick outside of a
+	// burst.
+	TradeCount int
+
+	// Skew is the Zipf exponent of the instrument activity (must be > 1).
+	// Larger values concentrate activity on fewer instruments.
+	Skew float64
+
+	// Volatility is the standard deviation of the relative price change of
+	// a single trade.
+	Volatility float64
+
+	// Opens are the market open events as offsets from the start of the
+	// simulation. Activity is multiplied by up to Burst around each open,
+	// decaying over BurstWidth.
+	Opens      []time.Duration
+	Burst      float64
+	BurstWidth time.Duration
+
+	// Seed seeds the simulation. If zero, the current time is used.
+	Seed int64
+}
+
+// Run starts the simulation.
+func (sim *Simulator) Run(ctx context.Context, pub Publisher) error {
+	if sim.Instruments <= 0 {
+		return errors.New("simulator needs at least one instrument")
+	}
+
+	seed := sim.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rnd := rand.New(rand.NewSource(seed))
+
+	skew := sim.Skew
+	if skew <= 1 {
+		skew = 1.1
+	}
+
+	// instruments are ranked by activity using a random permutation so that
+	// the hot instruments are spread across the token space.
+	quotes := sim.initQuotes(rnd)
+	ranks := rnd.Perm(len(quotes))
+	zipf := rand.NewZipf(rnd, skew, 1, uint64(len(quotes)-1))
+
+	tick := time.NewTicker(sim.TickRate)
+	defer tick.Stop()
+
+	rateTick := time.NewTicker(10 * time.Second)
+	defer rateTick.Stop()
+
+	counter := ratecounter.NewRateCounter(1 * time.Second)
+
+	start := time.Now()
+	touched := make(map[int]struct{})
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-rateTick.C:
+			log.Debug().Int64("rate", counter.Rate()).Msg("simulated tick rate")
+
+		case t := <-tick.C:
+			trades := int(float64(sim.TradeCount) * sim.activity(t.Sub(start)) * (0.5 + rnd.Float64()))
+			for i := 0; i < trades; i++ {
+				idx := ranks[zipf.Uint64()]
+				sim.trade(rnd, &quotes[idx])
+				touched[idx] = struct{}{}
+			}
+
+			ts := t.UnixMicro()
+			ticks := make([]Tick, 0, len(touched))
+			idxs := make([]int, 0, len(touched))
+			for idx := range touched {
+				q := &quotes[idx]
+				q.Timestamp = ts
+				sim.depth(rnd, q)
+				tick := q.Tick()
+				tick.Scheduled = ts
+				ticks = append(ticks, tick)
+				idxs = append(idxs, idx)
+				delete(touched, idx)
+			}
+
+			// instruments that were not accepted are conflated into the next
+			// tick instead of being queued up.
+			n := publish(pub, PublishPartial, ticks)
+			for _, idx := range idxs[n:] {
+				touched[idx] = struct{}{}
+			}
+			counter.Incr(int64(n))
+		}
+	}
+}
+
+func (sim *Simulator) initQuotes(rnd *rand.Rand) []Quote {
+	quotes := make([]Quote, sim.Instruments)
+	for i := range quotes {
+		price := int64(100_00+rnd.Intn(5000_00)) / priceTick * priceTick
+		quotes[i] = Quote{
+			Instrument: int32(i),
+			LastPrice:  price,
+			Open:       price,
+			High:       price,
+			Low:        price,
+			Close:      price,
+		}
+	}
+	return quotes
+}
+
+// activity returns the multiplier of the trade rate at the given offset
+// from the start of the simulation.
+func (sim *Simulator) activity(at time.Duration) float64 {
+	if sim.Burst <= 1 || sim.BurstWidth <= 0 {
+		return 1
+	}
+
+	mult := 1.0
+	for _, open := range sim.Opens {
+		d := float64(at-open) / float64(sim.BurstWidth)
+		mult = math.Max(mult, 1+(sim.Burst-1)*math.Exp(-d*d))
+	}
+	return mult
+}
+
+func (sim *Simulator) trade(rnd *rand.Rand, q *Quote) {
+	change := math.Exp(sim.Volatility * rnd.NormFloat64())
+	// rounded rather than truncated, which would drag the walk down.
+	price := int64(math.Round(float64(q.LastPrice)*change/priceTick)) * priceTick
+	if price < priceTick {
+		price = priceTick
+	}
+
+	q.LastPrice = price
+	q.High = max(q.High, price)
+	q.Low = min(q.Low, price)
+	q.Volume += int64(1 + rnd.Intn(500))
+}
+
+func (sim *Simulator) depth(rnd *rand.Rand, q *Quote) {
+	q.BuyQty, q.SellQty = 0, 0
+	for i := 0; i < DepthLevels; i++ {
+		spread := int64(i+1) * priceTick
+
+		q.Bids[i] = DepthLevel{
+			Price:  max(q.LastPrice-spread, priceTick),
+			Qty:    int32(1 + rnd.Intn(5000)),
+			Orders: int32(1 + rnd.Intn(50)),
+		}
+		q.Asks[i] = DepthLevel{
+			Price:  q.LastPrice + spread,
+			Qty:    int32(1 + rnd.Intn(5000)),
+			Orders: int32(1 + rnd.Intn(50)),
+		}
+
+		q.BuyQty += int64(q.Bids[i].Qty)
+		q.SellQty += int64(q.Asks[i].Qty)
+	}
+}
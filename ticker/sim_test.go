@@ -0,0 +1,65 @@
+package ticker
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSimulatorTrade(t *testing.T) {
+	tests := []struct {
+		name       string
+		volatility float64
+		price      int64
+		check      func(price int64) bool
+	}{
+		{"NoVolatility", 0, 100_00, func(price int64) bool { return price == 100_00 }},
+		{"OnTicks", 0.01, 100_00, func(price int64) bool { return price%priceTick == 0 }},
+		{"Floor", 10, priceTick, func(price int64) bool { return price >= priceTick }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := &Simulator{Volatility: tt.volatility}
+			rnd := rand.New(rand.NewSource(1))
+			for i := 0; i < 1000; i++ {
+				q := Quote{LastPrice: tt.price, High: tt.price, Low: tt.price}
+				sim.trade(rnd, &q)
+				if !tt.check(q.LastPrice) {
+					t.Fatalf("trade at %d moved the price to %d", tt.price, q.LastPrice)
+				}
+				if q.High < q.LastPrice || q.Low > q.LastPrice {
+					t.Fatalf("price %d out of the range [%d, %d]", q.LastPrice, q.Low, q.High)
+				}
+			}
+		})
+	}
+}
+
+// TestSimulatorUnbiased checks that rounding the prices to ticks does not
+// make the walks drift.
+func TestSimulatorUnbiased(t *testing.T) {
+	const (
+		walks  = 1000
+		trades = 1000
+		start  = 100_00
+	)
+
+	// a trade moves the price by about a tick: truncating the prices would
+	// drift them down by half a tick per trade, 25% over the walk.
+	sim := &Simulator{Volatility: 0.0005}
+	rnd := rand.New(rand.NewSource(1))
+
+	var sum float64
+	for w := 0; w < walks; w++ {
+		q := Quote{LastPrice: start, High: start, Low: start}
+		for i := 0; i < trades; i++ {
+			sim.trade(rnd, &q)
+		}
+		sum += float64(q.LastPrice)
+	}
+
+	if mean := sum / walks; math.Abs(mean-start) > start/100 {
+		t.Fatalf("walks from %d end at %.0f on average, want within 1%%", start, mean)
+	}
+}
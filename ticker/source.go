@@ -0,0 +1,82 @@
+package ticker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// publishTimeout is the timeout used by sources when publishing a batch.
+const publishTimeout = 5 * time.Millisecond
+
+// Source is a producer of ticks. Run must publish ticks to the given
+// publisher until the context is cancelled or the source is exhausted.
+type Source interface {
+	Run(ctx context.Context, pub Publisher) error
+}
+
+// ParseTicks decodes a single line of input into ticks. A line is either a
+// JSON encoded tick (e.g., `{"i": 408065, "d": "AAXg..."}`) or a JSON array
+// of ticks. Ticks without data are stamped with the current time.
+func ParseTicks(line []byte) ([]Tick, error) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	var ticks []Tick
+	if line[0] == '[' {
+		if err := json.Unmarshal(line, &ticks); err != nil {
+			return nil, err
+		}
+	} else {
+		var tick Tick
+		if err := json.Unmarshal(line, &tick); err != nil {
+			return nil, err
+		}
+		ticks = []Tick{tick}
+	}
+
+	now := time.Now()
+	for i := range ticks {
+		if len(ticks[i].Data) == 0 {
+			ticks[i].Data = binary.BigEndian.AppendUint64(nil, uint64(now.UnixMicro()))
+		}
+	}
+	return ticks, nil
+}
+
+// publish publishes the ticks with the given mode and returns the number of
+// ticks accepted.
+func publish(pub Publisher, mode PublishMode, ticks []Tick) int {
+	if len(ticks) == 0 {
+		return 0
+	}
+	stampPublished(ticks, time.Now())
+
+	n, err := pub.Publish(PublishOptions{Mode: mode, Timeout: publishTimeout}, ticks)
+	if err != nil {
+		log.Warn().Err(err).Int("count", len(ticks)).Int("accepted", n).Msg("failed to publish")
+	}
+	return n
+}
+
+// publishBlocking publishes the ticks, slowing down to the pace of the
+// broker until all ticks are accepted or the context is cancelled.
+func publishBlocking(ctx context.Context, pub Publisher, ticks []Tick) {
+	opts := PublishOptions{Mode: PublishPartial, Timeout: publishTimeout}
+	stampPublished(ticks, time.Now())
+	for len(ticks) > 0 && ctx.Err() == nil {
+		n, err := pub.Publish(opts, ticks)
+		ticks = ticks[n:]
+		if err != nil && !errors.Is(err, ErrTimeout) {
+			log.Warn().Err(err).Int("count", len(ticks)).Msg("failed to publish")
+			return
+		}
+	}
+}
@@ -13,32 +13,109 @@ const (
 	ModeFull              // LTP + Quote + Market Depth, etc.
 )
 
+// Publish modes.
+const (
+	PublishBlock      PublishMode = iota // Wait for room for the whole batch.
+	PublishPartial                       // Accept as many ticks as fit, waiting for room for the rest.
+	PublishShedOldest                    // Make room by dropping the oldest queued ticks.
+)
+
 // ErrTimeout should be returned when a publish operation times out.
 var ErrTimeout = errors.New("timeout")
 
+// ErrBatchTooLarge is returned when a batch is published in the block mode
+// to a queue too small to ever hold it.
+var ErrBatchTooLarge = errors.New("batch larger than the queue")
+
 // Mode indicates the subscription mode.
 type Mode int32
 
+// PublishMode decides how a publisher handles a full queue.
+type PublishMode int
+
+// PublishOptions are the options of a publish operation.
+type PublishOptions struct {
+	Mode    PublishMode
+	Timeout time.Duration
+}
+
 // Publisher is a broker that publishes ticks to subscribers.
 type Publisher interface {
-	Publish(timeout time.Duration, ticks []Tick) error
+	// Publish enqueues the ticks for delivery and returns the number of
+	// ticks accepted. If the ticks could not all be accepted within the
+	// timeout, ErrTimeout is returned. In the partial mode, the accepted
+	// ticks are always a prefix of the batch, and in the shed-oldest mode
+	// its newest ticks.
+	Publish(opts PublishOptions, ticks []Tick) (int, error)
+}
+
+// AcceptedTicks returns the n ticks of the batch a publisher accepted: the
+// newest ones in the shed-oldest mode and a prefix otherwise.
+func AcceptedTicks(opts PublishOptions, ticks []Tick, n int) []Tick {
+	if opts.Mode == PublishShedOldest {
+		return ticks[len(ticks)-n:]
+	}
+	return ticks[:n]
 }
 
-// Tick represents a single tick data for an instrument.
+// Tick represents a single tick data for an instrument. Data is usually the
+// full packet of a Quote (see Quote.AppendPacket). Scheduled and Published
+// are the times (unix micro) used to trace the tick (see Trace).
 type Tick struct {
-	Data       []byte
-	Instrument int32
+	Data       []byte `json:"d"`
+	Instrument int32  `json:"i"`
+
+	Scheduled int64 `json:"-"`
+	Published int64 `json:"-"`
 }
 
-// Compute computes the message data based on the subscription mode.
+// Compute computes the message data based on the subscription mode. Since
+// packets of lower modes are prefixes of the full packet, the data is just
+// sliced. Data shorter than the packet of the mode is returned as is.
 func (tic *Tick) Compute(mode Mode) []byte {
-	// TODO: slice the data based on the mode.
+	if n := PacketSize(mode); n > 0 && len(tic.Data) > n {
+		return tic.Data[:n]
+	}
 	return tic.Data
 }
 
+// Request actions.
+const (
+	ActionSubscribe = ""       // Subscribe/unsubscribe based on the mode.
+	ActionReplay    = "replay" // Replay the history from a sequence number.
+	ActionAck       = "ack"    // Reply to a subscription request.
+	ActionList      = "list"   // List the subscriptions of the client.
+)
+
 // Request is a request from client. It is used to subscribe/unsubscribe to
-// instruments.
+// instruments (by token or "EXCHANGE:SYMBOL") or instrument groups, or to
+// replay missed ticks.
 type Request struct {
-	Mode        Mode    `json:"m"`
-	Instruments []int32 `json:"i"`
+	Action      string   `json:"a,omitempty"`
+	Mode        Mode     `json:"m"`
+	Instruments []int32  `json:"i"`
+	Symbols     []string `json:"s,omitempty"`
+	Groups      []string `json:"g,omitempty"`
+	From        uint64   `json:"f,omitempty"` // first sequence number to replay.
+}
+
+// Ack is the reply to a subscription request. Instruments lists the
+// accepted instruments, including the ones resolved from symbols, and the
+// rejected fields list the unknown instruments, symbols and groups.
+type Ack struct {
+	Action          string   `json:"a"`
+	Mode            Mode     `json:"m"`
+	Instruments     []int32  `json:"i"`
+	Groups          []string `json:"g,omitempty"`
+	Rejected        []int32  `json:"r,omitempty"`
+	RejectedSymbols []string `json:"rs,omitempty"`
+	RejectedGroups  []string `json:"rg,omitempty"`
+}
+
+// List is the reply to a list request with the subscriptions of the client.
+// Instruments includes the members of the subscribed groups.
+type List struct {
+	Action      string          `json:"a"`
+	Instruments map[int32]Mode  `json:"i"`
+	Groups      map[string]Mode `json:"g,omitempty"`
 }
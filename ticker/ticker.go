@@ -2,7 +2,6 @@ package ticker
 
 import (
 	"context"
-	"encoding/binary"
 	"math/rand"
 	"time"
 
@@ -10,16 +9,14 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// Ticker is a source of ticks.
+// Ticker is a source of random ticks.
 type Ticker struct {
-	Publisher Publisher
-
 	TickRate   time.Duration
 	TradeCount int
 }
 
 // Run starts the ticker.
-func (ts *Ticker) Run(ctx context.Context) {
+func (ts *Ticker) Run(ctx context.Context, pub Publisher) error {
 	tick := time.NewTicker(ts.TickRate)
 	defer tick.Stop()
 
@@ -28,27 +25,33 @@ func (ts *Ticker) Run(ctx context.Context) {
 
 	counter := ratecounter.NewRateCounter(1 * time.Second)
 
-	b := make([]byte, 8)
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 
 		case <-rateTick.C:
 			log.Debug().Int64("rate", counter.Rate()).Msg("tick rate")
 
 		case t := <-tick.C:
-			binary.BigEndian.PutUint64(b, uint64(t.UnixMicro()))
-
 			updateCount := rand.Intn(ts.TradeCount)
+
+			// all packets of the batch share a single buffer.
+			buf := make([]byte, 0, updateCount*PacketSize(ModeLTP))
 			instrs := make([]Tick, updateCount)
 			for i := 0; i < updateCount; i++ {
-				instrs[i] = Tick{Instrument: int32(i), Data: b}
-			}
-			if err := ts.Publisher.Publish(5*time.Millisecond, instrs); err != nil {
-				log.Warn().Err(err).Msg("failed to publish")
+				q := Quote{Timestamp: t.UnixMicro(), Instrument: int32(i)}
+
+				start := len(buf)
+				buf = q.AppendPacket(buf, ModeLTP)
+				instrs[i] = Tick{
+					Instrument: int32(i),
+					Data:       buf[start:len(buf):len(buf)],
+					Scheduled:  q.Timestamp,
+				}
 			}
-			counter.Incr(int64(updateCount))
+			n := publish(pub, PublishBlock, instrs)
+			counter.Incr(int64(n))
 		}
 	}
 }
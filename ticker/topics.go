@@ -0,0 +1,503 @@
+package ticker
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+)
+
+// Subscriber is a client connection that receives tick packets.
+type Subscriber interface {
+	// EnqueuWrite enqueues a packet, or the message prepared from it if
+	// Topics.Prepare is set, to be sent as a binary message.
+	EnqueuWrite(msg []byte)
+
+	// EnqueuReply enqueues a JSON reply to a request of the client, to be
+	// sent as a text message.
+	EnqueuReply(msg []byte)
+}
+
+// Registry validates the instruments of subscription requests and resolves
+// trading symbols (see Master).
+type Registry interface {
+	Known(token int32) bool
+	Lookup(key string) (int32, bool)
+}
+
+// History provides the recently published packets of instruments.
+type History interface {
+	Since(instr int32, seq uint64) [][]byte
+}
+
+// DemandFunc is invoked when an instrument gains its first subscriber
+// (active=true) or loses its last one (active=false).
+type DemandFunc func(instrument int32, active bool)
+
+// NewTopics returns an empty subscription registry.
+func NewTopics() *Topics {
+	return &Topics{
+		topics:    make(map[int32]map[Subscriber]Mode),
+		groupSubs: make(map[string]map[Subscriber]Mode),
+		clients:   make(map[Subscriber]*subscriptions),
+		conns:     make(map[Subscriber]struct{}),
+		shed:      make(map[Subscriber]struct{}),
+	}
+}
+
+// Topics tracks the subscriptions of clients to instruments and fans out
+// ticks to them. Topics is not safe for concurrent use, brokers must guard
+// it with their own synchronisation.
+type Topics struct {
+	// OnDemand, if set, is invoked with the changes in the set of
+	// instruments that have at least one subscriber. It is called while the
+	// broker holds its synchronisation and must not block.
+	OnDemand DemandFunc
+
+	// History, if set, is used to serve replay requests.
+	History History
+
+	// Registry, if set, is used to reject subscriptions to unknown
+	// instruments and to resolve symbols.
+	Registry Registry
+
+	// Sessions, if set, keeps the subscriptions of disconnected clients
+	// for resumption (see Connect).
+	Sessions *Sessions
+
+	// MaxSubscriptions limits the number of instruments a client can be
+	// subscribed to (0 for no limit). Subscriptions beyond the limit are
+	// rejected in the ack.
+	MaxSubscriptions int
+
+	// ClientQueue, if set, is the number of packets the write queue of a
+	// client holds. A resumed session replays at most that many of its
+	// newest buffered packets, so that Connect never waits on a writer that
+	// has not started yet.
+	ClientQueue int
+
+	// Trace, if set, appends a trace to the packets sent to clients (see
+	// Trace). Each client then gets its own copy of the packets, and brokers
+	// stamp the time they are written with StampWritten.
+	Trace bool
+
+	// Prepare, if set, turns a packet into the message written as-is to the
+	// connections, e.g., a websocket frame. Dispatched packets are prepared
+	// once per tick and mode for all the subscribers.
+	Prepare func(packet []byte) []byte
+
+	connected atomic.Int64
+	topics    map[int32]map[Subscriber]Mode
+	groups    map[string]map[int32]struct{}
+	groupSubs map[string]map[Subscriber]Mode
+	clients   map[Subscriber]*subscriptions
+	conns     map[Subscriber]struct{} // connected clients.
+	shed      map[Subscriber]struct{} // shed clients not removed yet.
+}
+
+// subscriptions is the subscription index of a client. The mode of an
+// instrument is the highest of its direct subscription and of the group
+// subscriptions covering it.
+type subscriptions struct {
+	instruments map[int32]Mode
+	direct      map[int32]Mode // instruments subscribed to individually.
+	groups      map[string]Mode
+}
+
+// Apply applies the request of the client. Subscription requests are
+// acknowledged with an Ack.
+func (t *Topics) Apply(sub Subscriber, req Request) {
+	switch req.Action {
+	case ActionReplay:
+		t.replay(sub, req)
+		return
+
+	case ActionList:
+		t.list(sub)
+		return
+	}
+
+	ack := Ack{Action: ActionAck, Mode: req.Mode, Instruments: []int32{}}
+
+	instrs := req.Instruments
+	if len(req.Symbols) > 0 {
+		instrs = append([]int32(nil), req.Instruments...)
+		for _, sym := range req.Symbols {
+			if token, found := t.lookup(sym); found {
+				instrs = append(instrs, token)
+			} else {
+				ack.RejectedSymbols = append(ack.RejectedSymbols, sym)
+			}
+		}
+	}
+
+	for _, instr := range instrs {
+		if req.Mode == ModeNone {
+			t.unsubscribeDirect(sub, instr)
+		} else if t.Registry != nil && !t.Registry.Known(instr) {
+			ack.Rejected = append(ack.Rejected, instr)
+			continue
+		} else if _, subscribed := t.topics[instr][sub]; !subscribed && !t.fits(sub, 1) {
+			ack.Rejected = append(ack.Rejected, instr)
+			continue
+		} else {
+			t.subscribeDirect(sub, instr, req.Mode)
+		}
+		ack.Instruments = append(ack.Instruments, instr)
+	}
+
+	for _, name := range req.Groups {
+		if req.Mode == ModeNone {
+			t.unsubscribeGroup(sub, name)
+		} else if members, found := t.groups[name]; !found || !t.fits(sub, t.unsubscribed(sub, members)) {
+			ack.RejectedGroups = append(ack.RejectedGroups, name)
+			continue
+		} else {
+			t.subscribeGroup(sub, name, req.Mode)
+		}
+		ack.Groups = append(ack.Groups, name)
+	}
+
+	t.reply(sub, ack)
+}
+
+// SetGroups replaces the instrument groups. The subscribers of a group are
+// subscribed to the members it gained and unsubscribed from the members it
+// lost, and the subscribers of a removed group from all of its members.
+func (t *Topics) SetGroups(groups Groups) {
+	old := t.groups
+	t.groups = groups.members()
+
+	for name, subs := range t.groupSubs {
+		members := t.groups[name]
+		for instr := range old[name] {
+			if _, found := members[instr]; !found {
+				for sub := range subs {
+					t.resubscribe(sub, instr)
+				}
+			}
+		}
+		for instr := range members {
+			if _, found := old[name][instr]; !found {
+				for sub := range subs {
+					t.resubscribe(sub, instr)
+				}
+			}
+		}
+
+		if members == nil {
+			for sub := range subs {
+				if c := t.clients[sub]; c != nil {
+					delete(c.groups, name)
+					t.dropClient(sub, c)
+				}
+			}
+			delete(t.groupSubs, name)
+		}
+	}
+}
+
+// Connected returns the number of connected clients. It is safe for
+// concurrent use.
+func (t *Topics) Connected() int {
+	return int(t.connected.Load())
+}
+
+// Remove removes all the subscriptions of the client. If the client has a
+// session, the subscriptions are kept for the grace period instead.
+func (t *Topics) Remove(sub Subscriber) {
+	t.connected.Add(-1)
+	delete(t.conns, sub)
+	delete(t.shed, sub)
+	if !t.park(sub) {
+		t.drop(sub)
+	}
+}
+
+func (t *Topics) drop(sub Subscriber) {
+	c := t.clients[sub]
+	if c == nil {
+		return
+	}
+
+	for name := range c.groups {
+		subs := t.groupSubs[name]
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(t.groupSubs, name)
+		}
+	}
+
+	for instr := range c.instruments {
+		t.unsubscribe(sub, instr)
+	}
+	delete(t.clients, sub)
+}
+
+// Subscriptions returns a copy of the instruments and groups the client is
+// subscribed to, with their modes.
+func (t *Topics) Subscriptions(sub Subscriber) (map[int32]Mode, map[string]Mode) {
+	instruments := make(map[int32]Mode)
+	groups := make(map[string]Mode)
+	if c := t.clients[sub]; c != nil {
+		for instr, mode := range c.instruments {
+			instruments[instr] = mode
+		}
+		for name, mode := range c.groups {
+			groups[name] = mode
+		}
+	}
+	return instruments, groups
+}
+
+// Dispatch enqueues the ticks to all their subscribers. Traced packets are
+// stamped with the time they are enqueued.
+func (t *Topics) Dispatch(ticks []Tick) {
+	if t.Trace {
+		now := time.Now()
+		for _, tick := range ticks {
+			for sub, mode := range t.topics[tick.Instrument] {
+				sub.EnqueuWrite(t.prepare(tick.traced(mode, now)))
+			}
+		}
+		return
+	}
+
+	if t.Prepare != nil {
+		for _, tick := range ticks {
+			var prepared [ModeFull + 1][]byte
+			for sub, mode := range t.topics[tick.Instrument] {
+				if mode < ModeNone || mode > ModeFull {
+					sub.EnqueuWrite(t.Prepare(tick.Compute(mode)))
+					continue
+				}
+				if prepared[mode] == nil {
+					prepared[mode] = t.Prepare(tick.Compute(mode))
+				}
+				sub.EnqueuWrite(prepared[mode])
+			}
+		}
+		return
+	}
+
+	for _, tick := range ticks {
+		for sub, mode := range t.topics[tick.Instrument] {
+			sub.EnqueuWrite(tick.Compute(mode))
+		}
+	}
+}
+
+// prepare returns the message of the packet (see Prepare).
+func (t *Topics) prepare(packet []byte) []byte {
+	if t.Prepare == nil {
+		return packet
+	}
+	return t.Prepare(packet)
+}
+
+// replay enqueues the packets in the history from the requested sequence
+// number for the instruments the client is subscribed to, in the mode of the
+// subscription.
+func (t *Topics) replay(sub Subscriber, req Request) {
+	if t.History == nil {
+		return
+	}
+
+	for _, instr := range req.Instruments {
+		mode, found := t.topics[instr][sub]
+		if !found {
+			continue
+		}
+
+		for _, packet := range t.History.Since(instr, req.From) {
+			tick := Tick{Instrument: instr, Data: packet}
+			sub.EnqueuWrite(t.prepare(tick.Compute(mode)))
+		}
+	}
+}
+
+// list replies with the subscriptions of the client.
+func (t *Topics) list(sub Subscriber) {
+	instruments, groups := t.Subscriptions(sub)
+	t.reply(sub, List{Action: ActionList, Instruments: instruments, Groups: groups})
+}
+
+func (t *Topics) reply(sub Subscriber, v any) {
+	msg, _ := json.Marshal(v)
+	sub.EnqueuReply(msg)
+}
+
+func (t *Topics) client(sub Subscriber) *subscriptions {
+	c := t.clients[sub]
+	if c == nil {
+		c = &subscriptions{
+			instruments: make(map[int32]Mode),
+			direct:      make(map[int32]Mode),
+			groups:      make(map[string]Mode),
+		}
+		t.clients[sub] = c
+	}
+	return c
+}
+
+// dropClient forgets the client once it has no subscriptions left.
+func (t *Topics) dropClient(sub Subscriber, c *subscriptions) {
+	if len(c.instruments) == 0 && len(c.groups) == 0 {
+		delete(t.clients, sub)
+	}
+}
+
+func (t *Topics) subscribe(sub Subscriber, instr int32, mode Mode) {
+	subs := t.topics[instr]
+	if subs == nil {
+		subs = make(map[Subscriber]Mode)
+		t.topics[instr] = subs
+		if t.OnDemand != nil {
+			t.OnDemand(instr, true)
+		}
+	}
+	subs[sub] = mode
+	t.client(sub).instruments[instr] = mode
+}
+
+func (t *Topics) unsubscribe(sub Subscriber, instr int32) {
+	subs := t.topics[instr]
+	if _, found := subs[sub]; !found {
+		return
+	}
+
+	if c := t.clients[sub]; c != nil {
+		delete(c.instruments, instr)
+		delete(c.direct, instr)
+		t.dropClient(sub, c)
+	}
+
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(t.topics, instr)
+		if t.OnDemand != nil {
+			t.OnDemand(instr, false)
+		}
+	}
+}
+
+// fits reports whether the client can subscribe to n more instruments.
+func (t *Topics) fits(sub Subscriber, n int) bool {
+	if t.MaxSubscriptions <= 0 {
+		return true
+	}
+
+	count := 0
+	if c := t.clients[sub]; c != nil {
+		count = len(c.instruments)
+	}
+	return count+n <= t.MaxSubscriptions
+}
+
+// unsubscribed returns the number of instruments the client is not
+// subscribed to yet.
+func (t *Topics) unsubscribed(sub Subscriber, instrs map[int32]struct{}) int {
+	n := 0
+	for instr := range instrs {
+		if _, subscribed := t.topics[instr][sub]; !subscribed {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *Topics) lookup(sym string) (int32, bool) {
+	if t.Registry == nil {
+		return 0, false
+	}
+	return t.Registry.Lookup(sym)
+}
+
+// subscribeDirect subscribes the client to the instrument individually, on
+// top of its group subscriptions.
+func (t *Topics) subscribeDirect(sub Subscriber, instr int32, mode Mode) {
+	t.client(sub).direct[instr] = mode
+	t.resubscribe(sub, instr)
+}
+
+// unsubscribeDirect drops the individual subscription of the client to the
+// instrument. It stays subscribed if one of its groups covers it.
+func (t *Topics) unsubscribeDirect(sub Subscriber, instr int32) {
+	if c := t.clients[sub]; c != nil {
+		delete(c.direct, instr)
+	}
+	t.resubscribe(sub, instr)
+}
+
+// subscribeGroup subscribes the client to all members of the group. The group subscription is kept to
+// follow the membership changes of the group.
+func (t *Topics) subscribeGroup(sub Subscriber, name string, mode Mode) {
+	members, found := t.groups[name]
+	if !found {
+		return
+	}
+
+	subs := t.groupSubs[name]
+	if subs == nil {
+		subs = make(map[Subscriber]Mode)
+		t.groupSubs[name] = subs
+	}
+	subs[sub] = mode
+	t.client(sub).groups[name] = mode
+
+	for instr := range members {
+		t.resubscribe(sub, instr)
+	}
+}
+
+// unsubscribeGroup unsubscribes the client from the members of the group
+// that are not covered by its direct or other group subscriptions.
+func (t *Topics) unsubscribeGroup(sub Subscriber, name string) {
+	subs := t.groupSubs[name]
+	if _, found := subs[sub]; !found {
+		return
+	}
+
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(t.groupSubs, name)
+	}
+	if c := t.clients[sub]; c != nil {
+		delete(c.groups, name)
+		t.dropClient(sub, c)
+	}
+
+	for instr := range t.groups[name] {
+		t.resubscribe(sub, instr)
+	}
+}
+
+// resubscribe subscribes the client to the instrument in the highest mode of
+// its direct and group subscriptions covering the instrument, or unsubscribes
+// it if there are none.
+func (t *Topics) resubscribe(sub Subscriber, instr int32) {
+	if mode := t.mode(sub, instr); mode != ModeNone {
+		t.subscribe(sub, instr, mode)
+	} else {
+		t.unsubscribe(sub, instr)
+	}
+}
+
+// mode returns the mode the client should be subscribed to the instrument
+// in (see subscriptions).
+func (t *Topics) mode(sub Subscriber, instr int32) Mode {
+	c := t.clients[sub]
+	if c == nil {
+		return ModeNone
+	}
+
+	best := c.direct[instr]
+	for name, mode := range c.groups {
+		if mode <= best {
+			continue
+		}
+		if _, member := t.groups[name][instr]; member {
+			best = mode
+		}
+	}
+	return best
+}
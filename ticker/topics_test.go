@@ -0,0 +1,363 @@
+package ticker
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// testSub is a subscriber that keeps the messages enqueued for it.
+type testSub struct {
+	writes  [][]byte
+	replies [][]byte
+}
+
+func (s *testSub) EnqueuWrite(msg []byte) { s.writes = append(s.writes, msg) }
+func (s *testSub) EnqueuReply(msg []byte) { s.replies = append(s.replies, msg) }
+
+// lastReply decodes the last reply of the subscriber into v.
+func (s *testSub) lastReply(t *testing.T, v any) {
+	t.Helper()
+
+	if len(s.replies) == 0 {
+		t.Fatal("no reply")
+	}
+	if err := json.Unmarshal(s.replies[len(s.replies)-1], v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// step is an operation of a topics test.
+type step func(t *Topics, sub Subscriber)
+
+func subscribe(mode Mode, instrs ...int32) step {
+	return func(t *Topics, sub Subscriber) {
+		t.Apply(sub, Request{Mode: mode, Instruments: instrs})
+	}
+}
+
+func subscribeGroups(mode Mode, names ...string) step {
+	return func(t *Topics, sub Subscriber) {
+		t.Apply(sub, Request{Mode: mode, Groups: names})
+	}
+}
+
+func setGroups(groups Groups) step {
+	return func(t *Topics, _ Subscriber) {
+		t.SetGroups(groups)
+	}
+}
+
+// checkSubscriptions checks that the client is subscribed to exactly the
+// given instruments, both in its index and in the topics.
+func checkSubscriptions(t *testing.T, topics *Topics, sub Subscriber, want map[int32]Mode) {
+	t.Helper()
+
+	got, _ := topics.Subscriptions(sub)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Subscriptions() = %v, want %v", got, want)
+	}
+
+	dispatched := make(map[int32]Mode)
+	for instr, subs := range topics.topics {
+		if mode, found := subs[sub]; found {
+			dispatched[instr] = mode
+		}
+	}
+	if !reflect.DeepEqual(dispatched, want) {
+		t.Errorf("topics = %v, want %v", dispatched, want)
+	}
+}
+
+func TestTopicsGroups(t *testing.T) {
+	groups := Groups{"G": {1, 2}, "H": {2, 3}}
+
+	tests := []struct {
+		name  string
+		steps []step
+		want  map[int32]Mode
+	}{
+		{
+			name:  "Group",
+			steps: []step{subscribeGroups(ModeQuote, "G")},
+			want:  map[int32]Mode{1: ModeQuote, 2: ModeQuote},
+		},
+		{
+			name: "DirectThenGroupUnsubscribed",
+			steps: []step{
+				subscribe(ModeQuote, 1),
+				subscribeGroups(ModeLTP, "G"),
+				subscribeGroups(ModeNone, "G"),
+			},
+			want: map[int32]Mode{1: ModeQuote},
+		},
+		{
+			name: "GroupThenDirectGroupUnsubscribed",
+			steps: []step{
+				subscribeGroups(ModeLTP, "G"),
+				subscribe(ModeQuote, 1),
+				subscribeGroups(ModeNone, "G"),
+			},
+			want: map[int32]Mode{1: ModeQuote},
+		},
+		{
+			name: "LowerGroupKeepsDirectMode",
+			steps: []step{
+				subscribe(ModeFull, 1),
+				subscribeGroups(ModeLTP, "G"),
+			},
+			want: map[int32]Mode{1: ModeFull, 2: ModeLTP},
+		},
+		{
+			name: "HigherGroupOverridesDirectMode",
+			steps: []step{
+				subscribe(ModeLTP, 1),
+				subscribeGroups(ModeFull, "G"),
+			},
+			want: map[int32]Mode{1: ModeFull, 2: ModeFull},
+		},
+		{
+			name: "DirectModeRestoredAfterGroup",
+			steps: []step{
+				subscribe(ModeLTP, 1),
+				subscribeGroups(ModeFull, "G"),
+				subscribeGroups(ModeNone, "G"),
+			},
+			want: map[int32]Mode{1: ModeLTP},
+		},
+		{
+			name: "DirectUnsubscribedKeepsGroup",
+			steps: []step{
+				subscribeGroups(ModeQuote, "G"),
+				subscribe(ModeFull, 1),
+				subscribe(ModeNone, 1),
+			},
+			want: map[int32]Mode{1: ModeQuote, 2: ModeQuote},
+		},
+		{
+			name: "OverlappingGroups",
+			steps: []step{
+				subscribeGroups(ModeLTP, "G"),
+				subscribeGroups(ModeFull, "H"),
+				subscribeGroups(ModeNone, "H"),
+			},
+			want: map[int32]Mode{1: ModeLTP, 2: ModeLTP},
+		},
+		{
+			name: "MemberRemoved",
+			steps: []step{
+				subscribe(ModeLTP, 1),
+				subscribeGroups(ModeQuote, "G"),
+				setGroups(Groups{"G": {2}}),
+			},
+			want: map[int32]Mode{1: ModeLTP, 2: ModeQuote},
+		},
+		{
+			name: "MemberAdded",
+			steps: []step{
+				subscribeGroups(ModeQuote, "G"),
+				setGroups(Groups{"G": {1, 2, 3}}),
+			},
+			want: map[int32]Mode{1: ModeQuote, 2: ModeQuote, 3: ModeQuote},
+		},
+		{
+			name: "GroupRemoved",
+			steps: []step{
+				subscribe(ModeLTP, 1),
+				subscribeGroups(ModeQuote, "G"),
+				setGroups(Groups{"H": {2, 3}}),
+			},
+			want: map[int32]Mode{1: ModeLTP},
+		},
+		{
+			name: "AllUnsubscribed",
+			steps: []step{
+				subscribe(ModeLTP, 1),
+				subscribeGroups(ModeQuote, "G"),
+				subscribe(ModeNone, 1),
+				subscribeGroups(ModeNone, "G"),
+			},
+			want: map[int32]Mode{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topics := NewTopics()
+			topics.SetGroups(groups)
+
+			sub := &testSub{}
+			for _, step := range tt.steps {
+				step(topics, sub)
+			}
+			checkSubscriptions(t, topics, sub, tt.want)
+
+			if len(tt.want) == 0 && len(topics.clients) != 0 {
+				t.Errorf("clients = %v, want none", topics.clients)
+			}
+		})
+	}
+}
+
+func TestTopicsMaxSubscriptions(t *testing.T) {
+	groups := Groups{"G": {1, 2}, "H": {4, 5, 6}}
+
+	tests := []struct {
+		name           string
+		steps          []step
+		rejected       []int32
+		rejectedGroups []string
+		want           map[int32]Mode
+	}{
+		{
+			name:  "WithinLimit",
+			steps: []step{subscribe(ModeLTP, 1, 2, 3)},
+			want:  map[int32]Mode{1: ModeLTP, 2: ModeLTP, 3: ModeLTP},
+		},
+		{
+			name:     "BeyondLimit",
+			steps:    []step{subscribe(ModeLTP, 1, 2, 3, 4, 5)},
+			rejected: []int32{4, 5},
+			want:     map[int32]Mode{1: ModeLTP, 2: ModeLTP, 3: ModeLTP},
+		},
+		{
+			name: "ModeChangeAtLimit",
+			steps: []step{
+				subscribe(ModeLTP, 1, 2, 3),
+				subscribe(ModeFull, 1),
+			},
+			want: map[int32]Mode{1: ModeFull, 2: ModeLTP, 3: ModeLTP},
+		},
+		{
+			name: "UnsubscribeFreesRoom",
+			steps: []step{
+				subscribe(ModeLTP, 1, 2, 3),
+				subscribe(ModeNone, 3),
+				subscribe(ModeLTP, 4),
+			},
+			want: map[int32]Mode{1: ModeLTP, 2: ModeLTP, 4: ModeLTP},
+		},
+		{
+			name: "GroupBeyondLimit",
+			steps: []step{
+				subscribe(ModeLTP, 1),
+				subscribeGroups(ModeQuote, "H"),
+			},
+			rejectedGroups: []string{"H"},
+			want:           map[int32]Mode{1: ModeLTP},
+		},
+		{
+			name: "GroupOverlapCountedOnce",
+			steps: []step{
+				subscribe(ModeLTP, 1, 2, 3),
+				subscribeGroups(ModeQuote, "G"),
+			},
+			want: map[int32]Mode{1: ModeQuote, 2: ModeQuote, 3: ModeLTP},
+		},
+		{
+			name: "GroupMembersCounted",
+			steps: []step{
+				subscribeGroups(ModeQuote, "H"),
+				subscribe(ModeLTP, 1),
+			},
+			rejected: []int32{1},
+			want:     map[int32]Mode{4: ModeQuote, 5: ModeQuote, 6: ModeQuote},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topics := NewTopics()
+			topics.MaxSubscriptions = 3
+			topics.SetGroups(groups)
+
+			sub := &testSub{}
+			for _, step := range tt.steps {
+				step(topics, sub)
+			}
+			checkSubscriptions(t, topics, sub, tt.want)
+
+			var ack Ack
+			sub.lastReply(t, &ack)
+			if !reflect.DeepEqual(ack.Rejected, tt.rejected) || !reflect.DeepEqual(ack.RejectedGroups, tt.rejectedGroups) {
+				t.Errorf("rejected %v and groups %v, want %v and %v", ack.Rejected, ack.RejectedGroups, tt.rejected, tt.rejectedGroups)
+			}
+		})
+	}
+}
+
+func TestTopicsReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		history bool
+		prepare func(packet []byte) []byte
+		req     Request
+		want    []uint64
+		size    int
+	}{
+		{
+			name:    "Subscribed",
+			history: true,
+			req:     Request{Action: ActionReplay, Instruments: []int32{1}, From: 2},
+			want:    []uint64{2, 3},
+			size:    quotePacketSize,
+		},
+		{
+			name:    "NotSubscribed",
+			history: true,
+			req:     Request{Action: ActionReplay, Instruments: []int32{2}, From: 1},
+		},
+		{
+			name:    "Ahead",
+			history: true,
+			req:     Request{Action: ActionReplay, Instruments: []int32{1}, From: 4},
+		},
+		{
+			name: "NoHistory",
+			req:  Request{Action: ActionReplay, Instruments: []int32{1}, From: 1},
+		},
+		{
+			name:    "Prepared",
+			history: true,
+			prepare: func(packet []byte) []byte { return append([]byte("P"), packet...) },
+			req:     Request{Action: ActionReplay, Instruments: []int32{1}, From: 3},
+			want:    []uint64{3},
+			size:    1 + quotePacketSize,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sq := NewSequencer(publishFunc(func(_ PublishOptions, ticks []Tick) (int, error) {
+				return len(ticks), nil
+			}), 8)
+			for i := 0; i < 3; i++ {
+				q := Quote{Instrument: 1}
+				if _, err := sq.Publish(PublishOptions{}, []Tick{q.Tick()}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			topics := NewTopics()
+			if tt.history {
+				topics.History = sq
+			}
+			topics.Prepare = tt.prepare
+
+			sub := &testSub{}
+			topics.Apply(sub, Request{Mode: ModeQuote, Instruments: []int32{1}})
+			topics.Apply(sub, tt.req)
+
+			var packets [][]byte
+			for _, msg := range sub.writes {
+				if len(msg) != tt.size {
+					t.Fatalf("replayed a message of %d bytes, want %d", len(msg), tt.size)
+				}
+				packets = append(packets, msg[tt.size-quotePacketSize:])
+			}
+			if got := packetSeqs(t, packets); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("replayed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package ticker
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// TraceSize is the size of the trace appended to the packets sent to
+// clients when tracing is enabled (see Topics.Trace).
+const TraceSize = 4 * 8
+
+// Offsets of the stamps in a trace.
+const (
+	traceScheduled = 0
+	tracePublished = 8
+	traceEnqueued  = 16
+	traceWritten   = 24
+)
+
+// Trace holds the times (unix micro) a tick went through the stages of its
+// delivery: when the source was scheduled to produce it, when the source
+// published it, when the broker enqueued it for the client and when the
+// broker wrote it to the socket. The time it is received is measured by the
+// client.
+//
+// The trace is appended to the packet as 4 big-endian integers in that
+// order. Since traced messages carry a single packet, they are told apart
+// from untraced ones by their size.
+type Trace struct {
+	Scheduled int64
+	Published int64
+	Enqueued  int64
+	Written   int64
+}
+
+// traced returns the message of the tick for the mode with a trace stamped
+// with the enqueue time.
+func (tic *Tick) traced(mode Mode, now time.Time) []byte {
+	packet := tic.Compute(mode)
+	msg := make([]byte, len(packet), len(packet)+TraceSize)
+	copy(msg, packet)
+
+	tr := Trace{Scheduled: tic.Scheduled, Published: tic.Published, Enqueued: now.UnixMicro()}
+	return tr.Append(msg)
+}
+
+// Append appends the trace to b.
+func (tr Trace) Append(b []byte) []byte {
+	b = binary.BigEndian.AppendUint64(b, uint64(tr.Scheduled))
+	b = binary.BigEndian.AppendUint64(b, uint64(tr.Published))
+	b = binary.BigEndian.AppendUint64(b, uint64(tr.Enqueued))
+	b = binary.BigEndian.AppendUint64(b, uint64(tr.Written))
+	return b
+}
+
+// StampWritten stamps the write time in the trace of a traced message. It
+// must be called by brokers right before writing the message if tracing is
+// enabled.
+func StampWritten(msg []byte, now time.Time) {
+	if len(msg) >= TraceSize {
+		binary.BigEndian.PutUint64(msg[len(msg)-TraceSize+traceWritten:], uint64(now.UnixMicro()))
+	}
+}
+
+// ParseTrace splits a traced message into its packet and trace. It returns
+// false if the message is not traced.
+func ParseTrace(msg []byte) ([]byte, Trace, bool) {
+	n := len(msg) - TraceSize
+	if n != ltpPacketSize && n != quotePacketSize && n != fullPacketSize {
+		return msg, Trace{}, false
+	}
+
+	b := msg[n:]
+	tr := Trace{
+		Scheduled: int64(binary.BigEndian.Uint64(b[traceScheduled:])),
+		Published: int64(binary.BigEndian.Uint64(b[tracePublished:])),
+		Enqueued:  int64(binary.BigEndian.Uint64(b[traceEnqueued:])),
+		Written:   int64(binary.BigEndian.Uint64(b[traceWritten:])),
+	}
+	return msg[:n], tr, true
+}
+
+// stampPublished stamps the publish time on the ticks, defaulting the
+// scheduled time to it.
+func stampPublished(ticks []Tick, now time.Time) {
+	ts := now.UnixMicro()
+	for i := range ticks {
+		ticks[i].Published = ts
+		if ticks[i].Scheduled == 0 {
+			ticks[i].Scheduled = ts
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package ticker
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseTrace(t *testing.T) {
+	q := Quote{Instrument: 1, Seq: 3, LastPrice: 100}
+	tr := Trace{Scheduled: 1, Published: 2, Enqueued: 3, Written: 4}
+
+	tests := []struct {
+		name   string
+		packet []byte
+		traced bool
+	}{
+		{"LTP", q.AppendPacket(nil, ModeLTP), true},
+		{"Quote", q.AppendPacket(nil, ModeQuote), true},
+		{"Full", q.AppendPacket(nil, ModeFull), true},
+		{"Untraced", q.AppendPacket(nil, ModeFull), false},
+		{"Short", []byte("short"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.packet
+			if tt.traced {
+				msg = tr.Append(append([]byte(nil), tt.packet...))
+			}
+
+			packet, got, traced := ParseTrace(msg)
+			if traced != tt.traced {
+				t.Fatalf("ParseTrace() traced = %t, want %t", traced, tt.traced)
+			}
+			if !bytes.Equal(packet, tt.packet) {
+				t.Fatalf("ParseTrace() packet = %x, want %x", packet, tt.packet)
+			}
+			var want Trace
+			if tt.traced {
+				want = tr
+			}
+			if got != want {
+				t.Fatalf("ParseTrace() trace = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestStampWritten(t *testing.T) {
+	now := time.UnixMicro(1_700_000_000_000_000)
+	tick := Tick{Instrument: 1, Data: (&Quote{Instrument: 1}).AppendPacket(nil, ModeFull), Scheduled: 10, Published: 20}
+
+	tests := []struct {
+		name string
+		mode Mode
+	}{
+		{"LTP", ModeLTP},
+		{"Quote", ModeQuote},
+		{"Full", ModeFull},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tick.traced(tt.mode, now)
+			StampWritten(msg, now.Add(time.Millisecond))
+
+			packet, tr, traced := ParseTrace(msg)
+			if !traced || len(packet) != PacketSize(tt.mode) {
+				t.Fatalf("ParseTrace() = %d bytes, traced %t, want a %d bytes packet", len(packet), traced, PacketSize(tt.mode))
+			}
+			want := Trace{Scheduled: 10, Published: 20, Enqueued: now.UnixMicro(), Written: now.Add(time.Millisecond).UnixMicro()}
+			if tr != want {
+				t.Fatalf("trace = %+v, want %+v", tr, want)
+			}
+		})
+	}
+
+	// short messages are left alone.
+	short := []byte("short")
+	StampWritten(short, now)
+	if string(short) != "short" {
+		t.Fatalf("StampWritten() changed a short message to %q", short)
+	}
+}
@@ -0,0 +1,68 @@
+package ticker
+
+import (
+	"math/bits"
+	"strconv"
+	"sync/atomic"
+)
+
+// writeBuckets is the number of buckets of the histogram of frames per
+// write: up to 1, 2, 4, ..., and more than 512 frames.
+const writeBuckets = 11
+
+// WriteCounter counts the vectored writes of the clients of a broker, each
+// sending all the frames queued for a client at once. It is safe for
+// concurrent use.
+type WriteCounter struct {
+	writes atomic.Uint64
+	frames atomic.Uint64
+	bytes  atomic.Uint64
+	sizes  [writeBuckets]atomic.Uint64
+}
+
+// WriteStats are the counts of a WriteCounter.
+type WriteStats struct {
+	Writes         uint64  `json:"writes"`
+	Frames         uint64  `json:"frames"`
+	Bytes          uint64  `json:"bytes"`
+	FramesPerWrite float64 `json:"frames_per_write"`
+
+	// Histogram counts the writes by their number of frames, keyed by the
+	// upper bound of the bucket ("+Inf" for the last one).
+	Histogram map[string]uint64 `json:"histogram"`
+}
+
+// Record counts a write of the given number of frames and bytes.
+func (wc *WriteCounter) Record(frames, bytes int) {
+	wc.writes.Add(1)
+	wc.frames.Add(uint64(frames))
+	wc.bytes.Add(uint64(bytes))
+
+	i := 0
+	if frames > 1 {
+		i = min(bits.Len(uint(frames-1)), writeBuckets-1)
+	}
+	wc.sizes[i].Add(1)
+}
+
+// Stats returns the counts so far.
+func (wc *WriteCounter) Stats() WriteStats {
+	ws := WriteStats{
+		Writes:    wc.writes.Load(),
+		Frames:    wc.frames.Load(),
+		Bytes:     wc.bytes.Load(),
+		Histogram: make(map[string]uint64, writeBuckets),
+	}
+	if ws.Writes > 0 {
+		ws.FramesPerWrite = float64(ws.Frames) / float64(ws.Writes)
+	}
+
+	for i := range wc.sizes {
+		key := strconv.Itoa(1 << i)
+		if i == writeBuckets-1 {
+			key = "+Inf"
+		}
+		ws.Histogram[key] = wc.sizes[i].Load()
+	}
+	return ws
+}
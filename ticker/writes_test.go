@@ -0,0 +1,60 @@
+package ticker
+
+import "testing"
+
+func TestWriteCounterBuckets(t *testing.T) {
+	tests := []struct {
+		frames int
+		bucket string
+	}{
+		{0, "1"},
+		{1, "1"},
+		{2, "2"},
+		{3, "4"},
+		{4, "4"},
+		{5, "8"},
+		{256, "256"},
+		{257, "512"},
+		{512, "512"},
+		{513, "+Inf"},
+		{10000, "+Inf"},
+	}
+
+	for _, tt := range tests {
+		var wc WriteCounter
+		wc.Record(tt.frames, 10*tt.frames)
+
+		stats := wc.Stats()
+		if len(stats.Histogram) != writeBuckets {
+			t.Fatalf("histogram of %d buckets, want %d", len(stats.Histogram), writeBuckets)
+		}
+		for bucket, n := range stats.Histogram {
+			want := uint64(0)
+			if bucket == tt.bucket {
+				want = 1
+			}
+			if n != want {
+				t.Errorf("a write of %d frames: bucket %s = %d, want %d", tt.frames, bucket, n, want)
+			}
+		}
+	}
+}
+
+func TestWriteCounterStats(t *testing.T) {
+	var wc WriteCounter
+	if stats := wc.Stats(); stats.Writes != 0 || stats.FramesPerWrite != 0 {
+		t.Fatalf("Stats() = %+v before any write", stats)
+	}
+
+	wc.Record(1, 100)
+	wc.Record(3, 300)
+	wc.Record(8, 800)
+
+	stats := wc.Stats()
+	if stats.Writes != 3 || stats.Frames != 12 || stats.Bytes != 1200 || stats.FramesPerWrite != 4 {
+		t.Fatalf("Stats() = %+v, want 3 writes of 12 frames and 1200 bytes", stats)
+	}
+	if stats.Histogram["1"] != 1 || stats.Histogram["4"] != 1 || stats.Histogram["8"] != 1 {
+		t.Fatalf("Stats() histogram = %v", stats.Histogram)
+	}
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Admission authenticates websocket clients and limits the number of
+// connected clients. Its settings can be changed while serving. The brokers
+// guard their upgrade handlers with it (see their Options).
+type Admission struct {
+	// Conns returns the number of connected clients.
+	Conns func() int
+
+	// OverBudget, if set, reports whether the connections are over their
+	// memory budget, in which case new connections are refused.
+	OverBudget func() bool
+
+	maxConns atomic.Int64
+	tokens   atomic.Pointer[map[string]struct{}]
+}
+
+// SetMaxConns sets the maximum number of connected clients (0 for no
+// limit). Since clients are counted once connected, concurrent connection
+// attempts may overshoot the limit slightly.
+func (adm *Admission) SetMaxConns(n int) {
+	adm.maxConns.Store(int64(n))
+}
+
+// SetTokens sets the tokens that clients must present, either as a bearer
+// token or with the `token` query parameter. If empty, authentication is
+// disabled.
+func (adm *Admission) SetTokens(tokens []string) {
+	set := make(map[string]struct{}, len(tokens))
+	for _, token := range tokens {
+		set[token] = struct{}{}
+	}
+	adm.tokens.Store(&set)
+}
+
+// Middleware rejects unauthenticated requests and requests beyond the
+// connection limit or the memory budget. A nil Admission admits all
+// requests.
+func (adm *Admission) Middleware(next http.Handler) http.Handler {
+	if adm == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adm.authenticated(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if max := adm.maxConns.Load(); max > 0 && adm.Conns != nil && int64(adm.Conns()) >= max {
+			http.Error(w, "too many connections", http.StatusServiceUnavailable)
+			return
+		}
+
+		if adm.OverBudget != nil && adm.OverBudget() {
+			http.Error(w, "memory budget exceeded", http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (adm *Admission) authenticated(r *http.Request) bool {
+	tokens := adm.tokens.Load()
+	if tokens == nil || len(*tokens) == 0 {
+		return true
+	}
+
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	_, found := (*tokens)[token]
+	return token != "" && found
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdmission(t *testing.T) {
+	tests := []struct {
+		name       string
+		adm        func() *Admission
+		target     string
+		auth       string
+		wantStatus int
+	}{
+		{
+			name:       "Nil",
+			adm:        func() *Admission { return nil },
+			target:     "/",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "NoTokens",
+			adm:        func() *Admission { return &Admission{} },
+			target:     "/",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "QueryToken",
+			adm:        withTokens("a"),
+			target:     "/?token=a",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "BearerToken",
+			adm:        withTokens("a"),
+			target:     "/",
+			auth:       "Bearer a",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "WrongToken",
+			adm:        withTokens("a"),
+			target:     "/?token=b",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "MissingToken",
+			adm:        withTokens("a"),
+			target:     "/",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "UnderMaxConns",
+			adm: func() *Admission {
+				adm := &Admission{Conns: func() int { return 1 }}
+				adm.SetMaxConns(2)
+				return adm
+			},
+			target:     "/",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "MaxConns",
+			adm: func() *Admission {
+				adm := &Admission{Conns: func() int { return 2 }}
+				adm.SetMaxConns(2)
+				return adm
+			},
+			target:     "/",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "OverBudget",
+			adm: func() *Admission {
+				return &Admission{OverBudget: func() bool { return true }}
+			},
+			target:     "/",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+
+			w := httptest.NewRecorder()
+			tt.adm().Middleware(ok).ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func withTokens(tokens ...string) func() *Admission {
+	return func() *Admission {
+		adm := &Admission{}
+		adm.SetTokens(tokens)
+		return adm
+	}
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// subBucketBits sets the precision of the histogram: values are recorded
+// with a relative error below 1/2^(subBucketBits-1), i.e., < 1%.
+const subBucketBits = 8
+
+const (
+	subBuckets  = 1 << subBucketBits
+	halfBuckets = subBuckets / 2
+	numBuckets  = subBuckets + (64-subBucketBits)*halfBuckets
+)
+
+// Histogram is an HDR-style histogram of latencies in microseconds: values
+// up to 2^subBucketBits are counted exactly, and larger ones in log-linear
+// buckets of bounded relative error. It is safe for concurrent use, and
+// recording does not allocate or lock.
+type Histogram struct {
+	counts [numBuckets]atomic.Int64
+	total  atomic.Int64
+	sum    atomic.Int64
+	min    atomic.Int64
+	max    atomic.Int64
+}
+
+// NewHistogram returns an empty histogram.
+func NewHistogram() *Histogram {
+	h := &Histogram{}
+	h.min.Store(math.MaxInt64)
+	return h
+}
+
+// Record records a latency. Negative latencies (e.g., from clock skew) are
+// recorded as zero.
+func (h *Histogram) Record(d time.Duration) {
+	v := d.Microseconds()
+	if v < 0 {
+		v = 0
+	}
+
+	h.counts[bucketOf(v)].Add(1)
+	h.total.Add(1)
+	h.sum.Add(v)
+	for cur := h.min.Load(); v < cur && !h.min.CompareAndSwap(cur, v); cur = h.min.Load() {
+	}
+	for cur := h.max.Load(); v > cur && !h.max.CompareAndSwap(cur, v); cur = h.max.Load() {
+	}
+}
+
+// Snapshot returns a copy of the current state of the histogram.
+func (h *Histogram) Snapshot() *HistogramSnapshot {
+	s := &HistogramSnapshot{
+		counts: make([]int64, numBuckets),
+		Count:  h.total.Load(),
+		Sum:    h.sum.Load(),
+		Min:    h.min.Load(),
+		Max:    h.max.Load(),
+	}
+	for i := range h.counts {
+		s.counts[i] = h.counts[i].Load()
+	}
+	if s.Count == 0 {
+		s.Min = 0
+	}
+	return s
+}
+
+// HistogramSnapshot is a point-in-time copy of a Histogram. Values are in
+// microseconds.
+type HistogramSnapshot struct {
+	counts []int64
+
+	Count int64
+	Sum   int64
+	Min   int64
+	Max   int64
+}
+
+// Sub returns the values recorded since the previous snapshot. Since the
+// extremes of the interval are not known, Min and Max are estimated from
+// the buckets.
+func (s *HistogramSnapshot) Sub(prev *HistogramSnapshot) *HistogramSnapshot {
+	d := &HistogramSnapshot{
+		counts: make([]int64, numBuckets),
+		Count:  s.Count - prev.Count,
+		Sum:    s.Sum - prev.Sum,
+	}
+
+	first, last := -1, -1
+	for i := range s.counts {
+		d.counts[i] = s.counts[i] - prev.counts[i]
+		if d.counts[i] > 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first >= 0 {
+		d.Min = lowestOf(first)
+		d.Max = min(highestOf(last), s.Max)
+	}
+	return d
+}
+
+// Mean returns the mean of the recorded values.
+func (s *HistogramSnapshot) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Sum) / float64(s.Count)
+}
+
+// ValueAt returns the value at the percentile (0-100), i.e., the highest
+// value equivalent to the value below which the percentile of recorded
+// values fall.
+func (s *HistogramSnapshot) ValueAt(percentile float64) int64 {
+	if s.Count == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(percentile / 100 * float64(s.Count)))
+	rank = max(rank, 1)
+
+	var seen int64
+	for i, n := range s.counts {
+		seen += n
+		if seen >= rank {
+			return min(highestOf(i), s.Max)
+		}
+	}
+	return s.Max
+}
+
+func bucketOf(v int64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBucketBits
+	return subBuckets + (shift-1)*halfBuckets + int(v>>shift) - halfBuckets
+}
+
+func lowestOf(i int) int64 {
+	if i < subBuckets {
+		return int64(i)
+	}
+	shift := (i-subBuckets)/halfBuckets + 1
+	return int64((i-subBuckets)%halfBuckets+halfBuckets) << shift
+}
+
+func highestOf(i int) int64 {
+	if i < subBuckets {
+		return int64(i)
+	}
+	shift := (i-subBuckets)/halfBuckets + 1
+	return lowestOf(i) + 1<<shift - 1
+}
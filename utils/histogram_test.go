@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// durations returns the values from first to last by step, in microseconds.
+func durations(first, last, step int64) []time.Duration {
+	var ds []time.Duration
+	for v := first; v <= last; v += step {
+		ds = append(ds, time.Duration(v)*time.Microsecond)
+	}
+	return ds
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	type percentile struct {
+		p    float64
+		want int64
+	}
+
+	tests := []struct {
+		name        string
+		values      []time.Duration
+		percentiles []percentile
+		min, max    int64
+	}{
+		{
+			name:        "Empty",
+			percentiles: []percentile{{50, 0}, {100, 0}},
+		},
+		{
+			name:        "Single",
+			values:      durations(42, 42, 1),
+			percentiles: []percentile{{0, 42}, {50, 42}, {100, 42}},
+			min:         42, max: 42,
+		},
+		{
+			name:        "Exact",
+			values:      durations(1, 100, 1),
+			percentiles: []percentile{{0, 1}, {50, 50}, {90, 90}, {99, 99}, {100, 100}},
+			min:         1, max: 100,
+		},
+		{
+			name:        "Negative",
+			values:      []time.Duration{-time.Millisecond, 0, time.Microsecond},
+			percentiles: []percentile{{50, 0}, {100, 1}},
+			min:         0, max: 1,
+		},
+		{
+			name:        "Large",
+			values:      durations(1000, 1_000_000, 1000),
+			percentiles: []percentile{{50, 500_000}, {90, 900_000}, {99, 990_000}, {100, 1_000_000}},
+			min:         1000, max: 1_000_000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHistogram()
+			for _, d := range tt.values {
+				h.Record(d)
+			}
+
+			s := h.Snapshot()
+			if s.Count != int64(len(tt.values)) || s.Min != tt.min || s.Max != tt.max {
+				t.Fatalf("snapshot of %d values in [%d, %d], want %d in [%d, %d]",
+					s.Count, s.Min, s.Max, len(tt.values), tt.min, tt.max)
+			}
+
+			// values are within the precision of the histogram.
+			for _, p := range tt.percentiles {
+				got := s.ValueAt(p.p)
+				if got < p.want || float64(got-p.want) > float64(p.want)/100 {
+					t.Errorf("ValueAt(%g) = %d, want %d within 1%%", p.p, got, p.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHistogramSub(t *testing.T) {
+	h := NewHistogram()
+	for _, d := range durations(1, 100, 1) {
+		h.Record(d)
+	}
+	prev := h.Snapshot()
+	for _, d := range durations(1000, 2000, 10) {
+		h.Record(d)
+	}
+
+	d := h.Snapshot().Sub(prev)
+	if d.Count != 101 || d.Mean() != 1500 {
+		t.Fatalf("Sub() = %d values of mean %g, want 101 of mean 1500", d.Count, d.Mean())
+	}
+	if d.Min > 1000 || d.Min < 990 || d.Max != 2000 {
+		t.Fatalf("Sub() in [%d, %d], want [1000, 2000] within 1%%", d.Min, d.Max)
+	}
+	if got := d.ValueAt(50); got < 1500 || got > 1515 {
+		t.Fatalf("Sub() ValueAt(50) = %d, want 1500 within 1%%", got)
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	for _, v := range []int64{0, 1, 255, 256, 257, 511, 512, 1000, 123_456, 1 << 40, 1<<62 + 12345} {
+		i := bucketOf(v)
+		lo, hi := lowestOf(i), highestOf(i)
+		if v < lo || v > hi {
+			t.Errorf("%d is out of its bucket [%d, %d]", v, lo, hi)
+		}
+		if float64(hi-lo) > float64(lo)/100 {
+			t.Errorf("bucket [%d, %d] of %d is wider than 1%%", lo, hi, v)
+		}
+	}
+}